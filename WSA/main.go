@@ -1,27 +1,50 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io/fs"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"WSA/pkg/assistant"
+	"WSA/pkg/fakellm"
 	"WSA/pkg/goalengine"
+	"WSA/pkg/llm"
 	"WSA/pkg/logging"
 	"WSA/pkg/settings"
 	"WSA/pkg/types"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "fake-llm" {
+		fakeLLM(os.Args[2:])
+		return
+	}
+
 	logging.SetupLogging()
 
-	// Ensure the models are loaded
+	// Goals that were running when the backend last stopped can be resumed
+	// or abandoned through the /goals endpoints
+	if interrupted, err := logging.MarkInterruptedGoals(); err != nil {
+		log.Printf("Failed to check for interrupted goals: %v", err)
+	} else if interrupted > 0 {
+		log.Printf("%d goals were interrupted; list them at /goals?status=Interrupted to resume or abandon them", interrupted)
+	}
+
+	// Ensure the models are loaded. The server still starts without them so
+	// they can be pulled later through /pull.
 	err := assistant.PullModel("")
 	if err != nil {
-		log.Fatalf("Failed to load models: %v", err)
+		log.Printf("Failed to load models: %v", err)
 	}
 
 	// Generate system index if it doesn't exist
@@ -35,20 +58,37 @@ func main() {
 	}
 
 	// Load or initialize system settings
-	_, err = settings.LoadSettings()
+	settingsData, err := settings.LoadSettings()
 	if err != nil {
 		fmt.Printf("Failed to load settings: %v\n", err)
 		log.Printf("Failed to load settings: %v\n", err)
 		return
 	}
+	settingsData.ApplyLLMEnvironment()
+	configureResponseCache(settingsData)
+	if settingsData.PreloadModels {
+		go assistant.PreloadModels(settingsData.KeepAlive)
+	}
 
 	// Start HTTP server
 	http.HandleFunc("/execute", executeHandler)
+	http.HandleFunc("/execute/stream", executeStreamHandler)
 	http.HandleFunc("/settings", settingsHandler)
 	http.HandleFunc("/models", modelsHandler)
+	http.HandleFunc("/models/loaded", loadedModelsHandler)
+	http.HandleFunc("/cache", cacheHandler)
 	http.HandleFunc("/map-system", mapSystemHandler)
+	http.HandleFunc("/pull", pullHandler)
+	http.HandleFunc("/pull/progress", pullProgressHandler)
 	http.HandleFunc("/load-model", loadModelHandler)
 	http.HandleFunc("/unload-model", unloadModelHandler)
+	http.HandleFunc("/delete-model", deleteModelHandler)
+	http.HandleFunc("/copy-model", copyModelHandler)
+	http.HandleFunc("/create-model", createModelHandler)
+	http.HandleFunc("/goals", goalsHandler)
+	http.HandleFunc("/goals/resume", resumeGoalHandler)
+	http.HandleFunc("/goals/resume/stream", resumeGoalStreamHandler)
+	http.HandleFunc("/goals/abandon", abandonGoalHandler)
 	fmt.Println("Server started at http://localhost:8080")
 	log.Println("Server started at http://localhost:8080")
 	err = http.ListenAndServe(":8080", nil)
@@ -57,30 +97,275 @@ func main() {
 	}
 }
 
+// fakeLLM runs `wsa fake-llm`, an offline stand-in for the Ollama server
+// that answers from a scenario file. Point the backend at it with
+// LLM_API_ENDPOINT.
+func fakeLLM(args []string) {
+	flags := flag.NewFlagSet("fake-llm", flag.ExitOnError)
+	addr := flags.String("addr", "127.0.0.1:11434", "address to listen on")
+	scenarioPath := flags.String("scenario", "demo", "scenario file, or the name of a built-in scenario")
+	flags.Parse(args)
+
+	scenario, err := fakellm.LoadScenario(*scenarioPath)
+	if err != nil {
+		log.Fatalf("Failed to load scenario: %v", err)
+	}
+
+	fmt.Printf("Fake Ollama serving scenario %s at http://%s\n", *scenarioPath, *addr)
+	if err := http.ListenAndServe(*addr, fakellm.NewServer(scenario)); err != nil {
+		log.Fatalf("Failed to start fake Ollama server: %v", err)
+	}
+}
+
+// executeRequest is the body accepted by /execute and /execute/stream
+// and applies to that request only.
+type executeRequest struct {
+	Goal      string `json:"goal"`
+	UseVision bool   `json:"useVision"`
+	Model     string `json:"model"` // Tried first for the planner, commander and verifier roles
+	Provider  string `json:"provider"`
+	Endpoint  string `json:"endpoint"`  // Base URL of the provider's server
+	AgentMode *bool  `json:"agentMode"` // Defaults to the agentMode setting
+	Verify    *bool  `json:"verify"`    // Defaults to the verifyTasks setting
+	Samples   *int   `json:"samples"`   // Command candidates voted on per task; defaults to the commandSamples setting
+	Parallel  *int   `json:"parallel"`  // Tasks run at the same time; defaults to the parallelTasks setting
+	// MaxReplans is how many times the goal is replanned after tasks fail;
+	// defaults to the maxReplans setting
+	MaxReplans *int `json:"maxReplans"`
+	// Roles names models tried before those of the modelRoles setting
+	Roles settings.ModelRoles `json:"roles"`
+	// Limits; zero values keep the defaults
+	ContextWindow int    `json:"contextWindow"` // Tokens budgeted per model call
+	Timeout       string `json:"timeout"`       // Go duration bounding a single model call
+	MaxRetries    int    `json:"maxRetries"`    // Attempts per task
+	// Record saves the model traffic of the goal to a cassette in cassettesDir
+	Record bool `json:"record,omitempty"`
+	// Replay names a recorded cassette in cassettesDir, such as
+	// "20240102-150405.000.json". The goal is run again with the
+	// recorded request's options, one task at a time so the model calls come
+	// in a reproducible order, and the cassette answers every model call;
+	// the other fields are ignored.
+	Replay string `json:"replay,omitempty"`
+
+	cassette *llm.Cassette
+}
+
+// cassettesDir is where recorded cassettes are saved.
+const cassettesDir = "cassettes"
+
+// defaultParallelTasks is how many independent tasks run at the same time
+// when neither the request nor the parallelTasks setting says. Planners do
+// not always list the dependencies between tasks, so running them in
+// parallel is opt-in.
+const defaultParallelTasks = 1
+
+// defaultMaxReplans is how many times a goal is replanned after tasks fail
+// when neither the request nor the maxReplans setting says.
+const defaultMaxReplans = 2
+
+// options returns the llm.Options of the request.
+func (req executeRequest) options() (llm.Options, error) {
+	opts := llm.Options{
+		Provider:      req.Provider,
+		Endpoint:      req.Endpoint,
+		Model:         req.Model,
+		Roles:         req.Roles.Map(),
+		ContextWindow: req.ContextWindow,
+	}
+	if req.Timeout != "" {
+		timeout, err := time.ParseDuration(req.Timeout)
+		if err != nil || timeout <= 0 {
+			return opts, fmt.Errorf("invalid timeout %q", req.Timeout)
+		}
+		opts.Timeout = timeout
+	}
+	return opts, nil
+}
+
+// decodeExecuteRequest reads and validates the body of /execute and /execute/stream.
+func decodeExecuteRequest(r *http.Request) (executeRequest, error) {
+	var req executeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return req, fmt.Errorf("Invalid request body")
+	}
+
+	if req.Replay != "" {
+		replay := req.Replay
+		if filepath.Base(replay) != replay || strings.Contains(replay, "..") {
+			return req, fmt.Errorf("Invalid cassette name %q", replay)
+		}
+		cassette, err := llm.LoadCassette(filepath.Join(cassettesDir, replay))
+		if err != nil {
+			// The error names the path and may quote the file, so only log it
+			log.Printf("Failed to load cassette %s: %v", replay, err)
+			if errors.Is(err, fs.ErrNotExist) {
+				return req, fmt.Errorf("Cassette %q not found", replay)
+			}
+			return req, fmt.Errorf("Cassette %q could not be loaded", replay)
+		}
+		req = executeRequest{}
+		if err := json.Unmarshal(cassette.Request, &req); err != nil {
+			log.Printf("Cassette %s has no valid request: %v", replay, err)
+			return req, fmt.Errorf("Cassette %q has no valid request", replay)
+		}
+		req.Replay, req.Record, req.cassette = replay, false, cassette
+	}
+
+	req.Goal = strings.TrimSpace(req.Goal)
+	if req.Goal == "" {
+		return req, fmt.Errorf("Goal cannot be empty")
+	}
+	if _, err := req.options(); err != nil {
+		return req, err
+	}
+	if req.ContextWindow < 0 || req.MaxRetries < 0 || (req.Samples != nil && *req.Samples < 0) || (req.Parallel != nil && *req.Parallel < 0) || (req.MaxReplans != nil && *req.MaxReplans < 0) {
+		return req, fmt.Errorf("Limits cannot be negative")
+	}
+	if req.Samples != nil && *req.Samples > assistant.MaxCommandSamples {
+		return req, fmt.Errorf("At most %d samples are allowed", assistant.MaxCommandSamples)
+	}
+	return req, nil
+}
+
 // Handler for executing commands
 func executeHandler(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		Goal      string `json:"goal"`
-		UseVision bool   `json:"useVision"`
-		Model     string `json:"model"`
+	req, err := decodeExecuteRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	goal, err := runGoal(r.Context(), req, nil)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to generate tasks: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Prepare response
+	json.NewEncoder(w).Encode(newExecuteResponse(goal))
+}
+
+// executeResponse is the result of /execute and the payload of the final
+// /execute/stream event.
+type executeResponse struct {
+	Message  string          `json:"message"`
+	GoalID   int64           `json:"goalId,omitempty"` // Stored goal, for /goals/resume
+	Outcome  string          `json:"outcome"`          // Succeeded, PartiallySucceeded, Failed or Cancelled
+	Logs     []string        `json:"logs"`
+	Plans    []planSummary   `json:"plans"`              // The first plan and every replan
+	Thinking *thinkingTraces `json:"thinking,omitempty"` // Only with the includeThinking setting
+}
+
+// planSummary is a plan of the goal with the final state of its tasks.
+type planSummary struct {
+	Reason string        `json:"reason,omitempty"` // Failures that led to a replan
+	Tasks  []taskSummary `json:"tasks"`
+}
+
+type taskSummary struct {
+	Task     string `json:"task"`
+	Status   string `json:"status"`
+	Attempts int    `json:"attempts"`
+	Feedback string `json:"feedback,omitempty"`
+}
+
+// thinkingTraces is the model reasoning behind a goal's plan and tasks.
+type thinkingTraces struct {
+	Plan  string              `json:"plan,omitempty"`
+	Tasks []taskThinkingTrace `json:"tasks"`
+}
+
+type taskThinkingTrace struct {
+	Task     string `json:"task"`
+	Thinking string `json:"thinking,omitempty"`
+}
+
+func newExecuteResponse(goal *goalengine.Goal) executeResponse {
+	response := executeResponse{
+		Message: "Goal processed successfully",
+		GoalID:  goal.ID,
+		Outcome: goal.Status.String(),
+		Logs:    goal.Logs,
+		Plans:   []planSummary{},
+	}
+	for _, plan := range goal.Plans {
+		summary := planSummary{Reason: plan.Reason, Tasks: []taskSummary{}}
+		for _, task := range plan.Tasks {
+			summary.Tasks = append(summary.Tasks, taskSummary{Task: task.Description, Status: task.Status.String(), Attempts: task.Attempt, Feedback: task.Feedback})
+		}
+		response.Plans = append(response.Plans, summary)
+	}
+
+	if settingsData, err := settings.LoadSettings(); err == nil && settingsData.IncludeThinking {
+		traces := &thinkingTraces{Plan: goal.PlanThinking, Tasks: []taskThinkingTrace{}}
+		for _, task := range goal.Tasks {
+			traces.Tasks = append(traces.Tasks, taskThinkingTrace{Task: task.Description, Thinking: task.Thinking})
+		}
+		response.Thinking = traces
+	}
+	return response
+}
+
+// Handler for executing commands while streaming progress as Server-Sent Events.
+// Clients receive thinking, plan, nlResponse, log and transition events
+// followed by a final done (or error) event carrying the same payload as
+// /execute. A transition event reports each change of state of the goal or
+// one of its tasks. Disconnecting cancels the goal.
+func executeStreamHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
-	err := json.NewDecoder(r.Body).Decode(&req)
+
+	req, err := decodeExecuteRequest(r)
 	if err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	onEvent, ok := newSSEWriter(w)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
 		return
 	}
 
-	goalDescription := strings.TrimSpace(req.Goal)
-	if goalDescription == "" {
-		http.Error(w, "Goal cannot be empty", http.StatusBadRequest)
+	goal, err := runGoal(r.Context(), req, onEvent)
+	if err != nil {
+		onEvent(types.StreamEvent{Type: "error", Data: fmt.Sprintf("Failed to generate tasks: %v", err)})
 		return
 	}
 
-	// Set the model for this request
+	response, _ := json.Marshal(newExecuteResponse(goal))
+	onEvent(types.StreamEvent{Type: "done", Data: string(response)})
+}
+
+// runGoal plans and executes req, passing streaming progress to onEvent if set.
+// The request's models, endpoint and limits travel with the context passed
+// down to every model call, so concurrent requests do not affect each other.
+// Cancelling ctx, e.g. by disconnecting, cancels the goal.
+func runGoal(ctx context.Context, req executeRequest, onEvent assistant.EventFunc) (*goalengine.Goal, error) {
+	goalDescription := req.Goal
+
+	opts, err := req.options()
+	if err != nil {
+		return nil, err
+	}
+	ctx = llm.WithOptions(ctx, opts)
+	var cassette *llm.Cassette
+	switch {
+	case req.cassette != nil:
+		log.Printf("Replaying model traffic from %s for request: %s", req.Replay, goalDescription)
+		ctx = llm.Replay(ctx, req.cassette)
+	case req.Record:
+		cassette = llm.NewCassette()
+		ctx = llm.Record(ctx, cassette)
+	}
 	if req.Model != "" {
-		os.Setenv("LLM_MODEL", req.Model)
 		log.Printf("Using model: %s for request: %s", req.Model, goalDescription)
 	}
+	if req.Provider != "" {
+		log.Printf("Using provider: %s for request: %s", req.Provider, goalDescription)
+	}
 
 	// Process the goal using the internal goal engine
 	log.Printf("Processing goal: '%s'", goalDescription)
@@ -92,31 +377,269 @@ func executeHandler(w http.ResponseWriter, r *http.Request) {
 		CurrentState: &goalengine.State{}, // Initialize with current state
 		DesiredState: &goalengine.State{}, // Define desired state
 		Logs:         []string{},
-		UseVision:    req.UseVision,
+	}
+	configureGoal(goal, req)
+	onEvent = watchGoal(goal, onEvent)
+
+	// Save the recording even when planning fails, with the options resolved
+	// from the settings so a replay runs the goal the same way
+	if cassette != nil {
+		cassette.Request, _ = json.Marshal(resolvedRequest(req, goal))
+		defer saveCassette(cassette, onEvent)
 	}
 
 	// Generate tasks from the high-level goal
-	tasks, err := assistant.GenerateTasksFromGoal(goal.Description)
+	plan, err := assistant.GenerateTasksFromGoalStream(ctx, goal.Description, onEvent)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to generate tasks: %v", err), http.StatusInternalServerError)
-		return
+		goal.Finish(ctx.Err() != nil, err.Error())
+		return nil, err
+	}
+	goal.AddPlan(&goalengine.Plan{Tasks: plan.Tasks, Thinking: plan.Thinking, PromptVersion: plan.PromptVersion})
+
+	// Store the goal so it can be resumed if the backend stops. Replays are
+	// not stored, as resuming them would call the real models.
+	if req.cassette == nil {
+		request, _ := json.Marshal(resolvedRequest(req, goal))
+		if err := logging.CreateGoal(goal, request); err != nil {
+			log.Printf("Failed to store goal '%s': %v", goal.Description, err)
+		}
 	}
-	goal.Tasks = tasks
 
 	var chatHistory []types.PromptMessage // Initialize chat history
 
 	// Process the goal
-	processGoal(goal, &chatHistory)
+	processGoal(ctx, goal, &chatHistory, onEvent)
 
-	// Prepare response
-	response := struct {
-		Message string   `json:"message"`
-		Logs    []string `json:"logs"`
-	}{
-		Message: "Goal processed successfully",
-		Logs:    goal.Logs,
+	return goal, nil
+}
+
+// configureGoal sets the options of goal from req, falling back to the
+// settings for those req leaves out.
+func configureGoal(goal *goalengine.Goal, req executeRequest) {
+	settingsData, err := settings.LoadSettings()
+	if err != nil {
+		log.Printf("Failed to load settings: %v", err)
+		settingsData = &settings.Settings{}
 	}
-	json.NewEncoder(w).Encode(response)
+
+	goal.UseVision = req.UseVision
+	goal.AgentMode = settingsData.AgentMode
+	goal.Verify = settingsData.VerifyTasks
+	goal.Samples = settingsData.CommandSamples
+	goal.Parallel = settingsData.ParallelTasks
+	goal.MaxRetries = req.MaxRetries
+	goal.MaxReplans = settingsData.MaxReplans
+	if req.AgentMode != nil {
+		goal.AgentMode = *req.AgentMode
+	}
+	if req.Verify != nil {
+		goal.Verify = *req.Verify
+	}
+	if req.Samples != nil {
+		goal.Samples = *req.Samples
+	}
+	if req.Parallel != nil {
+		goal.Parallel = *req.Parallel
+	}
+	if goal.Parallel == 0 {
+		goal.Parallel = defaultParallelTasks
+	}
+	if req.cassette != nil {
+		// Replays run one task at a time, so model calls come in a
+		// reproducible order
+		goal.Parallel = 1
+	}
+	switch {
+	case req.MaxReplans != nil:
+		goal.MaxReplans = *req.MaxReplans
+	case goal.MaxReplans == 0:
+		goal.MaxReplans = defaultMaxReplans
+	case goal.MaxReplans < 0:
+		goal.MaxReplans = 0
+	}
+	if settingsData.PostconditionTimeout != "" {
+		timeout, err := time.ParseDuration(settingsData.PostconditionTimeout)
+		if err != nil || timeout <= 0 {
+			log.Printf("Invalid postconditionTimeout %q, using %s", settingsData.PostconditionTimeout, assistant.DefaultPostconditionTimeout)
+			timeout = assistant.DefaultPostconditionTimeout
+		}
+		goal.PostconditionTimeout = timeout
+	}
+}
+
+// resolvedRequest returns req with the options taken from the settings
+// filled in from goal, so that running it again runs the goal the same way.
+func resolvedRequest(req executeRequest, goal *goalengine.Goal) executeRequest {
+	resolved := req
+	resolved.Record = false
+	resolved.AgentMode, resolved.Verify, resolved.Samples = &goal.AgentMode, &goal.Verify, &goal.Samples
+	resolved.Parallel, resolved.MaxReplans = &goal.Parallel, &goal.MaxReplans
+	return resolved
+}
+
+// watchGoal returns the EventFunc to pass down while goal runs: it records
+// events in the goal logs as goalEvents does, and reports every change of
+// state of the goal and its tasks as a "transition" event.
+func watchGoal(goal *goalengine.Goal, onEvent assistant.EventFunc) assistant.EventFunc {
+	// Record repair attempts in the goal logs alongside streaming to the client
+	onEvent = goalEvents(goal, onEvent)
+	goal.OnTransition = func(t goalengine.Transition) {
+		data, _ := json.Marshal(t)
+		onEvent(types.StreamEvent{Type: "transition", Task: t.Task, Data: string(data)})
+	}
+	// Store logs as they come, so they survive the backend stopping
+	goal.OnLog = func(msg string) {
+		logging.AppendGoalLog(goal, msg)
+	}
+	return onEvent
+}
+
+// resuming holds the IDs of the stored goals being resumed.
+var (
+	resumingMu sync.Mutex
+	resuming   = map[int64]bool{}
+)
+
+// Errors of resumeGoal and abandonGoal for goals that cannot be changed in
+// their current state.
+var (
+	errGoalResuming       = errors.New("goal is being resumed")
+	errGoalNotInterrupted = errors.New("goal is not interrupted")
+)
+
+// goalErrorStatus returns the HTTP status for an error of resumeGoal or
+// abandonGoal.
+func goalErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, logging.ErrGoalNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errGoalResuming), errors.Is(err, errGoalNotInterrupted):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// resumeGoal runs the interrupted goal stored under id again with the
+// options of the request that started it. Completed tasks are kept, and the
+// chat history is restored. Cancelling ctx cancels the goal.
+func resumeGoal(ctx context.Context, id int64, onEvent assistant.EventFunc) (*goalengine.Goal, error) {
+	resumingMu.Lock()
+	if resuming[id] {
+		resumingMu.Unlock()
+		return nil, fmt.Errorf("Goal %d: %w", id, errGoalResuming)
+	}
+	resuming[id] = true
+	resumingMu.Unlock()
+	defer func() {
+		resumingMu.Lock()
+		delete(resuming, id)
+		resumingMu.Unlock()
+	}()
+
+	stored, err := logging.LoadGoal(id)
+	if err != nil {
+		return nil, err
+	}
+	goal := stored.Goal
+	if goal.Status != goalengine.GoalInterrupted {
+		return nil, fmt.Errorf("Goal %d is %s: %w", id, goal.Status, errGoalNotInterrupted)
+	}
+	var req executeRequest
+	if err := json.Unmarshal(stored.Request, &req); err != nil {
+		return nil, fmt.Errorf("Goal %d has no valid request: %v", id, err)
+	}
+	opts, err := req.options()
+	if err != nil {
+		return nil, err
+	}
+	ctx = llm.WithOptions(ctx, opts)
+
+	log.Printf("Resuming goal %d: '%s'", id, goal.Description)
+	configureGoal(goal, req)
+	onEvent = watchGoal(goal, onEvent)
+	addLog(goal, onEvent, fmt.Sprintf("Resuming goal %d after an interruption", id))
+
+	chatHistory := stored.ChatHistory
+	processGoal(ctx, goal, &chatHistory, onEvent)
+	return goal, nil
+}
+
+// abandonGoal cancels the interrupted goal stored under id.
+func abandonGoal(id int64) (*goalengine.Goal, error) {
+	resumingMu.Lock()
+	defer resumingMu.Unlock()
+	if resuming[id] {
+		return nil, fmt.Errorf("Goal %d: %w", id, errGoalResuming)
+	}
+
+	stored, err := logging.LoadGoal(id)
+	if err != nil {
+		return nil, err
+	}
+	goal := stored.Goal
+	if goal.Status != goalengine.GoalInterrupted {
+		return nil, fmt.Errorf("Goal %d is %s: %w", id, goal.Status, errGoalNotInterrupted)
+	}
+	goal.Finish(true, "abandoned")
+	goal.AddLog("Goal abandoned after an interruption")
+	if err := logging.SaveGoal(goal); err != nil {
+		return nil, err
+	}
+	return goal, nil
+}
+
+// saveCassette writes a recorded cassette to cassettesDir and reports its
+// path as a "cassette" event.
+func saveCassette(cassette *llm.Cassette, onEvent assistant.EventFunc) {
+	path := filepath.Join(cassettesDir, time.Now().Format("20060102-150405.000")+".json")
+	if err := cassette.Save(path); err != nil {
+		log.Printf("Failed to save cassette: %v", err)
+		return
+	}
+	message := fmt.Sprintf("Recorded %d model calls to cassette %s", len(cassette.Interactions), filepath.Base(path))
+	log.Print(message)
+	onEvent(types.StreamEvent{Type: "cassette", Data: message})
+}
+
+// goalEvents returns an EventFunc that records parse repair attempts,
+// per-request token reports, response cache hits, model reroutes, verdicts,
+// command votes, saved cassettes, postcondition checks and replans in the
+// goal logs and forwards every event to onEvent if set.
+func goalEvents(goal *goalengine.Goal, onEvent assistant.EventFunc) assistant.EventFunc {
+	return func(event types.StreamEvent) {
+		if event.Type == "repair" || event.Type == "tokens" || event.Type == "cache" || event.Type == "route" || event.Type == "verify" || event.Type == "consensus" || event.Type == "cassette" || event.Type == "postcondition" || event.Type == "replan" {
+			goal.AddLog(event.Data)
+		}
+		if onEvent != nil {
+			onEvent(event)
+		}
+	}
+}
+
+// newSSEWriter returns an EventFunc that writes each event to w as a
+// Server-Sent Event and flushes it immediately.
+func newSSEWriter(w http.ResponseWriter) (assistant.EventFunc, bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, false
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	flusher.Flush()
+
+	var mu sync.Mutex
+	return func(event types.StreamEvent) {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+		flusher.Flush()
+	}, true
 }
 
 // Handler for getting and setting settings
@@ -129,7 +652,7 @@ func settingsHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, fmt.Sprintf("Failed to load settings: %v", err), http.StatusInternalServerError)
 			return
 		}
-		json.NewEncoder(w).Encode(settingsData)
+		json.NewEncoder(w).Encode(settingsData.Redacted())
 	case http.MethodPost:
 		// Update settings
 		var settingsData settings.Settings
@@ -138,68 +661,228 @@ func settingsHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Invalid request body", http.StatusBadRequest)
 			return
 		}
+		// Clients never see the API key, so an empty one keeps the stored key
+		if settingsData.OpenAIAPIKey == "" {
+			if stored, err := settings.LoadSettings(); err == nil {
+				settingsData.OpenAIAPIKey = stored.OpenAIAPIKey
+			}
+		}
 		err = settingsData.SaveSettings()
 		if err != nil {
 			http.Error(w, fmt.Sprintf("Failed to save settings: %v", err), http.StatusInternalServerError)
 			return
 		}
-		json.NewEncoder(w).Encode(settingsData)
+		settingsData.ApplyLLMEnvironment()
+		configureResponseCache(&settingsData)
+		json.NewEncoder(w).Encode(settingsData.Redacted())
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// configureResponseCache enables the LLM response cache in app.db when the
+// responseCache setting is on, and disables it otherwise.
+func configureResponseCache(s *settings.Settings) {
+	if !s.ResponseCache {
+		llm.SetCache(nil)
+		return
+	}
+
+	ttl := 24 * time.Hour
+	if s.ResponseCacheTTL != "" {
+		d, err := time.ParseDuration(s.ResponseCacheTTL)
+		if err != nil {
+			log.Printf("Invalid responseCacheTTL %q, using %s: %v", s.ResponseCacheTTL, ttl, err)
+		} else {
+			ttl = d
+		}
+	}
+	maxEntries := 500
+	if s.ResponseCacheMaxEntries > 0 {
+		maxEntries = s.ResponseCacheMaxEntries
+	}
+	llm.SetCache(&logging.ResponseCache{TTL: ttl, MaxEntries: maxEntries})
+}
+
+// Handler for inspecting (GET) and clearing (DELETE) the LLM response cache
+func cacheHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		entries, err := logging.CacheEntries()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to read response cache: %v", err), http.StatusInternalServerError)
+			return
+		}
+		enabled := false
+		if settingsData, err := settings.LoadSettings(); err == nil {
+			enabled = settingsData.ResponseCache
+		}
+
+		response := struct {
+			Enabled bool                 `json:"enabled"`
+			Count   int                  `json:"count"`
+			Entries []logging.CacheEntry `json:"entries"`
+		}{
+			Enabled: enabled,
+			Count:   len(entries),
+			Entries: entries,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	case http.MethodDelete:
+		removed, err := logging.ClearResponseCache()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to clear response cache: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		response := struct {
+			Message string `json:"message"`
+			Removed int64  `json:"removed"`
+		}{
+			Message: "Response cache cleared",
+			Removed: removed,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
-func processGoal(goal *goalengine.Goal, chatHistory *[]types.PromptMessage) {
+func processGoal(ctx context.Context, goal *goalengine.Goal, chatHistory *[]types.PromptMessage, onEvent assistant.EventFunc) {
 	if len(goal.Tasks) == 0 {
 		log.Println("No tasks generated. Exiting goal processing.")
-		goal.Logs = append(goal.Logs, "No tasks generated. Exiting goal processing.")
+		addLog(goal, onEvent, "No tasks generated. Exiting goal processing.")
+		goal.Finish(false, "no tasks generated")
+		saveGoal(goal)
 		return
 	}
 
-	for !goal.IsGoalAchieved() {
-		for _, task := range goal.Tasks {
-			if task.Status == goalengine.Pending {
-				// Process the task
-				executeTask(task, chatHistory, goal)
-			}
+	if err := goal.Start(); err != nil {
+		log.Printf("Failed to start goal: %v", err)
+	}
+	saveGoal(goal)
+
+	// Tasks running at the same time each work on a copy of the chat
+	// history and add their messages to it when their attempt ends
+	var historyMu sync.Mutex
+	runTask := func(task *goalengine.Task) {
+		historyMu.Lock()
+		history := append([]types.PromptMessage(nil), *chatHistory...)
+		historyMu.Unlock()
+
+		start := len(history)
+		executeTask(ctx, task, &history, goal, onEvent)
+
+		historyMu.Lock()
+		*chatHistory = append(*chatHistory, history[start:]...)
+		logging.LogChatMessages(goal, history[start:])
+		historyMu.Unlock()
+	}
+
+	// Vision and tool calls drive the desktop in ways the plan does not
+	// show, so goals using them run one task at a time
+	limit := goal.Parallel
+	if limit > 1 && (goal.UseVision || goal.AgentMode) {
+		limit = 1
+		addLog(goal, onEvent, "Running tasks one at a time, as the goal uses vision or agent mode.")
+	}
+
+	// Replan the rest of the goal while tasks fail, up to MaxReplans times
+	goal.Run(ctx, limit, runTask)
+	for ctx.Err() == nil && goal.Replans() < goal.MaxReplans {
+		reason := failedTasksSummary(goal)
+		if reason == "" {
+			break
+		}
+		addLog(goal, onEvent, fmt.Sprintf("Replanning the goal (%d of %d) after: %s", goal.Replans()+1, goal.MaxReplans, reason))
+		plan, err := assistant.ReplanTasks(ctx, goal, onEvent)
+		if err != nil {
+			log.Printf("Failed to replan goal '%s': %v", goal.Description, err)
+			addLog(goal, onEvent, fmt.Sprintf("Failed to replan the goal: %v", err))
+			break
 		}
-		//// Update the goal's current state
-		//err := goal.UpdateCurrentState()
-		//if err != nil {
-		//	log.Printf("Error updating current state: %v\n", err)
-		//	goal.Logs = append(goal.Logs, fmt.Sprintf("Error updating current state: %v", err))
-		//} else {
-		//	log.Printf("Current State Updated: CPU Usage: %.2f%%, Memory Usage: %.2f%%\n",
-		//		goal.CurrentState.CPUUsage, goal.CurrentState.MemoryUsage)
-		//	goal.Logs = append(goal.Logs, fmt.Sprintf("Current State Updated: CPU Usage: %.2f%%, Memory Usage: %.2f%%",
-		//		goal.CurrentState.CPUUsage, goal.CurrentState.MemoryUsage))
-		//}
-	}
-
-	// After processing, check for failed tasks
-	var failedTasks []string
+		goal.AddPlan(&goalengine.Plan{Tasks: plan.Tasks, Thinking: plan.Thinking, PromptVersion: plan.PromptVersion, Reason: reason})
+		saveGoal(goal)
+		onEvent(types.StreamEvent{Type: "replan", Data: fmt.Sprintf("Replanned the goal into %d tasks", len(plan.Tasks))})
+		goal.Run(ctx, limit, runTask)
+	}
+
+	cancelled := ctx.Err() != nil
+	reason := ""
+	if cancelled {
+		reason = "goal cancelled"
+	}
+	outcome := goal.Finish(cancelled, reason)
+
+	// After processing, check for tasks that did not complete
+	var unfinished []string
 	for _, task := range goal.Tasks {
-		if task.Status == goalengine.Failed {
-			failedTasks = append(failedTasks, task.Description)
+		if task.Status != goalengine.Completed {
+			unfinished = append(unfinished, fmt.Sprintf("%s (%s)", task.Description, task.Status))
 		}
 	}
 
-	if len(failedTasks) > 0 {
+	if len(unfinished) > 0 {
 		log.Println("Some tasks could not be completed:")
-		goal.Logs = append(goal.Logs, "Some tasks could not be completed:")
-		for _, desc := range failedTasks {
+		addLog(goal, onEvent, "Some tasks could not be completed:")
+		for _, desc := range unfinished {
 			log.Printf("- %s\n", desc)
-			goal.Logs = append(goal.Logs, fmt.Sprintf("- %s", desc))
+			addLog(goal, onEvent, fmt.Sprintf("- %s", desc))
 		}
 	} else {
 		log.Println("All tasks completed successfully!")
-		goal.Logs = append(goal.Logs, "All tasks completed successfully!")
+		addLog(goal, onEvent, "All tasks completed successfully!")
+	}
+	addLog(goal, onEvent, fmt.Sprintf("Goal outcome: %s", outcome))
+	saveGoal(goal)
+}
+
+// saveGoal updates the stored goal, if it is stored, logging failures.
+func saveGoal(goal *goalengine.Goal) {
+	if goal.ID == 0 {
+		return
+	}
+	if err := logging.SaveGoal(goal); err != nil {
+		log.Printf("Failed to save goal %d: %v", goal.ID, err)
+	}
+}
+
+// failedTasksSummary describes the failed tasks of the goal's current plan,
+// or returns "" if none failed.
+func failedTasksSummary(goal *goalengine.Goal) string {
+	var failed []string
+	for _, task := range goal.Tasks {
+		if task.Status == goalengine.Failed {
+			failed = append(failed, fmt.Sprintf("'%s' failed: %s", task.Description, task.Feedback))
+		}
+	}
+	return strings.Join(failed, "; ")
+}
+
+// setTaskStatus moves task to status, logging moves the goal engine rejects.
+func setTaskStatus(goal *goalengine.Goal, task *goalengine.Task, status goalengine.TaskStatus, reason string) {
+	if err := goal.Transition(task, status, reason); err != nil {
+		log.Printf("Invalid task transition: %v", err)
 	}
 }
 
-func executeTask(task *goalengine.Task, chatHistory *[]types.PromptMessage, goal *goalengine.Goal) {
+// addLog records msg on the goal and forwards it to streaming clients.
+func addLog(goal *goalengine.Goal, onEvent assistant.EventFunc, msg string) {
+	goal.AddLog(msg)
+	if onEvent != nil {
+		onEvent(types.StreamEvent{Type: "log", Data: msg})
+	}
+}
+
+func executeTask(ctx context.Context, task *goalengine.Task, chatHistory *[]types.PromptMessage, goal *goalengine.Goal, onEvent assistant.EventFunc) {
 	task.Attempt++
-	task.Status = goalengine.InProgress
+	setTaskStatus(goal, task, goalengine.Running, fmt.Sprintf("attempt %d", task.Attempt))
+	task.Thinking = ""
+	task.PromptVersion = ""
+	task.Samples = 0
+	task.Disagreement = 0
 
 	// Add user input to chat history
 	*chatHistory = append(*chatHistory, types.PromptMessage{
@@ -207,20 +890,39 @@ func executeTask(task *goalengine.Task, chatHistory *[]types.PromptMessage, goal
 		Content: task.Description,
 	})
 
-	// Get commands for the task
-	combinedPrompt, err := assistant.GetShellCommand(task.Description, *chatHistory, task.Feedback, isInstallationCommand(task.Description))
+	// In agent mode the model calls typed tools instead of writing shell strings
+	if goal.AgentMode {
+		if success, ok := executeAgentTask(ctx, task, chatHistory, goal, onEvent); ok {
+			finishTask(ctx, task, chatHistory, goal, onEvent, success)
+			return
+		}
+	}
+
+	executeShellTask(ctx, task, chatHistory, goal, onEvent)
+}
+
+// executeShellTask asks the model for shell commands for the task and runs them.
+func executeShellTask(ctx context.Context, task *goalengine.Task, chatHistory *[]types.PromptMessage, goal *goalengine.Goal, onEvent assistant.EventFunc) {
+	// Get commands for the task, voting on several candidates if the goal asks for it
+	combinedPrompt, consensus, err := assistant.GetShellCommandConsensus(ctx, task.Description, *chatHistory, task.Feedback, isInstallationCommand(task.Description), goal.Samples, onEvent)
+	if consensus != nil {
+		task.Samples = consensus.Samples
+		task.Disagreement = consensus.Disagreement
+	}
 	if err != nil {
 		log.Printf("Error getting commands for task '%s': %v\n", task.Description, err)
-		task.Status = goalengine.Failed
 		task.Feedback = err.Error()
-		goal.Logs = append(goal.Logs, fmt.Sprintf("Error getting commands for task '%s': %v", task.Description, err))
-		logging.LogTaskExecution(task)
+		setTaskStatus(goal, task, failedOrCancelled(ctx), task.Feedback)
+		addLog(goal, onEvent, fmt.Sprintf("Error getting commands for task '%s': %v", task.Description, err))
+		logging.LogTaskExecution(goal, task)
 		return
 	}
 
 	task.Commands = combinedPrompt.Commands
+	task.Thinking = combinedPrompt.Thinking
+	task.PromptVersion = combinedPrompt.PromptVersion
 
-	// Add assistant's response to chat history
+	// Add assistant's response to chat history; thinking is never replayed
 	*chatHistory = append(*chatHistory, types.PromptMessage{
 		Role:    "assistant",
 		Content: combinedPrompt.NLResponse,
@@ -230,55 +932,155 @@ func executeTask(task *goalengine.Task, chatHistory *[]types.PromptMessage, goal
 
 	// Use vision model if needed and allowed
 	if combinedPrompt.VisionNeeded && goal.UseVision {
-		err := assistant.UseVisionModel(task.Description)
+		err := assistant.UseVisionModel(ctx, task.Description)
 		if err != nil {
 			log.Printf("Error using vision model for task '%s': %v\n", task.Description, err)
 			success = false
 			task.Feedback = err.Error()
-			goal.Logs = append(goal.Logs, fmt.Sprintf("Error using vision model for task '%s': %v", task.Description, err))
+			addLog(goal, onEvent, fmt.Sprintf("Error using vision model for task '%s': %v", task.Description, err))
 		}
 	} else if combinedPrompt.VisionNeeded && !goal.UseVision {
 		log.Printf("Vision model required but not enabled for task '%s'\n", task.Description)
 		success = false
 		task.Feedback = "Vision model required but not enabled."
-		goal.Logs = append(goal.Logs, fmt.Sprintf("Vision model required but not enabled for task '%s'", task.Description))
+		addLog(goal, onEvent, fmt.Sprintf("Vision model required but not enabled for task '%s'", task.Description))
+	}
+
+	// Commands that drive the desktop take turns with the input of other
+	// tasks, even when the planner did not mark the task as GUI
+	gui := assistant.HasGUICommand(task.Commands)
+	if gui {
+		task.GUI = true
+		assistant.LockScreen()
 	}
 
 	// Execute commands
+	var results []assistant.CommandOutput
 	for _, command := range task.Commands {
 		command = strings.TrimSpace(command)
 		if command == "" {
 			log.Printf("Skipping empty or invalid command.\n")
-			goal.Logs = append(goal.Logs, "Skipping empty or invalid command.")
+			addLog(goal, onEvent, "Skipping empty or invalid command.")
 			continue
 		}
-		err := assistant.ExecuteShellCommand(command)
+		output, err := assistant.RunShellCommand(command)
+		logging.LogCommandResult(goal, task, command, output, err)
 		if err != nil {
 			log.Printf("Error executing command '%s': %v\n", command, err)
 			success = false
 			task.Feedback = err.Error()
-			goal.Logs = append(goal.Logs, fmt.Sprintf("Error executing command '%s': %v", command, err))
+			addLog(goal, onEvent, fmt.Sprintf("Error executing command '%s': %v", command, err))
 			break
 		} else {
-			goal.Logs = append(goal.Logs, fmt.Sprintf("Command executed successfully: '%s'", command))
+			results = append(results, assistant.CommandOutput{Command: command, Output: output})
+			addLog(goal, onEvent, fmt.Sprintf("Command executed successfully: '%s'", command))
 		}
 	}
+	if gui {
+		assistant.UnlockScreen()
+	}
 
-	if success {
-		task.Status = goalengine.Completed
-		goal.Logs = append(goal.Logs, fmt.Sprintf("Task '%s' completed successfully.", task.Description))
-	} else {
-		if task.Attempt < task.MaxRetries {
-			// Retry the task with improved commands
-			goal.Logs = append(goal.Logs, fmt.Sprintf("Retrying task '%s'. Attempt %d.", task.Description, task.Attempt))
-			executeTask(task, chatHistory, goal)
+	// Have the verifier check that the commands achieved the task
+	if success && goal.Verify && len(results) > 0 {
+		verdict, err := assistant.VerifyTask(ctx, task.Description, results, onEvent)
+		if err != nil {
+			log.Printf("Error verifying task '%s': %v\n", task.Description, err)
+			addLog(goal, onEvent, fmt.Sprintf("Could not verify task '%s': %v", task.Description, err))
+		} else if !verdict.Achieved {
+			success = false
+			task.Feedback = "The commands ran but did not achieve the task: " + verdict.Reason
+		}
+	}
+
+	finishTask(ctx, task, chatHistory, goal, onEvent, success)
+}
+
+// finishTask checks the postconditions of a successful attempt, then marks
+// the task completed, puts it back to Pending for a retry, or marks it failed
+// once MaxRetries is reached, and records the outcome. A task whose goal was
+// cancelled is not retried.
+func finishTask(ctx context.Context, task *goalengine.Task, chatHistory *[]types.PromptMessage, goal *goalengine.Goal, onEvent assistant.EventFunc, success bool) {
+	if success && len(task.Postconditions) > 0 {
+		if unmet := assistant.CheckPostconditions(ctx, task.Description, task.Postconditions, goal.PostconditionTimeout, onEvent); len(unmet) > 0 {
+			success = false
+			task.Feedback = "The commands ran but these postconditions do not hold: " + strings.Join(unmet, "; ")
+		}
+	}
+
+	switch {
+	case success:
+		setTaskStatus(goal, task, goalengine.Completed, "")
+		addLog(goal, onEvent, fmt.Sprintf("Task '%s' completed successfully.", task.Description))
+	case ctx.Err() != nil:
+		setTaskStatus(goal, task, goalengine.Cancelled, "goal cancelled")
+		addLog(goal, onEvent, fmt.Sprintf("Task '%s' cancelled.", task.Description))
+	case task.Attempt < task.MaxRetries:
+		// Retry the task with improved commands
+		addLog(goal, onEvent, fmt.Sprintf("Retrying task '%s'. Attempt %d.", task.Description, task.Attempt))
+		setTaskStatus(goal, task, goalengine.Pending, task.Feedback)
+	default:
+		setTaskStatus(goal, task, goalengine.Failed, task.Feedback)
+		addLog(goal, onEvent, fmt.Sprintf("Task '%s' failed after %d attempts.", task.Description, task.Attempt))
+	}
+
+	logging.LogTaskExecution(goal, task)
+}
+
+// failedOrCancelled is the status of a task that cannot go on: Cancelled if
+// the goal was cancelled, Failed otherwise.
+func failedOrCancelled(ctx context.Context) goalengine.TaskStatus {
+	if ctx.Err() != nil {
+		return goalengine.Cancelled
+	}
+	return goalengine.Failed
+}
+
+// executeAgentTask runs the task through the tool-calling agent and reports
+// whether it succeeded. Executed tool calls are recorded as the task's commands.
+// ok is false when no installed model can call tools; the task should then run
+// as shell commands instead.
+func executeAgentTask(ctx context.Context, task *goalengine.Task, chatHistory *[]types.PromptMessage, goal *goalengine.Goal, onEvent assistant.EventFunc) (success bool, ok bool) {
+	result, err := assistant.RunToolAgent(ctx, task.Description, *chatHistory, task.Feedback, goal.UseVision, onEvent)
+	if errors.Is(err, llm.ErrUnsupported) {
+		// No installed model can call tools; complete the task with shell commands
+		addLog(goal, onEvent, fmt.Sprintf("Agent mode unavailable for task '%s': %v. Falling back to shell commands.", task.Description, err))
+		return false, false
+	}
+
+	task.Thinking = result.Thinking
+	task.PromptVersion = result.PromptVersion
+	task.Commands = nil
+	for _, step := range result.Steps {
+		args, _ := json.Marshal(step.Arguments)
+		call := fmt.Sprintf("%s(%s)", step.Tool, args)
+		task.Commands = append(task.Commands, call)
+		if step.Error != "" {
+			logging.LogCommandResult(goal, task, call, step.Result, errors.New(step.Error))
+			addLog(goal, onEvent, fmt.Sprintf("Tool call %s(%s) failed: %s", step.Tool, args, step.Error))
 		} else {
-			task.Status = goalengine.Failed
-			goal.Logs = append(goal.Logs, fmt.Sprintf("Task '%s' failed after %d attempts.", task.Description, task.Attempt))
+			logging.LogCommandResult(goal, task, call, step.Result, nil)
+			addLog(goal, onEvent, fmt.Sprintf("Tool call executed successfully: %s(%s)", step.Tool, args))
 		}
 	}
 
-	logging.LogTaskExecution(task)
+	if err != nil {
+		log.Printf("Error running tool agent for task '%s': %v\n", task.Description, err)
+		task.Feedback = err.Error()
+		addLog(goal, onEvent, fmt.Sprintf("Error running tool agent for task '%s': %v", task.Description, err))
+		return false, true
+	}
+
+	// Add assistant's response to chat history
+	*chatHistory = append(*chatHistory, types.PromptMessage{
+		Role:    "assistant",
+		Content: result.NLResponse,
+	})
+
+	if lastErr := result.LastError(); lastErr != "" {
+		task.Feedback = lastErr
+		return false, true
+	}
+	return true, true
 }
 
 // Handler for getting available models
@@ -288,17 +1090,24 @@ func modelsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get available models from Ollama
-	models, err := assistant.GetAvailableModels()
+	// Get installed models and their capabilities from the registry
+	registry, err := assistant.GetModelRegistry()
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to get models: %v", err), http.StatusInternalServerError)
 		return
 	}
 
+	models := []string{}
+	for _, model := range registry {
+		models = append(models, strings.TrimSuffix(model.Name, ":latest"))
+	}
+
 	response := struct {
-		Models []string `json:"models"`
+		Models       []string                `json:"models"`
+		Capabilities []llm.ModelCapabilities `json:"capabilities"`
 	}{
-		Models: models,
+		Models:       models,
+		Capabilities: registry,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -337,7 +1146,105 @@ func mapSystemHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(systemInfo)
 }
 
-// Handler for loading a specific model
+// pullHandler starts a background model download (POST {"model": ...}),
+// lists downloads (GET) or cancels one (DELETE ?id=...).
+func pullHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(assistant.PullJobs())
+	case http.MethodPost:
+		var req struct {
+			Model string `json:"model"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		startPull(w, req.Model)
+	case http.MethodDelete:
+		job, ok := assistant.PullJobByID(r.URL.Query().Get("id"))
+		if !ok {
+			http.Error(w, "Pull not found", http.StatusNotFound)
+			return
+		}
+		job.Cancel()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(job.Status())
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// startPull starts pulling model and responds with the job, whose progress
+// is streamed by /pull/progress?id=<id>.
+func startPull(w http.ResponseWriter, model string) {
+	if strings.TrimSpace(model) == "" {
+		http.Error(w, "Model is required", http.StatusBadRequest)
+		return
+	}
+	job, err := assistant.StartPull(model)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to start pull: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job.Status())
+}
+
+// pullProgressHandler streams the progress of a pull as Server-Sent Events:
+// a "progress" event with the job status after each update, then one "done",
+// "error" or "cancelled" event when it ends. Disconnecting does not cancel
+// the pull.
+func pullProgressHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	job, ok := assistant.PullJobByID(r.URL.Query().Get("id"))
+	if !ok {
+		http.Error(w, "Pull not found", http.StatusNotFound)
+		return
+	}
+
+	send, ok := newSSEWriter(w)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	updates, unsubscribe := job.Subscribe()
+	defer unsubscribe()
+
+	var last assistant.PullStatus
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case status, ok := <-updates:
+			if !ok {
+				eventType := "done"
+				switch last.State {
+				case assistant.PullFailed:
+					eventType = "error"
+				case assistant.PullCancelled:
+					eventType = "cancelled"
+				}
+				data, _ := json.Marshal(last)
+				send(types.StreamEvent{Type: eventType, Task: last.Model, Data: string(data)})
+				return
+			}
+			last = status
+			data, _ := json.Marshal(status)
+			send(types.StreamEvent{Type: "progress", Task: status.Model, Data: string(data)})
+		}
+	}
+}
+
+// Handler for loading a specific model into memory. keepAlive defaults to
+// the keepAlive setting. Missing models are pulled in the background first;
+// the response then names the pull to follow on /pull/progress.
 func loadModelHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -345,16 +1252,26 @@ func loadModelHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		Model string `json:"model"`
+		Model     string `json:"model"`
+		KeepAlive string `json:"keepAlive"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
+	if strings.TrimSpace(req.Model) == "" {
+		http.Error(w, "Model is required", http.StatusBadRequest)
+		return
+	}
+	if req.KeepAlive == "" {
+		if settingsData, err := settings.LoadSettings(); err == nil {
+			req.KeepAlive = settingsData.KeepAlive
+		}
+	}
 
 	// Load the model
-	err := assistant.PullModel(req.Model)
+	job, err := assistant.LoadModel(req.Model, req.KeepAlive)
 	if err != nil {
 		log.Printf("Failed to load model %s: %v", req.Model, err)
 		http.Error(w, fmt.Sprintf("Failed to load model: %v", err), http.StatusInternalServerError)
@@ -362,14 +1279,21 @@ func loadModelHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	response := struct {
-		Message string `json:"message"`
-		Model   string `json:"model"`
+		Message string                `json:"message"`
+		Model   string                `json:"model"`
+		Pull    *assistant.PullStatus `json:"pull,omitempty"`
 	}{
 		Message: fmt.Sprintf("Model %s loaded successfully", req.Model),
 		Model:   req.Model,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	if job != nil {
+		status := job.Status()
+		response.Message = fmt.Sprintf("Model %s is being pulled and will be loaded when the pull completes", req.Model)
+		response.Pull = &status
+		w.WriteHeader(http.StatusAccepted)
+	}
 	json.NewEncoder(w).Encode(response)
 }
 
@@ -389,9 +1313,13 @@ func unloadModelHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Unload the model (this is a placeholder - Ollama doesn't have explicit unload)
-	// In practice, Ollama manages memory automatically
+	// Unload the model
 	log.Printf("Unloading model: %s", req.Model)
+	if err := assistant.UnloadModel(req.Model); err != nil {
+		log.Printf("Failed to unload model %s: %v", req.Model, err)
+		http.Error(w, fmt.Sprintf("Failed to unload model: %v", err), http.StatusInternalServerError)
+		return
+	}
 
 	response := struct {
 		Message string `json:"message"`
@@ -404,3 +1332,263 @@ func unloadModelHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
+
+// Handler for deleting a model from the model store
+func deleteModelHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Model string `json:"model"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Model) == "" {
+		http.Error(w, "Model is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := assistant.DeleteModel(req.Model); err != nil {
+		log.Printf("Failed to delete model %s: %v", req.Model, err)
+		http.Error(w, fmt.Sprintf("Failed to delete model: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		Message string `json:"message"`
+		Model   string `json:"model"`
+	}{
+		Message: fmt.Sprintf("Model %s deleted successfully", req.Model),
+		Model:   req.Model,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Handler for copying a model to a new name or tag
+func copyModelHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Source      string `json:"source"`
+		Destination string `json:"destination"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Source) == "" || strings.TrimSpace(req.Destination) == "" {
+		http.Error(w, "Source and destination are required", http.StatusBadRequest)
+		return
+	}
+
+	if err := assistant.CopyModel(req.Source, req.Destination); err != nil {
+		log.Printf("Failed to copy model %s to %s: %v", req.Source, req.Destination, err)
+		http.Error(w, fmt.Sprintf("Failed to copy model: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		Message string `json:"message"`
+		Model   string `json:"model"`
+	}{
+		Message: fmt.Sprintf("Model %s copied to %s successfully", req.Source, req.Destination),
+		Model:   req.Destination,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Handler for creating the wsa-commander model, which has the command
+// generation prompt and parameters built in. from defaults to the first
+// commander model; parameters override the built-in defaults.
+func createModelHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		From       string                 `json:"from"`
+		Parameters map[string]interface{} `json:"parameters"`
+	}
+
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+	}
+
+	model, err := assistant.CreateCommanderModel(req.From, req.Parameters)
+	if err != nil {
+		log.Printf("Failed to create model: %v", err)
+		http.Error(w, fmt.Sprintf("Failed to create model: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		Message string `json:"message"`
+		Model   string `json:"model"`
+	}{
+		Message: fmt.Sprintf("Model %s created successfully", model),
+		Model:   model,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Handler listing the models held in memory, with their memory use and when
+// they expire
+func loadedModelsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	loaded, err := assistant.GetLoadedModels()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get loaded models: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	type loadedModel struct {
+		llm.LoadedModel
+		SizeRAM int64 `json:"sizeRam"`
+	}
+	response := struct {
+		Models    []loadedModel `json:"models"`
+		TotalVRAM int64         `json:"totalVram"`
+		TotalRAM  int64         `json:"totalRam"`
+	}{Models: []loadedModel{}}
+	for _, m := range loaded {
+		response.Models = append(response.Models, loadedModel{LoadedModel: m, SizeRAM: m.SizeRAM()})
+		response.TotalVRAM += m.SizeVRAM
+		response.TotalRAM += m.SizeRAM()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Handler for listing stored goals. ?status=Interrupted lists the goals
+// that were running when the backend last stopped, which can be resumed
+// through /goals/resume or abandoned through /goals/abandon.
+func goalsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	goals, err := logging.ListGoals(r.URL.Query().Get("status"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list goals: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(goals)
+}
+
+// decodeGoalID reads the {"id": n} body of the /goals endpoints.
+func decodeGoalID(r *http.Request) (int64, error) {
+	var req struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return 0, fmt.Errorf("Invalid JSON")
+	}
+	if req.ID <= 0 {
+		return 0, fmt.Errorf("Goal id is required")
+	}
+	return req.ID, nil
+}
+
+// Handler for resuming an interrupted goal. The response is that of /execute.
+func resumeGoalHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := decodeGoalID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	goal, err := resumeGoal(r.Context(), id, nil)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to resume goal: %v", err), goalErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newExecuteResponse(goal))
+}
+
+// Handler for resuming an interrupted goal while streaming progress as
+// Server-Sent Events, with the events of /execute/stream.
+func resumeGoalStreamHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := decodeGoalID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	onEvent, ok := newSSEWriter(w)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	goal, err := resumeGoal(r.Context(), id, onEvent)
+	if err != nil {
+		onEvent(types.StreamEvent{Type: "error", Data: fmt.Sprintf("Failed to resume goal: %v", err)})
+		return
+	}
+
+	response, _ := json.Marshal(newExecuteResponse(goal))
+	onEvent(types.StreamEvent{Type: "done", Data: string(response)})
+}
+
+// Handler for abandoning an interrupted goal, which cancels its remaining
+// tasks.
+func abandonGoalHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := decodeGoalID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	goal, err := abandonGoal(id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to abandon goal: %v", err), goalErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newExecuteResponse(goal))
+}
@@ -80,12 +80,17 @@ func executeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The model, endpoint and limits apply to this request only
 	var req struct {
-		Goal          string                 `json:"goal"`
-		UseVision     bool                   `json:"useVision"`
-		Model         string                 `json:"model"`
-		SystemContext map[string]interface{} `json:"systemContext"`
-		Timestamp     string                 `json:"timestamp"`
+		Goal           string                 `json:"goal"`
+		UseVision      bool                   `json:"useVision"`
+		Model          string                 `json:"model"`
+		Provider       string                 `json:"provider"`
+		Endpoint       string                 `json:"endpoint"`       // Ollama generate endpoint
+		Timeout        string                 `json:"timeout"`        // Go duration bounding the model call
+		CommandTimeout string                 `json:"commandTimeout"` // Go duration bounding each command, default 20s
+		SystemContext  map[string]interface{} `json:"systemContext"`
+		Timestamp      string                 `json:"timestamp"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -96,15 +101,29 @@ func executeHandler(w http.ResponseWriter, r *http.Request) {
 	goalDescription := req.Goal
 	log.Printf("Received goal: %s", goalDescription)
 
+	timeout, err := durationOr(req.Timeout, 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	commandTimeout, err := durationOr(req.CommandTimeout, 20*time.Second)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts := types.Options{Model: req.Model, Endpoint: req.Endpoint, Timeout: timeout}
+
 	if req.Model != "" {
-		os.Setenv("LLM_MODEL", req.Model)
 		log.Printf("Using model: %s for request: %s", req.Model, goalDescription)
 	}
+	if req.Provider != "" && req.Provider != "ollama" {
+		log.Printf("Ignoring provider %s for request: %s; this server only talks to Ollama", req.Provider, goalDescription)
+	}
 
 	// Process the goal using our goal engine
 	log.Printf("Processing goal: '%s'", goalDescription)
 
-	commands, err := goalengine.ProcessGoal(goalDescription, req.SystemContext)
+	commands, err := goalengine.ProcessGoal(goalDescription, req.SystemContext, opts)
 
 	var logs []string
 	var message string
@@ -141,7 +160,7 @@ func executeHandler(w http.ResponseWriter, r *http.Request) {
 				sh = "cmd"
 				args = []string{"/C", cmd}
 			}
-			ctx, cancel := context.WithTimeout(r.Context(), 20*time.Second)
+			ctx, cancel := context.WithTimeout(r.Context(), commandTimeout)
 			defer cancel()
 			c := exec.CommandContext(ctx, sh, args...)
 			output, runErr := c.CombinedOutput()
@@ -194,6 +213,18 @@ func executeHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// durationOr parses value as a Go duration, returning fallback when it is empty.
+func durationOr(value string, fallback time.Duration) (time.Duration, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("Invalid duration %q", value)
+	}
+	return d, nil
+}
+
 // Handler for getting and setting settings
 func settingsHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
@@ -477,7 +508,7 @@ func visionAnalyzeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	respText, err := vision.AnalyzeWithImages(req.Prompt, images, req.Model)
+	respText, err := vision.AnalyzeWithImages(req.Prompt, images, types.Options{Model: req.Model})
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Vision analysis failed: %v", err), http.StatusInternalServerError)
 		return
@@ -537,7 +568,7 @@ func visionCaptureAndAnalyzeHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	b64 := base64.StdEncoding.EncodeToString(b)
 
-	respText, err := vision.AnalyzeWithImages(req.Prompt, []string{b64}, req.Model)
+	respText, err := vision.AnalyzeWithImages(req.Prompt, []string{b64}, types.Options{Model: req.Model})
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Vision analysis failed: %v", err), http.StatusInternalServerError)
 		return
@@ -5,20 +5,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"os"
 	"strings"
 	"time"
+
+	"WSA/pkg/types"
 )
 
 // GenerateCommandsWithOllama asks an Ollama model to turn a natural language goal
 // into a list of shell commands. It returns the commands and the raw response text.
-func GenerateCommandsWithOllama(goal string, model string) ([]string, string, error) {
-	if model == "" {
-		model = os.Getenv("LLM_MODEL")
-	}
-	if model == "" {
-		model = "gemma3:12b"
-	}
+// The model, endpoint and timeout come from opts.
+func GenerateCommandsWithOllama(goal string, opts types.Options) ([]string, string, error) {
+	model := opts.ModelOr("gemma3:12b")
 
 	// Instruction to constrain output to a simple command list
 	prompt := fmt.Sprintf(`You are a command-generation assistant.
@@ -43,10 +40,7 @@ Examples (style, not answers):
 
 If the goal is unclear, output a single echo explaining what is missing.`, strings.TrimSpace(goal))
 
-	endpoint := os.Getenv("LLM_API_ENDPOINT")
-	if endpoint == "" {
-		endpoint = "http://localhost:11434/api/generate"
-	}
+	endpoint := opts.GenerateEndpoint()
 
 	body := map[string]interface{}{
 		"model":  model,
@@ -58,7 +52,7 @@ If the goal is unclear, output a single echo explaining what is missing.`, strin
 		return nil, "", err
 	}
 
-	client := &http.Client{Timeout: 60 * time.Second}
+	client := &http.Client{Timeout: opts.TimeoutOr(60 * time.Second)}
 	resp, err := client.Post(endpoint, "application/json", bytes.NewBuffer(b))
 	if err != nil {
 		return nil, "", err
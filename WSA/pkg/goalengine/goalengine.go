@@ -2,16 +2,17 @@ package goalengine
 
 import (
 	"WSA/pkg/assistant"
+	"WSA/pkg/types"
 	"fmt"
 	"log"
 )
 
-// ProcessGoal processes a user goal and returns commands
-func ProcessGoal(goal string, systemContext map[string]interface{}) ([]string, error) {
+// ProcessGoal processes a user goal with the model options of its request and returns commands
+func ProcessGoal(goal string, systemContext map[string]interface{}, opts types.Options) ([]string, error) {
 	log.Printf("Processing goal: %s", goal)
 
 	// Always use Ollama for command generation
-	commands, _, err := assistant.GenerateCommandsWithOllama(goal, "")
+	commands, _, err := assistant.GenerateCommandsWithOllama(goal, opts)
 	if err != nil {
 		return nil, fmt.Errorf("ollama generation failed: %v", err)
 	}
@@ -1,5 +1,10 @@
 package types
 
+import (
+	"os"
+	"time"
+)
+
 // Request represents an execution request
 type Request struct {
 	Goal          string                 `json:"goal"`
@@ -29,3 +34,43 @@ type VisionAnalyzeResponse struct {
 	Model    string `json:"model"`
 	Response string `json:"response"`
 }
+
+// Options configure the model calls of one request, so concurrent requests
+// can use different models. Empty fields fall back to the LLM_MODEL and
+// LLM_API_ENDPOINT environment variables.
+type Options struct {
+	Model    string
+	Endpoint string        // Ollama generate endpoint
+	Timeout  time.Duration // Bounds a single model call
+}
+
+// ModelOr returns the model of the request, LLM_MODEL, or fallback.
+func (o Options) ModelOr(fallback string) string {
+	if o.Model != "" {
+		return o.Model
+	}
+	if model := os.Getenv("LLM_MODEL"); model != "" {
+		return model
+	}
+	return fallback
+}
+
+// GenerateEndpoint returns the endpoint of the request, LLM_API_ENDPOINT, or
+// the generate endpoint of a local Ollama server.
+func (o Options) GenerateEndpoint() string {
+	if o.Endpoint != "" {
+		return o.Endpoint
+	}
+	if endpoint := os.Getenv("LLM_API_ENDPOINT"); endpoint != "" {
+		return endpoint
+	}
+	return "http://localhost:11434/api/generate"
+}
+
+// TimeoutOr returns the timeout of the request, or fallback.
+func (o Options) TimeoutOr(fallback time.Duration) time.Duration {
+	if o.Timeout > 0 {
+		return o.Timeout
+	}
+	return fallback
+}
@@ -8,24 +8,19 @@ import (
 	"io"
 	"net/http"
 	"os"
+
+	"WSA/pkg/types"
 )
 
 // AnalyzeWithImages sends a prompt and one or more base64-encoded images to Ollama using
 // a multimodal model (defaults to a gemma3 vision-capable variant) and returns the text response.
-func AnalyzeWithImages(prompt string, imagesBase64 []string, model string) (string, error) {
-	if model == "" {
-		// Default to a gemma3 vision-capable model name
-		model = os.Getenv("LLM_MODEL")
-		if model == "" {
-			model = "gemma3:12b"
-		}
-	}
+// The model, endpoint and timeout come from opts.
+func AnalyzeWithImages(prompt string, imagesBase64 []string, opts types.Options) (string, error) {
+	// Default to a gemma3 vision-capable model name
+	model := opts.ModelOr("gemma3:12b")
 
 	// Ollama generate endpoint; see https://github.com/ollama/ollama/blob/main/docs/api.md
-	apiEndpoint := os.Getenv("LLM_API_ENDPOINT")
-	if apiEndpoint == "" {
-		apiEndpoint = "http://localhost:11434/api/generate"
-	}
+	apiEndpoint := opts.GenerateEndpoint()
 
 	payload := map[string]interface{}{
 		"model":  model,
@@ -39,7 +34,8 @@ func AnalyzeWithImages(prompt string, imagesBase64 []string, model string) (stri
 		return "", fmt.Errorf("failed to marshal vision payload: %w", err)
 	}
 
-	resp, err := http.Post(apiEndpoint, "application/json", bytes.NewBuffer(body))
+	client := &http.Client{Timeout: opts.TimeoutOr(0)}
+	resp, err := client.Post(apiEndpoint, "application/json", bytes.NewBuffer(body))
 	if err != nil {
 		return "", fmt.Errorf("ollama request failed: %w", err)
 	}
@@ -62,7 +58,7 @@ func AnalyzeWithImages(prompt string, imagesBase64 []string, model string) (stri
 }
 
 // AnalyzeImagePaths reads image files, base64-encodes them, and calls AnalyzeWithImages.
-func AnalyzeImagePaths(prompt string, imagePaths []string, model string) (string, error) {
+func AnalyzeImagePaths(prompt string, imagePaths []string, opts types.Options) (string, error) {
 	images := make([]string, 0, len(imagePaths))
 	for _, p := range imagePaths {
 		data, err := os.ReadFile(p)
@@ -71,5 +67,5 @@ func AnalyzeImagePaths(prompt string, imagePaths []string, model string) (string
 		}
 		images = append(images, base64.StdEncoding.EncodeToString(data))
 	}
-	return AnalyzeWithImages(prompt, images, model)
+	return AnalyzeWithImages(prompt, images, opts)
 }
@@ -0,0 +1,58 @@
+package assistant
+
+import (
+	"WSA/pkg/llm"
+	"WSA/pkg/prompts"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// GenerateCommandsWithOllama asks an Ollama model to turn a natural language goal
+// into a list of shell commands. It returns the commands and the raw response text.
+func GenerateCommandsWithOllama(goal string, model string) ([]string, string, error) {
+	// Instruction to constrain output to a simple command list
+	vars := prompts.DefaultVars()
+	vars.Goal = strings.TrimSpace(goal)
+	prompt, promptVersion, err := prompts.Render(prompts.CommandGeneration, vars)
+	if err != nil {
+		return nil, "", err
+	}
+	fmt.Printf("Using prompt template %s\n", promptVersion)
+
+	response, err := llm.Default().Generate(context.Background(), llm.GenerateRequest{
+		Model:  llm.ModelFromEnv(model, "gemma3:12b"),
+		Prompt: prompt,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	if !response.CachedAt.IsZero() {
+		fmt.Printf("Cache hit for '%s': reusing a response stored at %s\n", goal, response.CachedAt.Format(time.RFC3339))
+	}
+
+	// Parse commands line-by-line, strip code fences if present
+	text := strings.TrimSpace(response.Response)
+	// Remove triple backtick code fences optionally with language tag
+	text = strings.TrimPrefix(text, "```bash")
+	text = strings.TrimPrefix(text, "```")
+	text = strings.TrimSuffix(text, "```")
+	text = strings.Trim(text, "`")
+	lines := strings.Split(text, "\n")
+	commands := make([]string, 0, len(lines))
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		// Drop bullets or numbering
+		line = strings.TrimPrefix(line, "-")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		commands = append(commands, line)
+	}
+	if len(commands) == 0 {
+		return nil, response.Response, fmt.Errorf("no commands generated")
+	}
+	return commands, response.Response, nil
+}
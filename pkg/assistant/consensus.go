@@ -0,0 +1,133 @@
+package assistant
+
+import (
+	"WSA/pkg/types"
+	"context"
+	"fmt"
+	"strings"
+)
+
+// MaxCommandSamples bounds how many candidates GetShellCommandConsensus may
+// sample for one task.
+const MaxCommandSamples = 9
+
+// Sampling temperatures of the first and last candidate; the others are
+// spread evenly in between.
+const (
+	minSampleTemperature = 0.2
+	maxSampleTemperature = 1.0
+)
+
+// Consensus records how the command candidates sampled for a task voted.
+type Consensus struct {
+	Samples int `json:"samples"` // Candidates requested
+	Valid   int `json:"valid"`   // Candidates that parsed and passed the safety check
+	Votes   int `json:"votes"`   // Valid candidates that agree with the winner
+	// Disagreement is the share of valid candidates that differ from the winner
+	Disagreement float64              `json:"disagreement"`
+	Candidates   []ConsensusCandidate `json:"candidates"`
+}
+
+// ConsensusCandidate is a distinct command list and the candidates that proposed it.
+type ConsensusCandidate struct {
+	Commands    []string `json:"commands"`
+	Votes       int      `json:"votes"`
+	Temperature float64  `json:"temperature"` // Lowest temperature that proposed it
+}
+
+// GetShellCommandConsensus is GetShellCommandStream for unreliable models: it
+// samples the commander samples times at increasing temperatures and returns
+// the command list proposed most often. Candidates are normalized with
+// fixStartCommand, and candidates that fail to parse or contain a dangerous
+// command are discarded before voting. Ties go to the candidate with fewer
+// commands, then to the one sampled at the lower temperature. The vote is
+// reported to onEvent as a "consensus" event.
+func GetShellCommandConsensus(ctx context.Context, userInput string, chatHistory []types.PromptMessage, errorContext string, isInstallation bool, samples int, onEvent EventFunc) (*types.CombinedPrompt, *Consensus, error) {
+	if samples > MaxCommandSamples {
+		samples = MaxCommandSamples
+	}
+	if samples <= 1 {
+		combinedPrompt, err := GetShellCommandStream(ctx, userInput, chatHistory, errorContext, isInstallation, onEvent)
+		return combinedPrompt, nil, err
+	}
+
+	if combinedPrompt, ok := smartAppCommand(userInput, onEvent); ok {
+		return combinedPrompt, nil, nil
+	}
+
+	messages, promptVersion, err := shellCommandMessages(chatHistory, errorContext)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	consensus := &Consensus{Samples: samples}
+	proposals := map[string]*types.CombinedPrompt{} // First candidate of each distinct command list
+	index := map[string]int{}
+	var lastErr error
+	for i := 0; i < samples; i++ {
+		temperature := minSampleTemperature + (maxSampleTemperature-minSampleTemperature)*float64(i)/float64(samples-1)
+		// A distinct seed per sample also keeps the response cache from
+		// returning the same candidate twice
+		options := map[string]interface{}{"temperature": temperature, "seed": i + 1}
+		candidate, err := sampleShellCommand(ctx, userInput, messages, options, onEvent, nil)
+		if err != nil {
+			fmt.Printf("Command sample %d of %d for '%s' discarded: %v\n", i+1, samples, userInput, err)
+			lastErr = err
+			continue
+		}
+		consensus.Valid++
+
+		key := commandsKey(candidate.Commands)
+		if j, ok := index[key]; ok {
+			consensus.Candidates[j].Votes++
+			continue
+		}
+		index[key] = len(consensus.Candidates)
+		proposals[key] = candidate
+		consensus.Candidates = append(consensus.Candidates, ConsensusCandidate{
+			Commands:    candidate.Commands,
+			Votes:       1,
+			Temperature: temperature,
+		})
+	}
+	if consensus.Valid == 0 {
+		return nil, consensus, fmt.Errorf("none of %d command samples was usable: %w", samples, lastErr)
+	}
+
+	// Candidates are in order of temperature, so the first best one wins ties
+	winner := consensus.Candidates[0]
+	for _, c := range consensus.Candidates[1:] {
+		if c.Votes > winner.Votes || (c.Votes == winner.Votes && len(c.Commands) < len(winner.Commands)) {
+			winner = c
+		}
+	}
+	consensus.Votes = winner.Votes
+	consensus.Disagreement = float64(consensus.Valid-winner.Votes) / float64(consensus.Valid)
+
+	message := fmt.Sprintf("Consensus for '%s': %d of %d usable samples agree on %s (disagreement %.0f%%, %d distinct candidates).",
+		userInput, winner.Votes, consensus.Valid, strings.Join(winner.Commands, "; "), consensus.Disagreement*100, len(consensus.Candidates))
+	fmt.Println(message)
+	if onEvent != nil {
+		onEvent(types.StreamEvent{Type: "consensus", Task: userInput, Data: message})
+	}
+
+	combinedPrompt := proposals[commandsKey(winner.Commands)]
+	combinedPrompt.PromptVersion = promptVersion
+	if onEvent != nil && combinedPrompt.NLResponse != "" {
+		onEvent(types.StreamEvent{Type: "nlResponse", Task: userInput, Data: combinedPrompt.NLResponse})
+	}
+	if err := confirmVision(combinedPrompt); err != nil {
+		return nil, consensus, err
+	}
+	return combinedPrompt, consensus, nil
+}
+
+// commandsKey identifies a command list regardless of surrounding and
+// repeated whitespace.
+func commandsKey(commands []string) string {
+	normalized := make([]string, 0, len(commands))
+	for _, cmd := range commands {
+		normalized = append(normalized, strings.Join(strings.Fields(cmd), " "))
+	}
+	return strings.Join(normalized, "\n")
+}
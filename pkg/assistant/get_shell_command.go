@@ -1,14 +1,12 @@
 package assistant
 
 import (
+	"WSA/pkg/llm"
+	"WSA/pkg/prompts"
 	"WSA/pkg/settings"
 	"WSA/pkg/types"
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
-	"io"
-	"net/http"
-	"os"
 	"os/user"
 	"path/filepath"
 	"regexp"
@@ -18,31 +16,75 @@ import (
 
 // GetShellCommand generates commands from the LLM based on user input, chat history, optional error context, and command type
 func GetShellCommand(userInput string, chatHistory []types.PromptMessage, errorContext string, isInstallation bool) (*types.CombinedPrompt, error) {
+	return GetShellCommandStream(context.Background(), userInput, chatHistory, errorContext, isInstallation, nil)
+}
+
+// GetShellCommandStream is GetShellCommand with the model reply streamed and
+// the models configured by the llm.Options carried by ctx. Partial nlResponse
+// text and thinking deltas are passed to onEvent as they arrive; the returned
+// CombinedPrompt is built once the stream ends.
+func GetShellCommandStream(ctx context.Context, userInput string, chatHistory []types.PromptMessage, errorContext string, isInstallation bool, onEvent EventFunc) (*types.CombinedPrompt, error) {
+	if combinedPrompt, ok := smartAppCommand(userInput, onEvent); ok {
+		return combinedPrompt, nil
+	}
+
+	messages, promptVersion, err := shellCommandMessages(chatHistory, errorContext)
+	if err != nil {
+		return nil, err
+	}
+
+	combinedPrompt, err := sampleShellCommand(ctx, userInput, messages, nil, onEvent,
+		streamEvents(onEvent, userInput, "nlResponse", ""))
+	if err != nil {
+		return nil, err
+	}
+	combinedPrompt.PromptVersion = promptVersion
+
+	if err := confirmVision(combinedPrompt); err != nil {
+		return nil, err
+	}
+	return combinedPrompt, nil
+}
+
+// smartAppCommand answers simple app control requests without the model.
+func smartAppCommand(userInput string, onEvent EventFunc) (*types.CombinedPrompt, bool) {
 	// Check if this is a simple app control request that we can handle intelligently
 	fmt.Printf("Checking smart app control for: '%s'\n", userInput)
-	if smartCommand, err := HandleSmartAppControl(userInput); err == nil {
-		fmt.Printf("Smart app control succeeded: %s\n", smartCommand)
-		return &types.CombinedPrompt{
-			NLResponse:   fmt.Sprintf("I'll %s for you.", userInput),
-			Commands:     []string{smartCommand},
-			VisionNeeded: false,
-		}, nil
-	} else {
+	smartCommand, err := HandleSmartAppControl(userInput)
+	if err != nil {
 		fmt.Printf("Smart app control failed: %v\n", err)
+		return nil, false
 	}
+
+	fmt.Printf("Smart app control succeeded: %s\n", smartCommand)
+	nlResponse := fmt.Sprintf("I'll %s for you.", userInput)
+	if onEvent != nil {
+		onEvent(types.StreamEvent{Type: "nlResponse", Task: userInput, Data: nlResponse})
+	}
+	return &types.CombinedPrompt{
+		NLResponse:   nlResponse,
+		Commands:     []string{smartCommand},
+		VisionNeeded: false,
+	}, true
+}
+
+// shellCommandMessages builds the system prompt, with error context if
+// available, followed by the chat history. It also returns the version of the
+// prompt template.
+func shellCommandMessages(chatHistory []types.PromptMessage, errorContext string) ([]types.PromptMessage, string, error) {
 	// Path to the system index file
 	indexFilePath := "system_index.txt"
 
 	// Load the system index
 	systemIndex, err := LoadSystemIndex(indexFilePath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load system index: %w", err)
+		return nil, "", fmt.Errorf("failed to load system index: %w", err)
 	}
 
 	// Get the current user's username
 	currentUser, err := user.Current()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get current user: %w", err)
+		return nil, "", fmt.Errorf("failed to get current user: %w", err)
 	}
 	username := currentUser.Username
 
@@ -58,46 +100,21 @@ func GetShellCommand(userInput string, chatHistory []types.PromptMessage, errorC
 	// Load settings to get the default browser
 	settingsData, err := settings.LoadSettings()
 	if err != nil {
-		return nil, fmt.Errorf("failed to load settings: %w", err)
-	}
-
-	// Include the default browser in the system prompt or use it in command post-processing
-	defaultBrowser := settingsData.DefaultBrowser
-
-	// Construct system prompt with error context if available
-	systemPrompt := "You are an AI assistant that helps generate macOS Terminal commands to achieve user tasks. " +
-		"For starting applications, always use the format 'open -a appname' (e.g., 'open -a TextEdit', 'open -a Spotify'). " +
-		"**For closing applications, use the command 'osascript -e \"quit app \\\"AppName\\\"\"' (e.g., 'osascript -e \"quit app \\\"Spotify\\\"\"').** " +
-		"Do not include file paths, extensions, or any additional parameters. " +
-		"Based on the user's input and system information, provide the necessary commands wrapped in **a single JSON object only**. " +
-		"Ensure the commands are compatible with macOS and do not include any dangerous operations. " +
-		"Do not include any additional text or explanations.\n\n" +
-		"**Response format strictly as follows (do not include any text outside this JSON):**\n" +
-		"```json\n" +
-		"{\n" +
-		"  \"nlResponse\": \"Your natural language response to the user.\",\n" +
-		"  \"commands\": [\n" +
-		"    \"First command\",\n" +
-		"    \"Second command\"\n" +
-		"  ],\n" +
-		"  \"visionNeeded\": false // Set to true if vision is needed, else false.\n" +
-		"}\n" +
-		"```\n" +
-		"Ensure that the JSON is properly formatted and contains no syntax errors. " +
-		"**Ensure that in the JSON output, all special characters, especially double quotes, are properly escaped using backslashes as per JSON format.** " +
-		"**Do not include multiple JSON objects or arrays.** " +
-		"**When closing applications, ensure the command follows the specified 'osascript' format.**"
-
-	// Example: Include default browser in the system prompt
-	systemPrompt += "\n\nNote: The user's default browser is " + defaultBrowser + "."
+		return nil, "", fmt.Errorf("failed to load settings: %w", err)
+	}
 
+	// Render the system prompt for this OS with error context if available
+	vars := prompts.DefaultVars()
+	vars.DefaultBrowser = settingsData.DefaultBrowser
+	vars.Username = username
+	vars.IndexSummary = summarizedIndex
 	if errorContext != "" {
-		systemPrompt += "\n\nNote: The previous command failed with the following error: \"" + sanitizeError(errorContext) + "\". " +
-			"Please provide an improved command to address this error."
+		vars.ErrorContext = sanitizeError(errorContext)
+	}
+	systemPrompt, promptVersion, err := prompts.Render(prompts.ShellCommand, vars)
+	if err != nil {
+		return nil, "", err
 	}
-
-	// Append the summarized system index to the prompt
-	systemPrompt += "\n\nHere is a summary of key system directories:\n" + summarizedIndex
 
 	systemMessage := types.PromptMessage{
 		Role:    "system",
@@ -107,78 +124,34 @@ func GetShellCommand(userInput string, chatHistory []types.PromptMessage, errorC
 	// Build chat history including system message
 	messages := []types.PromptMessage{systemMessage}
 	messages = append(messages, chatHistory...)
+	return messages, promptVersion, nil
+}
 
-	// Prepare LLM request data
-	chatData := types.ChatData{
-		Model:    os.Getenv("LLM_MODEL"), // Model name from environment variable
+// sampleShellCommand asks the commander models for one CombinedPrompt,
+// streaming the reply to stream if set. options are sent with the request,
+// e.g. a sampling temperature. The commands are normalized and filtered, and
+// an error is returned when none are left or any is dangerous.
+func sampleShellCommand(ctx context.Context, userInput string, messages []types.PromptMessage, options map[string]interface{}, onEvent EventFunc, stream llm.StreamFunc) (*types.CombinedPrompt, error) {
+	// Constrain the reply to the CombinedPrompt shape when the backend supports it
+	provider := llm.For(ctx)
+	schema := llm.SchemaFor(types.CombinedPrompt{})
+	constrained := llm.SupportsFormat(provider)
+	chatRequest := llm.ChatRequest{
 		Messages: messages,
-		Stream:   false, // Disable streaming
-	}
-
-	if chatData.Model == "" {
-		chatData.Model = "llama3.2" // Default model
-	}
-
-	// Make LLM API call to Ollama
-	body, err := json.Marshal(chatData)
-	if err != nil {
-		return nil, fmt.Errorf("error marshaling chat data: %w", err)
-	}
-
-	// Get API endpoint from environment variable or use default
-	apiEndpoint := os.Getenv("LLM_API_ENDPOINT")
-	if apiEndpoint == "" {
-		apiEndpoint = "http://localhost:11434/api/chat"
-	}
-
-	response, err := http.Post(apiEndpoint, "application/json", bytes.NewBuffer(body))
-	if err != nil {
-		return nil, fmt.Errorf("error making LLM API request: %w", err)
+		Options:  options,
 	}
-	defer response.Body.Close()
-
-	// Read the entire response body
-	respBody, err := io.ReadAll(response.Body)
-	if err != nil {
-		return nil, fmt.Errorf("error reading LLM response body: %w", err)
-	}
-
-	// Log the prompt and response for debugging
-	fmt.Printf("LLM Prompt Sent:\n%s\n", string(body))
-	fmt.Printf("LLM Response Received:\n%s\n", string(respBody))
-
-	// Define the struct to unmarshal the response
-	var llmResponse types.LLMResponse
-	err = json.Unmarshal(respBody, &llmResponse)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode LLM response: %w\nResponse body: %s", err, string(respBody))
-	}
-
-	assistantMessage := llmResponse.Message.Content
-
-	// Log the assistant's message separately
-	fmt.Printf("Assistant's Message Content:\n%s\n", assistantMessage)
-
-	// Clean and fix the assistant's message before parsing
-	assistantMessage = cleanAssistantMessage(assistantMessage)
-
-	// Do not alter escape sequences; keep assistant JSON as-is
-
-	// Attempt to extract JSON from the assistant's message
-	extractedJSON, extractErr := ExtractJSON(assistantMessage)
-	if extractErr != nil {
-		return nil, fmt.Errorf("failed to extract JSON from assistant's message: %w\nMessage content: %s", extractErr, assistantMessage)
+	if constrained {
+		chatRequest.Format = schema
 	}
 
-	// Clean common JSON issues like trailing commas
-	extractedJSON = removeTrailingCommas(extractedJSON)
-
-	// Now parse extractedJSON into CombinedPrompt
+	// Send the chat request to the commander models, repairing replies that
+	// fail to parse as CombinedPrompt
 	var combinedPrompt types.CombinedPrompt
-	err = json.Unmarshal([]byte(extractedJSON), &combinedPrompt)
+	chatResponse, err := chatWithRole(ctx, llm.RoleCommander, provider, chatRequest, schema, constrained, &combinedPrompt, userInput, onEvent, stream)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse extracted JSON as CombinedPrompt: %w\nExtracted JSON: %s", err, extractedJSON)
+		return nil, fmt.Errorf("failed to parse assistant's message as CombinedPrompt: %w", err)
 	}
+	combinedPrompt.Thinking = chatResponse.Thinking
 
 	// Post-process commands to correct any deviations
 	for i, cmd := range combinedPrompt.Commands {
@@ -208,6 +181,12 @@ func GetShellCommand(userInput string, chatHistory []types.PromptMessage, errorC
 		}
 	}
 
+	return &combinedPrompt, nil
+}
+
+// confirmVision asks the user for permission when the commands need the
+// vision model.
+func confirmVision(combinedPrompt *types.CombinedPrompt) error {
 	// Check if vision is needed and ask the user for permission
 	if combinedPrompt.VisionNeeded {
 		fmt.Println("The assistant requires access to the vision model to proceed. Do you allow this? (yes/no)")
@@ -215,11 +194,10 @@ func GetShellCommand(userInput string, chatHistory []types.PromptMessage, errorC
 		fmt.Print("> ")
 		fmt.Scanln(&userResponse)
 		if strings.ToLower(userResponse) != "yes" {
-			return nil, fmt.Errorf("user denied access to the vision model")
+			return fmt.Errorf("user denied access to the vision model")
 		}
 	}
-
-	return &combinedPrompt, nil
+	return nil
 }
 
 // HandleSmartAppControl handles simple app control requests intelligently
@@ -389,6 +367,13 @@ func summarizeSystemIndex(systemIndex string, username string) string {
 			"C:\\Windows",
 			"C:\\ProgramData",
 		}
+	} else if runtime.GOOS == "linux" {
+		keyDirs = []string{
+			"/usr/share/applications",
+			"/home/" + username,
+			"/opt",
+			"/usr",
+		}
 	} else {
 		keyDirs = []string{
 			"/Applications",
@@ -420,31 +405,19 @@ func sanitizeError(errorMsg string) string {
 	return strings.TrimSpace(errorMsg)
 }
 
-// escapeBackslashesInJSON escapes backslashes in JSON strings to ensure valid JSON parsing.
-func escapeBackslashesInJSON(s string) string {
-	// Replace single backslashes with double backslashes
-	return strings.ReplaceAll(s, `\`, `\\`)
-}
-
-// removeTrailingCommas removes trailing commas before closing brackets/braces in JSON.
-func removeTrailingCommas(s string) string {
-	// , ] -> ] and , } -> }
-	reCommaBeforeBracket := regexp.MustCompile(`,\s*]`)
-	s = reCommaBeforeBracket.ReplaceAllString(s, "]")
-	reCommaBeforeBrace := regexp.MustCompile(`,\s*}`)
-	s = reCommaBeforeBrace.ReplaceAllString(s, "}")
-	return s
-}
-
 // isCloseIntent returns true if the user's input is about closing/quitting an app.
 func isCloseIntent(userInput string) bool {
 	in := strings.ToLower(userInput)
 	return strings.Contains(in, "close") || strings.Contains(in, "quit") || strings.Contains(in, "exit") || strings.Contains(in, "stop")
 }
 
-// isValidQuitCommand checks if a command cleanly quits a macOS app via osascript.
+// isValidQuitCommand checks if a command cleanly quits an app: via osascript
+// on macOS, or pkill/killall by process name on Linux.
 func isValidQuitCommand(cmd string) bool {
 	c := strings.TrimSpace(cmd)
+	if runtime.GOOS == "linux" {
+		return regexp.MustCompile(`^(pkill|killall)(\s+-x)?\s+("[^"]+"|'[^']+'|\S+)$`).MatchString(c)
+	}
 	// Accept our normalized single-quoted AppleScript
 	if regexp.MustCompile(`(?i)^osascript\s+-e\s+'quit app "[^"]+"'$`).MatchString(c) {
 		return true
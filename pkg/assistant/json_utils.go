@@ -1,25 +1,312 @@
 package assistant
 
 import (
+	"WSA/pkg/llm"
 	"fmt"
-	"regexp"
 	"strings"
 )
 
-// ExtractJSON attempts to find and return the first JSON object or array in the input string.
+// ExtractJSON returns the first balanced JSON object or array in the input string.
 func ExtractJSON(input string) (string, error) {
-	// Remove any leading or trailing code fences or backticks
-	input = strings.TrimSpace(input)
-	input = strings.Trim(input, "```")
-	input = strings.TrimPrefix(input, "json")
-	input = strings.TrimSpace(input)
-
-	// Regular expression to match JSON objects or arrays
-	jsonRegex := regexp.MustCompile(`(?s)\{.*\}|\[.*\]`)
-	matches := jsonRegex.FindAllString(input, -1)
-	if len(matches) == 0 {
+	candidates := ExtractJSONCandidates(input)
+	if len(candidates) == 0 {
 		return "", fmt.Errorf("no JSON object or array found in the input")
 	}
-	// Return the first match
-	return matches[0], nil
+	return candidates[0], nil
+}
+
+// ExtractJSONCandidates returns every balanced top-level JSON object or array
+// in input, in order. Brackets inside quoted strings and // or /* */ comments
+// do not count towards the balance.
+func ExtractJSONCandidates(input string) []string {
+	var candidates []string
+	for i := 0; i < len(input); i++ {
+		if input[i] != '{' && input[i] != '[' {
+			continue
+		}
+		if end := matchBracket(input, i); end > 0 {
+			candidates = append(candidates, input[i:end+1])
+			i = end
+		}
+	}
+	return candidates
+}
+
+// decodeTolerant decodes the first candidate in message that matches schema,
+// trying each one as strict JSON and then normalized from JSON5.
+func decodeTolerant(message string, schema llm.Schema, target interface{}) error {
+	candidates := ExtractJSONCandidates(message)
+	if len(candidates) == 0 {
+		return fmt.Errorf("no JSON object or array found in the input")
+	}
+
+	var firstErr error
+	for _, candidate := range candidates {
+		err := llm.DecodeWithSchema([]byte(candidate), schema, target)
+		if err == nil {
+			return nil
+		}
+		if normalized, ok := normalizeJSON5(candidate); ok {
+			if err = llm.DecodeWithSchema([]byte(normalized), schema, target); err == nil {
+				return nil
+			}
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return fmt.Errorf("failed to parse extracted JSON: %w\nExtracted JSON: %s", firstErr, candidates[0])
+}
+
+// matchBracket returns the index of the bracket closing the one at s[start],
+// or -1 if it is never closed or the nesting does not match.
+func matchBracket(s string, start int) int {
+	var stack []byte
+	for i := start; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\'':
+			end, _, ok := scanString(s, i)
+			if !ok {
+				return -1
+			}
+			i = end
+		case '/':
+			if end, ok := skipComment(s, i); ok {
+				i = end
+			}
+		case '{':
+			stack = append(stack, '}')
+		case '[':
+			stack = append(stack, ']')
+		case '}', ']':
+			if len(stack) == 0 || stack[len(stack)-1] != c {
+				return -1
+			}
+			stack = stack[:len(stack)-1]
+			if len(stack) == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// normalizeJSON5 rewrites a JSON5-style document as strict JSON: comments and
+// trailing commas are dropped, single-quoted strings and bare keys are
+// double-quoted, and string contents are re-escaped by scanString.
+func normalizeJSON5(s string) (string, bool) {
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\'':
+			end, literal, ok := scanString(s, i)
+			if !ok {
+				return "", false
+			}
+			out.WriteString(literal)
+			i = end
+		case c == '/':
+			end, ok := skipComment(s, i)
+			if !ok {
+				out.WriteByte(c)
+				continue
+			}
+			i = end
+		case c == ',':
+			if next := nextSignificant(s, i+1); next < len(s) && (s[next] == '}' || s[next] == ']') {
+				continue
+			}
+			out.WriteByte(c)
+		case isIdentStart(c):
+			end := i
+			for end < len(s) && (isIdentStart(s[end]) || (s[end] >= '0' && s[end] <= '9')) {
+				end++
+			}
+			ident := s[i:end]
+			if next := nextSignificant(s, end); next < len(s) && s[next] == ':' {
+				out.WriteString(`"` + ident + `"`)
+			} else {
+				out.WriteString(ident)
+			}
+			i = end - 1
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return out.String(), true
+}
+
+// scanString reads the single- or double-quoted string starting at s[start].
+// It returns the index of the closing quote and the contents as a strict JSON
+// string literal. Models often leave inner quotes unescaped or escape them
+// twice (\\"), so a quote only closes the string when followed by a structural
+// character, and invalid escapes such as Windows paths keep their backslash.
+func scanString(s string, start int) (int, string, bool) {
+	quote := s[start]
+	var (
+		out    strings.Builder
+		quotes int // literal double quotes in the value so far
+	)
+	out.WriteByte('"')
+	for i := start + 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\':
+			if i+1 >= len(s) {
+				return -1, "", false
+			}
+			next := s[i+1]
+			if next == '\\' && i+2 < len(s) && s[i+2] == quote {
+				// A doubled escape before a quote. It closes the string only
+				// if it balances the quotes already in the value.
+				if closesString(s, i+3) && quotes%2 == 0 {
+					out.WriteString(`\\"`)
+					return i + 2, out.String(), true
+				}
+				writeQuote(&out, quote, &quotes)
+				if closesString(s, i+3) {
+					out.WriteByte('"')
+					return i + 2, out.String(), true
+				}
+				i += 2
+				continue
+			}
+			switch next {
+			case '"', '\'':
+				writeQuote(&out, next, &quotes)
+			case '\\', '/', 'b', 'f', 'n', 'r', 't':
+				out.WriteByte('\\')
+				out.WriteByte(next)
+			case 'u':
+				if i+6 <= len(s) && isHex(s[i+2:i+6]) {
+					out.WriteString(s[i : i+6])
+					i += 4
+				} else {
+					out.WriteString(`\\u`)
+				}
+			default:
+				out.WriteString(`\\`)
+				out.WriteByte(next)
+			}
+			i++
+		case c == quote:
+			if closesString(s, i+1) {
+				out.WriteByte('"')
+				return i, out.String(), true
+			}
+			writeQuote(&out, c, &quotes)
+		case c == '"':
+			writeQuote(&out, c, &quotes)
+		case c == '\n':
+			out.WriteString(`\n`)
+		case c == '\r':
+			out.WriteString(`\r`)
+		case c == '\t':
+			out.WriteString(`\t`)
+		case c < 0x20:
+			fmt.Fprintf(&out, `\u%04x`, c)
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return -1, "", false
+}
+
+func writeQuote(out *strings.Builder, quote byte, quotes *int) {
+	if quote == '"' {
+		out.WriteString(`\"`)
+		*quotes++
+		return
+	}
+	out.WriteByte(quote)
+}
+
+// closesString reports whether a quote followed by s[i:] ends a string, that
+// is whether the next significant character is structural or the input ends.
+func closesString(s string, i int) bool {
+	next := nextSignificant(s, i)
+	if next >= len(s) {
+		return true
+	}
+	switch s[next] {
+	case ',', ':', '}', ']':
+		return true
+	}
+	return false
+}
+
+// skipComment returns the index of the last character of the // or /* */
+// comment starting at s[i].
+func skipComment(s string, i int) (int, bool) {
+	if i+1 >= len(s) {
+		return i, false
+	}
+	switch s[i+1] {
+	case '/':
+		if end := strings.IndexByte(s[i:], '\n'); end >= 0 {
+			return i + end - 1, true
+		}
+		return len(s) - 1, true
+	case '*':
+		if end := strings.Index(s[i+2:], "*/"); end >= 0 {
+			return i + 2 + end + 1, true
+		}
+		return len(s) - 1, true
+	}
+	return i, false
+}
+
+// nextSignificant returns the index of the next character at or after i that
+// is neither whitespace nor part of a comment.
+func nextSignificant(s string, i int) int {
+	for i < len(s) {
+		switch s[i] {
+		case ' ', '\t', '\n', '\r':
+			i++
+		case '/':
+			end, ok := skipComment(s, i)
+			if !ok {
+				return i
+			}
+			i = end + 1
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= '0' && c <= '9') && !(c >= 'a' && c <= 'f') && !(c >= 'A' && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// parseStructuredReply decodes a model reply into target and validates it
+// against schema. Replies from schema-constrained backends are decoded as-is
+// first, since servers do not always honour the schema; otherwise, or when
+// that fails, each object or array in the reply is tried in turn, tolerating
+// surrounding prose, comments, single quotes, trailing commas and bad escapes.
+func parseStructuredReply(message string, schema llm.Schema, constrained bool, target interface{}) error {
+	if constrained {
+		if err := llm.DecodeWithSchema([]byte(strings.TrimSpace(message)), schema, target); err == nil {
+			return nil
+		}
+	}
+
+	// Strip code fences, then try each JSON candidate in the reply in turn
+	message = cleanAssistantMessage(message)
+	if err := decodeTolerant(message, schema, target); err != nil {
+		return fmt.Errorf("%w\nMessage content: %s", err, message)
+	}
+	return nil
 }
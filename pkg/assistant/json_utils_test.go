@@ -0,0 +1,144 @@
+package assistant
+
+import (
+	"WSA/pkg/llm"
+	"reflect"
+	"testing"
+)
+
+type tolerantReply struct {
+	Name  string   `json:"name"`
+	Items []string `json:"items"`
+}
+
+func TestDecodeTolerant(t *testing.T) {
+	schema := llm.SchemaFor(tolerantReply{})
+	tests := []struct {
+		name    string
+		message string
+		want    tolerantReply
+		wantErr bool
+	}{
+		{
+			name:    "plain JSON",
+			message: `{"name": "a", "items": ["x"]}`,
+			want:    tolerantReply{Name: "a", Items: []string{"x"}},
+		},
+		{
+			name: "line and block comments",
+			message: `{
+				// the name
+				"name": "a", /* items follow */
+				"items": ["x"]
+			}`,
+			want: tolerantReply{Name: "a", Items: []string{"x"}},
+		},
+		{
+			name:    "brackets in comments and strings",
+			message: `{"name": "a]}", /* } */ "items": ["[x"]}`,
+			want:    tolerantReply{Name: "a]}", Items: []string{"[x"}},
+		},
+		{
+			name:    "prose around the JSON",
+			message: `Here you go: {"name": "a", "items": []} Hope that helps!`,
+			want:    tolerantReply{Name: "a", Items: []string{}},
+		},
+		{
+			name:    "first candidate does not match the schema",
+			message: `Example: {"foo": 1}. Answer: {"name": "b", "items": ["y"]}`,
+			want:    tolerantReply{Name: "b", Items: []string{"y"}},
+		},
+		{
+			name:    "first matching candidate wins",
+			message: `{"name": "a", "items": []} {"name": "b", "items": []}`,
+			want:    tolerantReply{Name: "a", Items: []string{}},
+		},
+		{
+			name:    "single quotes and bare keys",
+			message: `{name: 'a', 'items': ['it's', "y"]}`,
+			want:    tolerantReply{Name: "a", Items: []string{"it's", "y"}},
+		},
+		{
+			name:    "double quotes inside single quotes",
+			message: `{'name': 'say "hi"', 'items': []}`,
+			want:    tolerantReply{Name: `say "hi"`, Items: []string{}},
+		},
+		{
+			name:    "trailing commas",
+			message: `{"name": "a", "items": ["x", "y",],}`,
+			want:    tolerantReply{Name: "a", Items: []string{"x", "y"}},
+		},
+		{
+			name: "trailing comma before a comment",
+			message: `{"name": "a", "items": ["x", // last
+			]}`,
+			want: tolerantReply{Name: "a", Items: []string{"x"}},
+		},
+		{
+			name:    "doubled escapes around inner quotes",
+			message: `{"name": "echo \\"hi\\"", "items": []}`,
+			want:    tolerantReply{Name: `echo "hi"`, Items: []string{}},
+		},
+		{
+			name:    "unescaped inner quotes",
+			message: `{"name": "echo "hi" now", "items": []}`,
+			want:    tolerantReply{Name: `echo "hi" now`, Items: []string{}},
+		},
+		{
+			name:    "invalid escapes of a Windows path",
+			message: `{"name": "C:\Users\me", "items": []}`,
+			want:    tolerantReply{Name: `C:\Users\me`, Items: []string{}},
+		},
+		{
+			name:    "raw newline in a string",
+			message: "{\"name\": \"a\nb\", \"items\": []}",
+			want:    tolerantReply{Name: "a\nb", Items: []string{}},
+		},
+		{
+			name:    "no JSON",
+			message: `I cannot help with that.`,
+			wantErr: true,
+		},
+		{
+			name:    "no candidate matches the schema",
+			message: `{"foo": 1} {"name": 2}`,
+			wantErr: true,
+		},
+		{
+			name:    "unclosed object",
+			message: `{"name": "a", "items": [`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got tolerantReply
+			err := decodeTolerant(tt.message, schema, &got)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("decodeTolerant(%q) = %+v, want an error", tt.message, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodeTolerant(%q) failed: %v", tt.message, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decodeTolerant(%q) = %+v, want %+v", tt.message, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseStructuredReplyFallsBackWhenConstrained(t *testing.T) {
+	schema := llm.SchemaFor(tolerantReply{})
+	var got tolerantReply
+	err := parseStructuredReply("```json\n{'name': 'a', 'items': [],}\n```", schema, true, &got)
+	if err != nil {
+		t.Fatalf("parseStructuredReply failed: %v", err)
+	}
+	if got.Name != "a" {
+		t.Errorf("parseStructuredReply decoded %+v, want name a", got)
+	}
+}
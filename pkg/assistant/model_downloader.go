@@ -1,54 +1,99 @@
 package assistant
 
 import (
+	"WSA/pkg/llm"
+	"context"
+	"errors"
 	"fmt"
-	"os/exec"
-	"strings"
 )
 
-// PullModel ensures that the specified models are available
+// PullModel ensures that the specified model is available, downloading it
+// through a pull job when it is missing. Without a name it ensures the first
+// model of each text role and, unless an installed model already supports
+// vision, the first vision model. Every missing model is attempted; the
+// failures are returned together.
 func PullModel(modelName string) error {
-	modelsToPull := []string{"llama3.2", "llava"}
+	provider, err := llm.Named(llm.ProviderOllama)
+	if err != nil {
+		return err
+	}
+
+	// Check which models are already available
+	installed, err := provider.ListModels(context.Background())
+	if err != nil {
+		// If ollama is not installed or not running, skip pulling to avoid fatal error
+		fmt.Printf("Ollama not available, skipping model checks and pulls. Error: %v\n", err)
+		return nil
+	}
 
-	if modelName != "" {
-		modelsToPull = []string{modelName}
+	modelsToPull := []string{modelName}
+	if modelName == "" {
+		modelsToPull = defaultModels(provider)
 	}
 
+	var errs []error
 	for _, model := range modelsToPull {
 		fmt.Printf("Checking if %s model needs to be pulled...\n", model)
+		if isInstalled(installed, model) {
+			fmt.Printf("Model %s is already available.\n", model)
+			continue
+		}
 
-		// Sanitize model name
-		safeModelName := strings.ReplaceAll(model, "\"", "")
-		safeModelName = strings.ReplaceAll(safeModelName, ";", "")
-		safeModelName = strings.TrimSpace(safeModelName)
-
-		// Check if the model is already available
-		cmd := exec.Command("ollama", "list")
-		output, err := cmd.CombinedOutput()
+		fmt.Printf("Pulling %s model...\n", model)
+		job, err := StartPull(model)
+		if err == nil {
+			err = job.Wait()
+		}
 		if err != nil {
-			// If ollama is not installed or not running, skip pulling to avoid fatal error
-			fmt.Printf("Ollama not available, skipping model checks and pulls. Error: %v\n", err)
-			return nil
+			errs = append(errs, err)
 		}
+	}
 
-		if strings.Contains(string(output), safeModelName) {
-			fmt.Printf("Model %s is already available.\n", safeModelName)
-			continue
+	return errors.Join(errs...)
+}
+
+// defaultModels returns the first model of the planner, commander and
+// verifier roles, plus the first vision model when no installed model
+// supports vision.
+func defaultModels(provider llm.Provider) []string {
+	var models []string
+	for _, role := range []llm.Role{llm.RolePlanner, llm.RoleCommander, llm.RoleVerifier} {
+		model := llm.RoleModels(context.Background(), role)[0]
+		if !containsModel(models, model) {
+			models = append(models, model)
 		}
+	}
 
-		// Pull the model
-		fmt.Printf("Pulling %s model...\n", safeModelName)
-		cmd = exec.Command("ollama", "pull", safeModelName)
-		output, err = cmd.CombinedOutput()
-		if err != nil {
-			fmt.Printf("Failed to pull %s model: %v\n", safeModelName, err)
-			fmt.Printf("Output: %s\n", output)
-			// Do not fail hard; continue without vision model
-			return nil
+	registry, err := llm.Registry(context.Background(), provider)
+	if err == nil {
+		for _, model := range registry {
+			if model.Known && model.Vision {
+				return models
+			}
 		}
+	}
+
+	visionModel := llm.RoleModels(context.Background(), llm.RoleVision)[0]
+	if containsModel(models, visionModel) {
+		return models
+	}
+	return append(models, visionModel)
+}
 
-		fmt.Printf("Successfully pulled %s model.\n", safeModelName)
+func containsModel(models []string, model string) bool {
+	for _, m := range models {
+		if m == model {
+			return true
+		}
 	}
+	return false
+}
 
-	return nil
+func isInstalled(installed []llm.ModelInfo, model string) bool {
+	for _, m := range installed {
+		if m.Name == model || m.Name == model+":latest" {
+			return true
+		}
+	}
+	return false
 }
@@ -1,55 +1,53 @@
 package assistant
 
 import (
-	"encoding/json"
+	"WSA/pkg/llm"
+	"context"
 	"fmt"
-	"net/http"
 	"strings"
 )
 
-// OllamaModel represents a model from Ollama API
-type OllamaModel struct {
-	Name       string `json:"name"`
-	ModifiedAt string `json:"modified_at"`
-	Size       int64  `json:"size"`
-}
-
-// OllamaModelsResponse represents the response from Ollama models API
-type OllamaModelsResponse struct {
-	Models []OllamaModel `json:"models"`
-}
-
-// GetAvailableModels fetches available models from Ollama
-func GetAvailableModels() ([]string, error) {
-	// Try to get models from Ollama API
-	resp, err := http.Get("http://localhost:11434/api/tags")
+// GetModelRegistry lists the models installed on Ollama and, when one is
+// configured, the OpenAI-compatible server, with their capabilities
+func GetModelRegistry() ([]llm.ModelCapabilities, error) {
+	ollama, err := llm.Named(llm.ProviderOllama)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to Ollama: %w", err)
+		return nil, err
+	}
+	registry, err := llm.Registry(context.Background(), ollama)
+	if err != nil && !llm.OpenAIConfigured() {
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Ollama API returned status %d", resp.StatusCode)
+	if llm.OpenAIConfigured() {
+		openai, openaiErr := llm.Named(llm.ProviderOpenAI)
+		if openaiErr == nil {
+			var openaiModels []llm.ModelCapabilities
+			openaiModels, openaiErr = llm.Registry(context.Background(), openai)
+			registry = append(registry, openaiModels...)
+		}
+		if openaiErr != nil && err != nil {
+			return nil, fmt.Errorf("%v; %v", err, openaiErr)
+		}
 	}
 
-	var modelsResp OllamaModelsResponse
-	if err := json.NewDecoder(resp.Body).Decode(&modelsResp); err != nil {
-		return nil, fmt.Errorf("failed to decode models response: %w", err)
+	return registry, nil
+}
+
+// GetAvailableModels returns the names of the installed models
+func GetAvailableModels() ([]string, error) {
+	registry, err := GetModelRegistry()
+	if err != nil {
+		return nil, err
 	}
 
-	var modelNames []string
-	for _, model := range modelsResp.Models {
+	modelNames := []string{}
+	for _, model := range registry {
 		// Clean up model names (remove :latest suffix if present)
-		name := model.Name
-		if strings.HasSuffix(name, ":latest") {
-			name = strings.TrimSuffix(name, ":latest")
+		name := strings.TrimSuffix(model.Name, ":latest")
+		if !containsString(modelNames, name) {
+			modelNames = append(modelNames, name)
 		}
-		modelNames = append(modelNames, name)
-	}
-
-	// If no models found, return default ones
-	if len(modelNames) == 0 {
-		return []string{"llama3.2", "gemma3:12b", "gpt-oss:20b"}, nil
 	}
 
 	return modelNames, nil
@@ -71,4 +69,160 @@ func SetDefaultModel(modelName string) error {
 	}
 
 	return fmt.Errorf("model '%s' not found in available models", modelName)
-}
\ No newline at end of file
+}
+
+// LoadModel loads model into memory for keepAlive (see llm.ModelLoader). A
+// model that is not installed is pulled first: the pull job is returned and
+// the model is loaded in the background once it completes.
+func LoadModel(model, keepAlive string) (*PullJob, error) {
+	provider, err := llm.Named(llm.ProviderOllama)
+	if err != nil {
+		return nil, err
+	}
+
+	installed, err := provider.ListModels(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if isInstalled(installed, model) {
+		return nil, llm.Load(context.Background(), provider, model, keepAlive)
+	}
+
+	job, err := StartPull(model)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		if job.Wait() != nil {
+			return
+		}
+		if err := llm.Load(context.Background(), provider, model, keepAlive); err != nil {
+			fmt.Printf("Failed to load %s model after pulling it: %v\n", model, err)
+		}
+	}()
+	return job, nil
+}
+
+// UnloadModel frees the memory held for model.
+func UnloadModel(model string) error {
+	provider, err := llm.Named(llm.ProviderOllama)
+	if err != nil {
+		return err
+	}
+	return llm.Unload(context.Background(), provider, model)
+}
+
+// GetLoadedModels returns the models currently held in memory.
+func GetLoadedModels() ([]llm.LoadedModel, error) {
+	provider, err := llm.Named(llm.ProviderOllama)
+	if err != nil {
+		return nil, err
+	}
+	return llm.Loaded(context.Background(), provider)
+}
+
+// PreloadModels loads the first planner and commander models so the first
+// goal does not wait for them. Failures are logged and otherwise ignored.
+func PreloadModels(keepAlive string) {
+	var models []string
+	for _, role := range []llm.Role{llm.RolePlanner, llm.RoleCommander} {
+		model := llm.RoleModels(context.Background(), role)[0]
+		if !containsModel(models, model) {
+			models = append(models, model)
+		}
+	}
+
+	for _, model := range models {
+		fmt.Printf("Preloading %s model...\n", model)
+		job, err := LoadModel(model, keepAlive)
+		if err == nil && job != nil {
+			err = job.Wait()
+		}
+		if err != nil {
+			fmt.Printf("Failed to preload %s model: %v\n", model, err)
+		}
+	}
+}
+
+// CommanderModel is the name of the model created by CreateCommanderModel.
+const CommanderModel = "wsa-commander"
+
+// commanderParameters are the options built into CommanderModel unless
+// overridden: a low temperature keeps the commands predictable.
+var commanderParameters = map[string]interface{}{"temperature": 0.2}
+
+// DeleteModel removes model from the Ollama model store.
+func DeleteModel(model string) error {
+	provider, err := llm.Named(llm.ProviderOllama)
+	if err != nil {
+		return err
+	}
+	return llm.Delete(context.Background(), provider, model)
+}
+
+// CopyModel stores model source under the name destination as well.
+func CopyModel(source, destination string) error {
+	provider, err := llm.Named(llm.ProviderOllama)
+	if err != nil {
+		return err
+	}
+	return llm.Copy(context.Background(), provider, source, destination)
+}
+
+// CreateCommanderModel creates CommanderModel from the installed model from,
+// by default the first commander model, with the shell command system prompt
+// and parameters built in. parameters are merged over the defaults. It
+// returns the name of the created model.
+func CreateCommanderModel(from string, parameters map[string]interface{}) (string, error) {
+	provider, err := llm.Named(llm.ProviderOllama)
+	if err != nil {
+		return "", err
+	}
+
+	if from == "" {
+		for _, model := range llm.RoleModels(context.Background(), llm.RoleCommander) {
+			// Rebuilding the model from itself would stack the prompt
+			if strings.TrimSuffix(model, ":latest") != CommanderModel {
+				from = model
+				break
+			}
+		}
+		if from == "" {
+			from = llm.DefaultChatModel
+		}
+	}
+
+	messages, promptVersion, err := shellCommandMessages(nil, "")
+	if err != nil {
+		return "", err
+	}
+
+	merged := map[string]interface{}{}
+	for name, value := range commanderParameters {
+		merged[name] = value
+	}
+	for name, value := range parameters {
+		merged[name] = value
+	}
+
+	fmt.Printf("Creating %s model from %s with prompt %s...\n", CommanderModel, from, promptVersion)
+	err = llm.Create(context.Background(), provider, llm.CreateModelRequest{
+		Model:      CommanderModel,
+		From:       from,
+		System:     messages[0].Content,
+		Parameters: merged,
+	})
+	if err != nil {
+		return "", err
+	}
+	return CommanderModel, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
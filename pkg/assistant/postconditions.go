@@ -0,0 +1,204 @@
+package assistant
+
+import (
+	"WSA/pkg/goalengine"
+	"WSA/pkg/types"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// DefaultPostconditionTimeout is how long postconditions are given to hold
+// when the goal does not say, as apps take a moment to start or quit.
+const DefaultPostconditionTimeout = 5 * time.Second
+
+// postconditionInterval is the pause between checks of failing postconditions.
+const postconditionInterval = 500 * time.Millisecond
+
+// CheckPostconditions checks conds until they all hold or timeout passes and
+// returns a description of each one that does not hold. The commands of
+// output_matches postconditions run only once, after the other
+// postconditions hold or time out, as they may not be safe to repeat. The
+// result of each postcondition is reported to onEvent as a "postcondition"
+// event.
+func CheckPostconditions(ctx context.Context, task string, conds []goalengine.Postcondition, timeout time.Duration, onEvent EventFunc) []string {
+	if timeout <= 0 {
+		timeout = DefaultPostconditionTimeout
+	}
+	deadline := time.Now().Add(timeout)
+
+	failures := make([]error, len(conds))
+	for {
+		pending := false
+		for i, cond := range conds {
+			if cond.Kind == goalengine.OutputMatches {
+				continue
+			}
+			failures[i] = CheckPostcondition(cond)
+			if failures[i] != nil {
+				pending = true
+			}
+		}
+		if !pending || ctx.Err() != nil || time.Now().After(deadline) {
+			break
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(postconditionInterval):
+		}
+	}
+	for i, cond := range conds {
+		if cond.Kind == goalengine.OutputMatches {
+			failures[i] = CheckPostcondition(cond)
+		}
+	}
+
+	var unmet []string
+	for i, cond := range conds {
+		message := fmt.Sprintf("Postcondition of '%s' holds: %s", task, cond)
+		if failures[i] != nil {
+			unmet = append(unmet, fmt.Sprintf("%s: %v", cond, failures[i]))
+			message = fmt.Sprintf("Postcondition of '%s' does not hold: %s: %v", task, cond, failures[i])
+		}
+		fmt.Println(message)
+		if onEvent != nil {
+			onEvent(types.StreamEvent{Type: "postcondition", Task: task, Data: message})
+		}
+	}
+	return unmet
+}
+
+// CheckPostcondition checks cond once and returns why it does not hold, or
+// nil if it does.
+func CheckPostcondition(cond goalengine.Postcondition) error {
+	if err := cond.Validate(); err != nil {
+		return err
+	}
+
+	switch cond.Kind {
+	case goalengine.ProcessRunning, goalengine.ProcessNotRunning:
+		running, err := isProcessRunning(cond.Target)
+		if err != nil {
+			return err
+		}
+		if running && cond.Kind == goalengine.ProcessNotRunning {
+			return errors.New("the process is running")
+		}
+		if !running && cond.Kind == goalengine.ProcessRunning {
+			return errors.New("no such process is running")
+		}
+	case goalengine.FileExists, goalengine.DirExists:
+		path := expandPath(cond.Target)
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("%s does not exist", path)
+		}
+		if info.IsDir() && cond.Kind == goalengine.FileExists {
+			return fmt.Errorf("%s is a directory", path)
+		}
+		if !info.IsDir() && cond.Kind == goalengine.DirExists {
+			return fmt.Errorf("%s is not a directory", path)
+		}
+	case goalengine.AppFrontmost:
+		frontmost, _, err := frontmostApp()
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(frontmost, processName(cond.Target)) {
+			return fmt.Errorf("the frontmost app is %s", frontmost)
+		}
+	case goalengine.OutputMatches:
+		output, err := RunShellCommand(cond.Target)
+		if err != nil {
+			return err
+		}
+		if !regexp.MustCompile(cond.Pattern).MatchString(output) {
+			return fmt.Errorf("the output does not match: %s", strings.TrimSpace(output))
+		}
+	}
+	return nil
+}
+
+// isProcessRunning reports whether a process named name is running,
+// ignoring case. On macOS running apps are matched by name as well, since an
+// app's process can be named differently.
+func isProcessRunning(name string) (bool, error) {
+	err := exec.Command("pgrep", "-i", "-x", processName(name)).Run()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 1:
+		// No process matched
+	default:
+		return false, fmt.Errorf("failed to list processes: %w", err)
+	}
+
+	if runtime.GOOS != "darwin" {
+		return false, nil
+	}
+	apps, err := GetRunningApplications()
+	if err != nil {
+		return false, err
+	}
+	for _, app := range apps {
+		if strings.EqualFold(app.Name, name) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// processName returns name as the process table shows it: Linux truncates
+// process names to 15 characters.
+func processName(name string) string {
+	if runtime.GOOS == "linux" && len(name) > 15 {
+		return name[:15]
+	}
+	return name
+}
+
+// frontmostApp returns the name of the app whose window has the focus and,
+// on Linux, the title of that window. On Linux the name is the process name
+// of the active window, which needs xdotool.
+func frontmostApp() (name, title string, err error) {
+	if runtime.GOOS == "darwin" {
+		output, err := exec.Command("osascript", "-e",
+			`tell application "System Events" to get name of first application process whose frontmost is true`).Output()
+		if err != nil {
+			return "", "", fmt.Errorf("failed to get the frontmost app: %w", err)
+		}
+		return strings.TrimSpace(string(output)), "", nil
+	}
+
+	pid, err := exec.Command("xdotool", "getactivewindow", "getwindowpid").Output()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get the active window: %w", err)
+	}
+	comm, err := os.ReadFile(filepath.Join("/proc", strings.TrimSpace(string(pid)), "comm"))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get the active window's process: %w", err)
+	}
+	if output, err := exec.Command("xdotool", "getactivewindow", "getwindowname").Output(); err == nil {
+		title = strings.TrimSpace(string(output))
+	}
+	return strings.TrimSpace(string(comm)), title, nil
+}
+
+// expandPath expands a leading ~ and environment variables in path.
+func expandPath(path string) string {
+	path = os.ExpandEnv(path)
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, path[1:])
+		}
+	}
+	return path
+}
@@ -0,0 +1,256 @@
+package assistant
+
+import (
+	"WSA/pkg/llm"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// States of a PullJob.
+const (
+	PullRunning   = "running"
+	PullSucceeded = "succeeded"
+	PullFailed    = "failed"
+	PullCancelled = "cancelled"
+)
+
+// PullLayer is the download progress of one layer of a model.
+type PullLayer struct {
+	Digest    string `json:"digest"`
+	Total     int64  `json:"total"`
+	Completed int64  `json:"completed"`
+}
+
+// PullStatus is a snapshot of a PullJob.
+type PullStatus struct {
+	ID        string      `json:"id"`
+	Model     string      `json:"model"`
+	State     string      `json:"state"`
+	Status    string      `json:"status"`    // Latest status line from the server, e.g. "pulling manifest"
+	Completed int64       `json:"completed"` // Bytes downloaded over all layers
+	Total     int64       `json:"total"`     // Bytes to download over all layers seen so far
+	Layers    []PullLayer `json:"layers"`
+	Error     string      `json:"error,omitempty"`
+	DiskFull  bool        `json:"diskFull,omitempty"` // The pull failed because the disk is full
+	StartedAt time.Time   `json:"startedAt"`
+	EndedAt   *time.Time  `json:"endedAt,omitempty"`
+}
+
+// PullJob downloads a model in the background.
+type PullJob struct {
+	mu          sync.Mutex
+	status      PullStatus
+	layers      map[string]*PullLayer
+	cancel      context.CancelFunc
+	done        chan struct{}
+	err         error
+	subscribers map[chan PullStatus]struct{}
+}
+
+var (
+	pullMu   sync.Mutex
+	pullJobs = map[string]*PullJob{}
+	pullSeq  int
+)
+
+// StartPull starts downloading model from the Ollama server in the
+// background. A pull of the same model that is still running is returned
+// instead of starting another.
+func StartPull(model string) (*PullJob, error) {
+	provider, err := llm.Named(llm.ProviderOllama)
+	if err != nil {
+		return nil, err
+	}
+
+	pullMu.Lock()
+	defer pullMu.Unlock()
+	for _, job := range pullJobs {
+		if status := job.Status(); status.Model == model && status.State == PullRunning {
+			return job, nil
+		}
+	}
+
+	pullSeq++
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &PullJob{
+		status: PullStatus{
+			ID:        fmt.Sprintf("pull-%d", pullSeq),
+			Model:     model,
+			State:     PullRunning,
+			Status:    "starting",
+			StartedAt: time.Now(),
+		},
+		layers:      map[string]*PullLayer{},
+		cancel:      cancel,
+		done:        make(chan struct{}),
+		subscribers: map[chan PullStatus]struct{}{},
+	}
+	pullJobs[job.status.ID] = job
+
+	go func() {
+		err := llm.Pull(ctx, provider, model, job.progress)
+		job.finish(ctx, err)
+	}()
+	return job, nil
+}
+
+// PullJobByID returns the pull with the given ID.
+func PullJobByID(id string) (*PullJob, bool) {
+	pullMu.Lock()
+	defer pullMu.Unlock()
+	job, ok := pullJobs[id]
+	return job, ok
+}
+
+// PullJobs returns a snapshot of every pull, oldest first.
+func PullJobs() []PullStatus {
+	pullMu.Lock()
+	jobs := make([]*PullJob, 0, len(pullJobs))
+	for _, job := range pullJobs {
+		jobs = append(jobs, job)
+	}
+	pullMu.Unlock()
+
+	statuses := make([]PullStatus, 0, len(jobs))
+	for _, job := range jobs {
+		statuses = append(statuses, job.Status())
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].StartedAt.Before(statuses[j].StartedAt) })
+	return statuses
+}
+
+// Status returns a snapshot of the job.
+func (j *PullJob) Status() PullStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.snapshot()
+}
+
+// Cancel stops the download. It has no effect once the job has ended.
+func (j *PullJob) Cancel() {
+	j.cancel()
+}
+
+// Wait blocks until the job ends and returns its error.
+func (j *PullJob) Wait() error {
+	<-j.done
+	return j.err
+}
+
+// Subscribe returns a channel that receives the job's status after every
+// update and is closed when the job ends, and a function to unsubscribe.
+// Updates are dropped for subscribers that fall behind, except the last.
+func (j *PullJob) Subscribe() (<-chan PullStatus, func()) {
+	ch := make(chan PullStatus, 16)
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	ch <- j.snapshot()
+	if j.status.State != PullRunning {
+		close(ch)
+		return ch, func() {}
+	}
+	j.subscribers[ch] = struct{}{}
+	return ch, func() {
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		if _, ok := j.subscribers[ch]; ok {
+			delete(j.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+func (j *PullJob) progress(p llm.PullProgress) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if p.Digest == "" && p.Status != j.status.Status {
+		fmt.Printf("Pull of %s: %s\n", j.status.Model, p.Status)
+	}
+	j.status.Status = p.Status
+	if p.Digest != "" {
+		layer, ok := j.layers[p.Digest]
+		if !ok {
+			fmt.Printf("Pull of %s: %s\n", j.status.Model, p.Status)
+			layer = &PullLayer{Digest: p.Digest}
+			j.layers[p.Digest] = layer
+		}
+		if p.Total > 0 {
+			layer.Total = p.Total
+		}
+		if p.Completed > layer.Completed {
+			layer.Completed = p.Completed
+		}
+	}
+	j.publish()
+}
+
+func (j *PullJob) finish(ctx context.Context, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := time.Now()
+	j.status.EndedAt = &now
+	switch {
+	case err == nil:
+		j.status.State = PullSucceeded
+		fmt.Printf("Successfully pulled %s model.\n", j.status.Model)
+	case ctx.Err() != nil:
+		j.status.State = PullCancelled
+		j.status.Error = "pull cancelled"
+		err = fmt.Errorf("pull of %s cancelled", j.status.Model)
+	default:
+		j.status.State = PullFailed
+		j.status.Error = err.Error()
+		j.status.DiskFull = errors.Is(err, llm.ErrDiskFull)
+		fmt.Printf("Failed to pull %s model: %v\n", j.status.Model, err)
+	}
+	j.err = err
+	j.cancel()
+
+	j.publish()
+	for ch := range j.subscribers {
+		close(ch)
+	}
+	j.subscribers = map[chan PullStatus]struct{}{}
+	close(j.done)
+}
+
+// publish sends the current status to every subscriber. Callers hold j.mu.
+func (j *PullJob) publish() {
+	status := j.snapshot()
+	for ch := range j.subscribers {
+		select {
+		case ch <- status:
+		default:
+			// Make room for the newest status by dropping the oldest
+			select {
+			case <-ch:
+			default:
+			}
+			select {
+			case ch <- status:
+			default:
+			}
+		}
+	}
+}
+
+// snapshot copies the status with totals over all layers. Callers hold j.mu.
+func (j *PullJob) snapshot() PullStatus {
+	status := j.status
+	status.Layers = make([]PullLayer, 0, len(j.layers))
+	status.Completed, status.Total = 0, 0
+	for _, layer := range j.layers {
+		status.Layers = append(status.Layers, *layer)
+		status.Completed += layer.Completed
+		status.Total += layer.Total
+	}
+	sort.Slice(status.Layers, func(a, b int) bool { return status.Layers[a].Digest < status.Layers[b].Digest })
+	return status
+}
@@ -0,0 +1,96 @@
+package assistant
+
+import (
+	"WSA/pkg/llm"
+	"WSA/pkg/types"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// MaxRepairAttempts bounds how many times a reply that fails to parse is sent
+// back to the model for correction.
+const MaxRepairAttempts = 2
+
+// chatAndParse sends req, streaming the first reply to stream if set, and
+// parses the reply into target. When parsing fails, the model is shown its own
+// output and the exact parser error and asked for a corrected object, up to
+// MaxRepairAttempts times. The outcome of every parse attempt, including a
+// first-attempt success, is reported to onEvent as a "repair" event.
+func chatAndParse(ctx context.Context, provider llm.Provider, req llm.ChatRequest, schema llm.Schema, constrained bool, target interface{}, task string, onEvent EventFunc, stream llm.StreamFunc) (*llm.ChatResponse, error) {
+	report := llm.FitContext(ctx, provider, &req)
+	chatResponse, err := llm.ChatStream(ctx, provider, req, stream)
+	if err != nil {
+		return nil, err
+	}
+	emitTokens(onEvent, task, report, chatResponse)
+
+	messages := append([]types.PromptMessage{}, req.Messages...)
+	for attempt := 1; ; attempt++ {
+		// Log the assistant's message separately
+		fmt.Printf("Assistant's Message Content:\n%s\n", chatResponse.Content)
+
+		if attempt > 1 {
+			// Drop whatever the failed attempt decoded before parsing the repair
+			reflect.ValueOf(target).Elem().Set(reflect.Zero(reflect.TypeOf(target).Elem()))
+		}
+		parseErr := parseStructuredReply(chatResponse.Content, schema, constrained, target)
+		if parseErr == nil {
+			emitRepair(onEvent, task, fmt.Sprintf("Parse attempt %d for '%s' with model %s succeeded.", attempt, task, req.Model))
+			return chatResponse, nil
+		}
+
+		reason := strings.SplitN(parseErr.Error(), "\n", 2)[0]
+		emitRepair(onEvent, task, fmt.Sprintf("Parse attempt %d for '%s' with model %s failed: %s", attempt, task, req.Model, reason))
+		if attempt > MaxRepairAttempts {
+			return nil, fmt.Errorf("reply could not be parsed after %d attempts: %w", attempt, parseErr)
+		}
+
+		schemaJSON, _ := json.Marshal(schema)
+		messages = append(messages,
+			types.PromptMessage{Role: "assistant", Content: chatResponse.Content},
+			types.PromptMessage{Role: "user", Content: "Your previous reply could not be parsed: " + reason + "\n" +
+				"Reply again with only the corrected JSON, no code fences or commentary, matching this JSON schema:\n" + string(schemaJSON)},
+		)
+
+		repairRequest := req
+		repairRequest.Messages = messages
+		report = llm.FitContext(ctx, provider, &repairRequest)
+		chatResponse, err = provider.Chat(ctx, repairRequest)
+		if err != nil {
+			return nil, err
+		}
+		emitTokens(onEvent, task, report, chatResponse)
+	}
+}
+
+func emitRepair(onEvent EventFunc, task, message string) {
+	fmt.Println(message)
+	if onEvent != nil {
+		onEvent(types.StreamEvent{Type: "repair", Task: task, Data: message})
+	}
+}
+
+// emitTokens completes report with the server's counts from resp and reports
+// it to onEvent as a "tokens" event. Responses served from the cache used no
+// tokens and are reported as a "cache" event instead.
+func emitTokens(onEvent EventFunc, task string, report *llm.TokenReport, resp *llm.ChatResponse) {
+	if !resp.CachedAt.IsZero() {
+		message := fmt.Sprintf("Cache hit for '%s' with model %s: reusing a response stored at %s.",
+			task, report.Model, resp.CachedAt.Format(time.RFC3339))
+		fmt.Println(message)
+		if onEvent != nil {
+			onEvent(types.StreamEvent{Type: "cache", Task: task, Data: message})
+		}
+		return
+	}
+
+	report.Record(resp)
+	fmt.Println(report.String())
+	if onEvent != nil {
+		onEvent(types.StreamEvent{Type: "tokens", Task: task, Data: report.String()})
+	}
+}
@@ -0,0 +1,95 @@
+package assistant
+
+import (
+	"WSA/pkg/llm"
+	"WSA/pkg/types"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// scriptedProvider answers each chat request with the next of its replies.
+type scriptedProvider struct {
+	replies []string
+	calls   int
+}
+
+func (p *scriptedProvider) Name() string { return "scripted" }
+
+func (p *scriptedProvider) Chat(ctx context.Context, req llm.ChatRequest) (*llm.ChatResponse, error) {
+	reply := p.replies[p.calls]
+	p.calls++
+	return &llm.ChatResponse{Model: req.Model, Content: reply}, nil
+}
+
+func (p *scriptedProvider) Generate(ctx context.Context, req llm.GenerateRequest) (*llm.GenerateResponse, error) {
+	return nil, nil
+}
+
+func (p *scriptedProvider) Vision(ctx context.Context, req llm.VisionRequest) (*llm.GenerateResponse, error) {
+	return nil, nil
+}
+
+func (p *scriptedProvider) ListModels(ctx context.Context) ([]llm.ModelInfo, error) {
+	return nil, nil
+}
+
+func TestChatAndParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		replies []string
+		want    tolerantReply
+		repairs []string
+		wantErr bool
+	}{
+		{
+			name:    "first attempt",
+			replies: []string{`{"name": "a", "items": []}`},
+			want:    tolerantReply{Name: "a", Items: []string{}},
+			repairs: []string{"Parse attempt 1 for 'task' with model m succeeded."},
+		},
+		{
+			name:    "repaired",
+			replies: []string{`{"name": 1, "items": ["stale"]}`, `{"name": "b"}`},
+			want:    tolerantReply{Name: "b"},
+			repairs: []string{"Parse attempt 1 for 'task' with model m failed", "Parse attempt 2 for 'task' with model m succeeded."},
+		},
+		{
+			name:    "never parses",
+			replies: []string{`no`, `still no`, `no again`},
+			repairs: []string{"Parse attempt 1", "Parse attempt 2", "Parse attempt 3"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &scriptedProvider{replies: tt.replies}
+			req := llm.ChatRequest{Model: "m", Messages: []types.PromptMessage{{Role: "user", Content: "go"}}}
+			var repairs []string
+			onEvent := func(event types.StreamEvent) {
+				if event.Type == "repair" {
+					repairs = append(repairs, event.Data)
+				}
+			}
+
+			var got tolerantReply
+			_, err := chatAndParse(context.Background(), provider, req, llm.SchemaFor(got), false, &got, "task", onEvent, nil)
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("chatAndParse() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("chatAndParse() decoded %+v, want %+v", got, tt.want)
+			}
+			if len(repairs) != len(tt.repairs) {
+				t.Fatalf("repair events = %q, want %d", repairs, len(tt.repairs))
+			}
+			for i, prefix := range tt.repairs {
+				if !strings.HasPrefix(repairs[i], prefix) {
+					t.Errorf("repair event %d = %q, want prefix %q", i, repairs[i], prefix)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package assistant
+
+import (
+	"WSA/pkg/llm"
+	"WSA/pkg/types"
+	"context"
+	"fmt"
+	"reflect"
+)
+
+// chatWithRole runs chatAndParse with each model of role's chain in turn
+// until one returns a reply that parses into target. Models that are not
+// installed or fail are reported to onEvent as "route" events.
+func chatWithRole(ctx context.Context, role llm.Role, provider llm.Provider, req llm.ChatRequest, schema llm.Schema, constrained bool, target interface{}, task string, onEvent EventFunc, stream llm.StreamFunc) (*llm.ChatResponse, error) {
+	var chatResponse *llm.ChatResponse
+	onFallback := func(model string, err error) {
+		emitRoute(onEvent, task, fmt.Sprintf("Skipping %s model %s for '%s': %v", role, model, task, err))
+	}
+	err := llm.WithFallback(ctx, provider, llm.RoleModels(ctx, role), onFallback, func(model string) error {
+		// Start each model from an empty target so a failed reply leaves nothing behind
+		reflect.ValueOf(target).Elem().Set(reflect.Zero(reflect.TypeOf(target).Elem()))
+		req.Model = model
+		var err error
+		chatResponse, err = chatAndParse(ctx, provider, req, schema, constrained, target, task, onEvent, stream)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return chatResponse, nil
+}
+
+func emitRoute(onEvent EventFunc, task, message string) {
+	fmt.Println(message)
+	if onEvent != nil {
+		onEvent(types.StreamEvent{Type: "route", Task: task, Data: message})
+	}
+}
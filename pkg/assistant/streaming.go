@@ -0,0 +1,113 @@
+package assistant
+
+import (
+	"WSA/pkg/llm"
+	"WSA/pkg/types"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// EventFunc receives progress events while a model reply is streamed.
+type EventFunc func(types.StreamEvent)
+
+// streamEvents adapts raw chat chunks into StreamEvents for onEvent.
+// Thinking deltas are forwarded as they arrive. If textKey is set, the growing
+// string value of that JSON key is emitted as nlResponse deltas. If itemKey is
+// set, each completed value of that key is emitted once as a plan event.
+// It returns nil when onEvent is nil so callers fall back to a plain request.
+func streamEvents(onEvent EventFunc, task, textKey, itemKey string) llm.StreamFunc {
+	if onEvent == nil {
+		return nil
+	}
+
+	var (
+		mu        sync.Mutex
+		buf       strings.Builder
+		textSent  int
+		itemsSent int
+	)
+	return func(chunk llm.StreamChunk) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if chunk.Thinking != "" {
+			onEvent(types.StreamEvent{Type: "thinking", Task: task, Data: chunk.Thinking})
+		}
+		if chunk.Content == "" {
+			return
+		}
+		buf.WriteString(chunk.Content)
+
+		if textKey != "" {
+			text := partialJSONString(buf.String(), textKey)
+			if len(text) > textSent {
+				onEvent(types.StreamEvent{Type: "nlResponse", Task: task, Data: text[textSent:]})
+				textSent = len(text)
+			}
+		}
+		if itemKey != "" {
+			items := completedJSONStrings(buf.String(), itemKey)
+			for ; itemsSent < len(items); itemsSent++ {
+				onEvent(types.StreamEvent{Type: "plan", Task: task, Data: items[itemsSent]})
+			}
+		}
+	}
+}
+
+// partialJSONString returns the value of key in a JSON document that may
+// still be arriving, including a string that has not been closed yet.
+func partialJSONString(doc, key string) string {
+	loc := jsonKeyPattern(key).FindStringIndex(doc)
+	if loc == nil {
+		return ""
+	}
+	value, _ := readJSONString(doc[loc[1]:])
+	return value
+}
+
+// completedJSONStrings returns every fully streamed string value of key.
+func completedJSONStrings(doc, key string) []string {
+	var values []string
+	for _, loc := range jsonKeyPattern(key).FindAllStringIndex(doc, -1) {
+		value, closed := readJSONString(doc[loc[1]:])
+		if !closed {
+			break
+		}
+		values = append(values, value)
+	}
+	return values
+}
+
+func jsonKeyPattern(key string) *regexp.Regexp {
+	return regexp.MustCompile(`"` + regexp.QuoteMeta(key) + `"\s*:\s*"`)
+}
+
+// readJSONString decodes a JSON string body starting just after its opening
+// quote. It reports whether the closing quote was found; an unfinished escape
+// at the end of s is dropped.
+func readJSONString(s string) (string, bool) {
+	end, closed := len(s), false
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' {
+			if i+1 >= len(s) || (s[i+1] == 'u' && i+6 > len(s)) {
+				end = i
+				break
+			}
+			i++
+			continue
+		}
+		if s[i] == '"' {
+			end, closed = i, true
+			break
+		}
+	}
+
+	raw := s[:end]
+	var value string
+	if err := json.Unmarshal([]byte(`"`+raw+`"`), &value); err != nil {
+		return raw, closed
+	}
+	return value, closed
+}
@@ -69,3 +69,28 @@ func LoadSystemIndex(indexFilePath string) (string, error) {
 	indexContent := strings.ReplaceAll(string(data), "\\", "\\\\")
 	return indexContent, nil
 }
+
+// SearchSystemIndex returns up to limit indexed directory paths containing
+// query, ignoring case.
+func SearchSystemIndex(indexFilePath string, query string, limit int) ([]string, error) {
+	query = strings.ToLower(strings.TrimSpace(query))
+	if query == "" {
+		return nil, fmt.Errorf("empty search query")
+	}
+
+	data, err := os.ReadFile(indexFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read system index file: %w", err)
+	}
+
+	var matches []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.Contains(strings.ToLower(line), query) {
+			matches = append(matches, line)
+			if len(matches) >= limit {
+				break
+			}
+		}
+	}
+	return matches, nil
+}
@@ -0,0 +1,75 @@
+package assistant
+
+import (
+	"WSA/pkg/prompts"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxSnapshotProcesses bounds how many process names a snapshot lists.
+const maxSnapshotProcesses = 100
+
+// SystemSnapshot describes the current state of the system for the planner:
+// the OS, the time, the home directory, the frontmost app and the running
+// apps. Parts that cannot be read are left out.
+func SystemSnapshot() string {
+	vars := prompts.DefaultVars()
+	var snapshot strings.Builder
+	fmt.Fprintf(&snapshot, "OS: %s (%s shell)\n", vars.OS, vars.Shell)
+	fmt.Fprintf(&snapshot, "Time: %s\n", time.Now().Format(time.RFC1123))
+	if home, err := os.UserHomeDir(); err == nil {
+		fmt.Fprintf(&snapshot, "Home directory: %s\n", home)
+	}
+	if frontmost, title, err := frontmostApp(); err == nil {
+		if title != "" {
+			frontmost += " (" + title + ")"
+		}
+		fmt.Fprintf(&snapshot, "Frontmost app: %s\n", frontmost)
+	}
+	if names, err := runningProcessNames(); err == nil {
+		fmt.Fprintf(&snapshot, "Running: %s\n", strings.Join(names, ", "))
+	}
+	return strings.TrimSpace(snapshot.String())
+}
+
+// runningProcessNames returns the sorted names of the running apps on macOS,
+// or of the user's running processes elsewhere.
+func runningProcessNames() ([]string, error) {
+	seen := map[string]bool{}
+	if runtime.GOOS == "darwin" {
+		apps, err := GetRunningApplications()
+		if err != nil {
+			return nil, err
+		}
+		for _, app := range apps {
+			seen[app.Name] = true
+		}
+	} else {
+		output, err := exec.Command("ps", "-U", strconv.Itoa(os.Getuid()), "-o", "comm=").Output()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list processes: %w", err)
+		}
+		for _, line := range strings.Split(string(output), "\n") {
+			// Skip kernel threads such as kworker/0:1, which root sees
+			if name := strings.TrimSpace(line); name != "" && !strings.Contains(name, "/") {
+				seen[name] = true
+			}
+		}
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) > maxSnapshotProcesses {
+		names = names[:maxSnapshotProcesses]
+	}
+	return names, nil
+}
@@ -2,29 +2,107 @@ package assistant
 
 import (
 	"WSA/pkg/goalengine"
+	"WSA/pkg/llm"
+	"WSA/pkg/prompts"
 	"WSA/pkg/types"
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
-	"io"
-	"net/http"
-	"os"
+	"strings"
 )
 
+// taskSpec is the shape of each task the planner model returns.
+// DependsOn numbers earlier tasks from 1 as they appear in the reply.
+type taskSpec struct {
+	Description string `json:"description"`
+	DependsOn   []int  `json:"dependsOn,omitempty"`
+	GUI         bool   `json:"gui,omitempty"`
+	// Postconditions that are not valid are dropped
+	Postconditions []goalengine.Postcondition `json:"postconditions,omitempty"`
+}
+
+// Plan is the planner's breakdown of a goal.
+type Plan struct {
+	Tasks         []*goalengine.Task
+	Thinking      string // Planner reasoning, if the model returned any
+	PromptVersion string // Version of the planner prompt template
+}
+
 // GenerateTasksFromGoal breaks down a high-level goal into tasks using the LLM
 func GenerateTasksFromGoal(goalDescription string) ([]*goalengine.Task, error) {
-	// Prepare the system prompt
-	systemPrompt := "You are an assistant that helps break down high-level goals into actionable tasks for a macOS-based operating system. " +
-		"When starting applications, always use the 'open -a appname' format (e.g., 'open -a TextEdit', 'open -a Spotify'). " +
-		"Do not include file paths, extensions, or any additional parameters in the descriptions. " +
-		"Please provide a single JSON array of tasks with descriptions only. " +
-		"**Do not include multiple JSON arrays or multiple copies of the response.** " +
-		"Do not include any additional text, explanations, or commentary.\n\n" +
-		"Response format strictly as follows:\n```json\n[\n  { \"description\": \"First task description\" },\n  { \"description\": \"Second task description\" }\n]\n" +
-		"```\nEnsure that the JSON is properly formatted and contains no syntax errors. **Do not include any other text outside the JSON array.**"
+	plan, err := GenerateTasksFromGoalStream(context.Background(), goalDescription, nil)
+	if err != nil {
+		return nil, err
+	}
+	return plan.Tasks, nil
+}
 
+// GenerateTasksFromGoalStream is GenerateTasksFromGoal with the model reply
+// streamed and the models configured by the llm.Options carried by ctx. Each
+// task description is passed to onEvent as a plan event as soon as it is
+// complete, along with any thinking deltas.
+func GenerateTasksFromGoalStream(ctx context.Context, goalDescription string, onEvent EventFunc) (*Plan, error) {
 	// Prepare the user message with the goal description
-	userMessage := "Goal: " + goalDescription
+	return planTasks(ctx, goalDescription, "Goal: "+goalDescription, onEvent)
+}
+
+// maxReplanFeedback bounds the feedback of each failed task shown to the
+// planner when replanning.
+const maxReplanFeedback = 1000
+
+// systemSnapshot takes the snapshot shown to the planner when replanning.
+var systemSnapshot = SystemSnapshot
+
+// ReplanTasks asks the planner for the tasks left to achieve goal after some
+// of its tasks failed. The planner is shown the goal, the tasks that
+// completed, the failures and a snapshot of the system, and the revised
+// tasks are streamed to onEvent like GenerateTasksFromGoalStream.
+func ReplanTasks(ctx context.Context, goal *goalengine.Goal, onEvent EventFunc) (*Plan, error) {
+	var message strings.Builder
+	message.WriteString("Goal: " + goal.Description + "\n")
+
+	message.WriteString("\nCompleted tasks:\n")
+	completed := 0
+	for _, task := range goal.Tasks {
+		if task.Status == goalengine.Completed {
+			message.WriteString("- " + task.Description + "\n")
+			completed++
+		}
+	}
+	if completed == 0 {
+		message.WriteString("(none)\n")
+	}
+
+	message.WriteString("\nTasks that did not complete:\n")
+	for _, task := range goal.Tasks {
+		if task.Status == goalengine.Completed {
+			continue
+		}
+		message.WriteString(fmt.Sprintf("- %s (%s)", task.Description, task.Status))
+		if feedback := sanitizeError(task.Feedback); feedback != "" {
+			if len(feedback) > maxReplanFeedback {
+				feedback = feedback[:maxReplanFeedback] + "..."
+			}
+			message.WriteString(": " + feedback)
+		}
+		message.WriteString("\n")
+	}
+
+	// The snapshot holds the time and the running apps, so replays reuse the
+	// recorded one to send the same prompt
+	snapshot := llm.Recorded(ctx, "systemSnapshot", systemSnapshot)
+	message.WriteString("\nCurrent system:\n" + snapshot + "\n")
+	message.WriteString("\nList only the tasks still needed to achieve the goal, taking a different approach to the tasks that failed. Do not repeat completed tasks; number the tasks from 1 again.")
+
+	return planTasks(ctx, goal.Description, message.String(), onEvent)
+}
+
+// planTasks asks the planner models to answer userMessage with a task list.
+func planTasks(ctx context.Context, goalDescription, userMessage string, onEvent EventFunc) (*Plan, error) {
+	// Prepare the system prompt
+	systemPrompt, promptVersion, err := prompts.Render(prompts.TaskPlan, prompts.DefaultVars())
+	if err != nil {
+		return nil, err
+	}
 
 	messages := []types.PromptMessage{
 		{
@@ -37,96 +115,57 @@ func GenerateTasksFromGoal(goalDescription string) ([]*goalengine.Task, error) {
 		},
 	}
 
-	// Prepare LLM request data
-	chatData := types.ChatData{
-		Model:    os.Getenv("LLM_MODEL"), // Model name from environment variable
+	// Constrain the reply to the task-list shape when the backend supports it
+	provider := llm.For(ctx)
+	schema := llm.SchemaFor([]taskSpec{})
+	constrained := llm.SupportsFormat(provider)
+	chatRequest := llm.ChatRequest{
 		Messages: messages,
-		Stream:   false, // Disable streaming
-	}
-
-	if chatData.Model == "" {
-		chatData.Model = "llama3.2" // Default model
 	}
-
-	// Make LLM API call to Ollama
-	body, err := json.Marshal(chatData)
-	if err != nil {
-		return nil, fmt.Errorf("error marshaling chat data: %w", err)
+	if constrained {
+		chatRequest.Format = schema
 	}
 
-	// Get API endpoint from environment variable or use default
-	apiEndpoint := os.Getenv("LLM_API_ENDPOINT")
-	if apiEndpoint == "" {
-		apiEndpoint = "http://localhost:11434/api/chat"
-	}
-
-	response, err := http.Post(apiEndpoint, "application/json", bytes.NewBuffer(body))
+	// Send the chat request to the planner models, repairing replies that
+	// fail to parse as a task list
+	var tasks []taskSpec
+	chatResponse, err := chatWithRole(ctx, llm.RolePlanner, provider, chatRequest, schema, constrained, &tasks, goalDescription, onEvent,
+		streamEvents(onEvent, "", "", "description"))
 	if err != nil {
-		return nil, fmt.Errorf("error making LLM API request: %w", err)
+		return nil, fmt.Errorf("failed to parse assistant's message as tasks: %w", err)
 	}
-	defer response.Body.Close()
 
-	// Read the entire response body
-	respBody, err := io.ReadAll(response.Body)
-	if err != nil {
-		return nil, fmt.Errorf("error reading LLM response body: %w", err)
-	}
-
-	// Log the prompt and response for debugging
-	fmt.Printf("LLM Request Sent:\n%s\n", string(body))
-	fmt.Printf("LLM Response Received:\n%s\n", string(respBody))
-
-	// Parse the response
-	var llmResponse types.LLMResponse
-	err = json.Unmarshal(respBody, &llmResponse)
-	if err != nil {
-		// Attempt to extract JSON from the response body
-		extractedJSON, extractErr := ExtractJSON(string(respBody))
-		if extractErr != nil {
-			return nil, fmt.Errorf("failed to decode LLM response: %w\nResponse body: %s", err, string(respBody))
+	// Convert to goalengine.Task, keeping only dependencies on earlier tasks
+	// so the plan cannot contain cycles
+	var goalTasks []*goalengine.Task
+	for i, t := range tasks {
+		var dependsOn []int
+		for _, n := range t.DependsOn {
+			if n >= 1 && n <= i {
+				dependsOn = append(dependsOn, n-1)
+			}
 		}
-
-		// Retry unmarshaling with the extracted JSON
-		err = json.Unmarshal([]byte(extractedJSON), &llmResponse)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse extracted JSON as LLM response: %w\nExtracted JSON: %s", err, extractedJSON)
+		var postconditions []goalengine.Postcondition
+		for _, cond := range t.Postconditions {
+			if err := cond.Validate(); err != nil {
+				fmt.Printf("Dropping postcondition of task '%s': %v\n", t.Description, err)
+				continue
+			}
+			postconditions = append(postconditions, cond)
 		}
-	}
-
-	assistantMessage := llmResponse.Message.Content
-
-	// Log the assistant's message separately
-	fmt.Printf("Assistant's Message Content:\n%s\n", assistantMessage)
-
-	// Escape backslashes in the assistant's message
-	assistantMessage = escapeBackslashesInJSON(assistantMessage)
-
-	// Now parse assistantMessage into a list of tasks
-	var tasks []struct {
-		Description string `json:"description"`
-	}
-
-	// Attempt to extract JSON from the assistant's message
-	extractedJSON, extractErr := ExtractJSON(assistantMessage)
-	if extractErr != nil {
-		return nil, fmt.Errorf("failed to extract JSON from assistant's message: %w\nMessage content: %s", extractErr, assistantMessage)
-	}
-
-	// Parse the extracted JSON
-	err = json.Unmarshal([]byte(extractedJSON), &tasks)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse extracted JSON as tasks: %w\nExtracted JSON: %s", err, extractedJSON)
-	}
-
-	// Convert to goalengine.Task
-	var goalTasks []*goalengine.Task
-	for _, t := range tasks {
 		goalTasks = append(goalTasks, &goalengine.Task{
-			Description: t.Description,
-			Status:      goalengine.Pending,
-			MaxRetries:  3,
+			Description:    t.Description,
+			Status:         goalengine.Pending,
+			MaxRetries:     3,
+			DependsOn:      dependsOn,
+			GUI:            t.GUI,
+			Postconditions: postconditions,
 		})
 	}
 
-	return goalTasks, nil
+	return &Plan{
+		Tasks:         goalTasks,
+		Thinking:      chatResponse.Thinking,
+		PromptVersion: promptVersion,
+	}, nil
 }
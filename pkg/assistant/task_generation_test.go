@@ -0,0 +1,89 @@
+package assistant
+
+import (
+	"WSA/pkg/goalengine"
+	"WSA/pkg/llm"
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// planAndReplan plans goal, fails its last task and replans it, returning
+// the descriptions of both plans.
+func planAndReplan(ctx context.Context) ([]string, error) {
+	plan, err := GenerateTasksFromGoalStream(ctx, "write notes", nil)
+	if err != nil {
+		return nil, fmt.Errorf("planning failed: %w", err)
+	}
+	goal := &goalengine.Goal{Description: "write notes"}
+	goal.AddPlan(&goalengine.Plan{Tasks: plan.Tasks})
+	for _, task := range goal.Tasks {
+		task.Status = goalengine.Completed
+	}
+	last := goal.Tasks[len(goal.Tasks)-1]
+	last.Status, last.Feedback = goalengine.Failed, "permission denied"
+
+	replan, err := ReplanTasks(ctx, goal, nil)
+	if err != nil {
+		return nil, fmt.Errorf("replanning failed: %w", err)
+	}
+	var descriptions []string
+	for _, task := range append(plan.Tasks, replan.Tasks...) {
+		descriptions = append(descriptions, task.Description)
+	}
+	return descriptions, nil
+}
+
+func TestReplanTasksReplaysRecordedSnapshot(t *testing.T) {
+	snapshots := 0
+	systemSnapshot = func() string {
+		snapshots++
+		return fmt.Sprintf("Time: snapshot %d", snapshots)
+	}
+	t.Cleanup(func() { systemSnapshot = SystemSnapshot })
+	llm.SetDefault(&scriptedProvider{replies: []string{
+		`[{"description": "open the editor"}, {"description": "save notes.txt", "dependsOn": [1]}]`,
+		`[{"description": "save notes.txt with sudo"}]`,
+	}})
+	t.Cleanup(func() { llm.SetDefault(nil) })
+
+	cassette := llm.NewCassette()
+	recorded, err := planAndReplan(llm.Record(context.Background(), cassette))
+	if err != nil {
+		t.Fatalf("recording: %v", err)
+	}
+	want := []string{"open the editor", "save notes.txt", "save notes.txt with sudo"}
+	if !reflect.DeepEqual(recorded, want) {
+		t.Fatalf("recorded plans %v, want %v", recorded, want)
+	}
+	path := filepath.Join(t.TempDir(), "replan.json")
+	if err := cassette.Save(path); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loaded, err := llm.LoadCassette(path)
+	if err != nil {
+		t.Fatalf("LoadCassette failed: %v", err)
+	}
+
+	// The snapshot taken now differs from the recorded one; the replay must
+	// send the recorded one
+	replayed, err := planAndReplan(llm.Replay(context.Background(), loaded))
+	if err != nil {
+		t.Fatalf("replaying: %v", err)
+	}
+	if !reflect.DeepEqual(replayed, recorded) {
+		t.Errorf("replayed plans %v, want %v", replayed, recorded)
+	}
+	if snapshots != 1 {
+		t.Errorf("took %d snapshots, want 1 while recording only", snapshots)
+	}
+
+	// Without the recorded snapshot the replan prompt changes
+	delete(loaded.Values, "systemSnapshot")
+	if _, err := planAndReplan(llm.Replay(context.Background(), loaded)); !errors.Is(err, llm.ErrNotRecorded) {
+		t.Errorf("replay with a new snapshot error = %v, want ErrNotRecorded", err)
+	}
+}
@@ -0,0 +1,323 @@
+package assistant
+
+import (
+	"WSA/pkg/llm"
+	"WSA/pkg/prompts"
+	"WSA/pkg/types"
+	"WSA/pkg/vision"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/kbinani/screenshot"
+)
+
+// MaxAgentSteps bounds the number of model round trips the tool agent may
+// make for a single task.
+const MaxAgentSteps = 8
+
+// AgentStep records one tool call made by the agent and its outcome.
+type AgentStep struct {
+	Tool      string                 `json:"tool"`
+	Arguments map[string]interface{} `json:"arguments"`
+	Command   string                 `json:"command,omitempty"` // Shell command run by the tool, if any
+	Result    string                 `json:"result"`
+	Error     string                 `json:"error,omitempty"`
+}
+
+// AgentResult is the outcome of RunToolAgent.
+type AgentResult struct {
+	NLResponse    string
+	Thinking      string // Thinking traces of every model turn, in order
+	PromptVersion string // Version of the system prompt template used
+	Steps         []AgentStep
+}
+
+// LastError returns the error of the final tool call, or "" if it succeeded.
+func (r *AgentResult) LastError() string {
+	if len(r.Steps) == 0 {
+		return ""
+	}
+	return r.Steps[len(r.Steps)-1].Error
+}
+
+// agentTool pairs a tool definition with the function that executes it.
+type agentTool struct {
+	def types.Tool
+	run func(args map[string]interface{}) (command string, result string, err error)
+}
+
+// RunToolAgent completes a task by letting the model call typed tools instead
+// of writing free-form shell strings. Tool results are sent back to the model
+// until it replies without tool calls or MaxAgentSteps is reached.
+func RunToolAgent(ctx context.Context, taskDescription string, chatHistory []types.PromptMessage, errorContext string, allowVision bool, onEvent EventFunc) (*AgentResult, error) {
+	tools := agentTools(ctx, allowVision)
+	definitions := make([]types.Tool, 0, len(tools))
+	byName := make(map[string]agentTool, len(tools))
+	for _, tool := range tools {
+		definitions = append(definitions, tool.def)
+		byName[tool.def.Function.Name] = tool
+	}
+
+	vars := prompts.DefaultVars()
+	vars.ErrorContext = sanitizeError(errorContext)
+	systemPrompt, promptVersion, err := prompts.Render(prompts.ToolAgent, vars)
+	if err != nil {
+		return &AgentResult{}, err
+	}
+
+	messages := []types.PromptMessage{{Role: "system", Content: systemPrompt}}
+	messages = append(messages, chatHistory...)
+
+	// Use the first installed commander model that supports tool calling, or
+	// reroute to any installed model that does
+	provider := llm.For(ctx)
+	chain := llm.RoleModels(ctx, llm.RoleCommander)
+	var model string
+	onFallback := func(model string, err error) {
+		emitRoute(onEvent, taskDescription, fmt.Sprintf("Skipping commander model %s for '%s': %v", model, taskDescription, err))
+	}
+	err = llm.WithFallback(ctx, provider, chain, onFallback, func(candidate string) error {
+		if !llm.Capabilities(ctx, provider, candidate).Has(llm.CapabilityTools) {
+			return fmt.Errorf("%w: %s has no tools support", llm.ErrUnsupported, candidate)
+		}
+		model = candidate
+		return nil
+	})
+	if err != nil {
+		resolved, _, err := llm.ResolveModel(ctx, provider, chain[0], llm.CapabilityTools)
+		if err != nil {
+			return &AgentResult{PromptVersion: promptVersion}, err
+		}
+		emitRoute(onEvent, taskDescription, fmt.Sprintf("Model %s does not support tool calls; using %s for '%s'.", chain[0], resolved, taskDescription))
+		model = resolved
+	}
+
+	result := &AgentResult{PromptVersion: promptVersion}
+	for step := 0; step < MaxAgentSteps; step++ {
+		chatRequest := llm.ChatRequest{
+			Model:    model,
+			Messages: messages,
+			Tools:    definitions,
+		}
+		report := llm.FitContext(ctx, provider, &chatRequest)
+		chatResponse, err := provider.Chat(ctx, chatRequest)
+		if err != nil {
+			return result, err
+		}
+		emitTokens(onEvent, taskDescription, report, chatResponse)
+		if chatResponse.Thinking != "" {
+			if result.Thinking != "" {
+				result.Thinking += "\n\n"
+			}
+			result.Thinking += chatResponse.Thinking
+		}
+
+		if len(chatResponse.ToolCalls) == 0 {
+			result.NLResponse = strings.TrimSpace(chatResponse.Content)
+			if onEvent != nil && result.NLResponse != "" {
+				onEvent(types.StreamEvent{Type: "nlResponse", Task: taskDescription, Data: result.NLResponse})
+			}
+			return result, nil
+		}
+
+		// Thinking stays out of the conversation sent back to the model
+		messages = append(messages, types.PromptMessage{
+			Role:      "assistant",
+			Content:   chatResponse.Content,
+			ToolCalls: chatResponse.ToolCalls,
+		})
+
+		for _, call := range chatResponse.ToolCalls {
+			agentStep := AgentStep{Tool: call.Function.Name, Arguments: call.Function.Arguments}
+			tool, ok := byName[call.Function.Name]
+			if !ok {
+				agentStep.Error = fmt.Sprintf("unknown tool %q", call.Function.Name)
+			} else {
+				command, output, runErr := tool.run(call.Function.Arguments)
+				agentStep.Command = command
+				agentStep.Result = output
+				if runErr != nil {
+					agentStep.Error = runErr.Error()
+				}
+			}
+			result.Steps = append(result.Steps, agentStep)
+
+			content := agentStep.Result
+			if agentStep.Error != "" {
+				content = "error: " + agentStep.Error
+			}
+			fmt.Printf("Tool call %s(%v) -> %s\n", agentStep.Tool, agentStep.Arguments, content)
+			if onEvent != nil {
+				data, _ := json.Marshal(agentStep)
+				onEvent(types.StreamEvent{Type: "tool", Task: taskDescription, Data: string(data)})
+			}
+
+			messages = append(messages, types.PromptMessage{
+				Role:       "tool",
+				Content:    content,
+				ToolName:   call.Function.Name,
+				ToolCallID: call.ID,
+			})
+		}
+	}
+
+	return result, fmt.Errorf("tool agent did not finish within %d steps", MaxAgentSteps)
+}
+
+// agentTools returns the tools offered to the model. capture_screen is only
+// offered when vision is allowed for the goal.
+func agentTools(ctx context.Context, allowVision bool) []agentTool {
+	appParams := llm.Schema{
+		"type": "object",
+		"properties": llm.Schema{
+			"app": llm.Schema{"type": "string", "description": "Application name, e.g. Spotify"},
+		},
+		"required": []string{"app"},
+	}
+
+	tools := []agentTool{
+		{
+			def: toolDefinition("open_app", "Open (launch) an installed application by name.", appParams),
+			run: func(args map[string]interface{}) (string, string, error) {
+				command, err := GetSmartOpenCommand(stringArg(args, "app"))
+				if err != nil {
+					return "", "", err
+				}
+				output, err := RunShellCommand(command)
+				return command, commandResult(output, "Application opened."), err
+			},
+		},
+		{
+			def: toolDefinition("quit_app", "Quit a running application by name.", appParams),
+			run: func(args map[string]interface{}) (string, string, error) {
+				command, err := GetSmartQuitCommand(stringArg(args, "app"))
+				if err != nil {
+					return "", "", err
+				}
+				output, err := RunShellCommand(command)
+				return command, commandResult(output, "Application quit."), err
+			},
+		},
+		{
+			def: toolDefinition("run_command", "Run a single POSIX shell command and return its output.", llm.Schema{
+				"type": "object",
+				"properties": llm.Schema{
+					"command": llm.Schema{"type": "string", "description": "The shell command to run"},
+				},
+				"required": []string{"command"},
+			}),
+			run: func(args map[string]interface{}) (string, string, error) {
+				command := fixStartCommand(strings.TrimSpace(stringArg(args, "command")))
+				output, err := RunShellCommand(command)
+				return command, commandResult(output, "Command completed with no output."), err
+			},
+		},
+		{
+			def: toolDefinition("list_running_apps", "List the applications that are currently running.", llm.Schema{
+				"type":       "object",
+				"properties": llm.Schema{},
+			}),
+			run: func(args map[string]interface{}) (string, string, error) {
+				apps, err := GetRunningApplications()
+				if err != nil {
+					return "", "", err
+				}
+				names := make([]string, 0, len(apps))
+				for _, app := range apps {
+					names = append(names, fmt.Sprintf("%s (%s, pid %s)", app.Name, app.BundleID, app.PID))
+				}
+				return "", strings.Join(names, "\n"), nil
+			},
+		},
+		{
+			def: toolDefinition("search_index", "Search the system directory index for paths containing a query.", llm.Schema{
+				"type": "object",
+				"properties": llm.Schema{
+					"query": llm.Schema{"type": "string", "description": "Case-insensitive text to look for in directory paths"},
+				},
+				"required": []string{"query"},
+			}),
+			run: func(args map[string]interface{}) (string, string, error) {
+				matches, err := SearchSystemIndex("system_index.txt", stringArg(args, "query"), 25)
+				if err != nil {
+					return "", "", err
+				}
+				if len(matches) == 0 {
+					return "", "No matching directories found.", nil
+				}
+				return "", strings.Join(matches, "\n"), nil
+			},
+		},
+	}
+
+	if allowVision {
+		tools = append(tools, agentTool{
+			def: toolDefinition("capture_screen", "Capture the screen (or a region of it) and answer a question about it with the vision model.", llm.Schema{
+				"type": "object",
+				"properties": llm.Schema{
+					"question": llm.Schema{"type": "string", "description": "What to look for in the screenshot"},
+					"x":        llm.Schema{"type": "integer"},
+					"y":        llm.Schema{"type": "integer"},
+					"width":    llm.Schema{"type": "integer"},
+					"height":   llm.Schema{"type": "integer"},
+				},
+				"required": []string{"question"},
+			}),
+			run: func(args map[string]interface{}) (string, string, error) {
+				bounds := screenshot.GetDisplayBounds(0)
+				x := intArg(args, "x", bounds.Min.X)
+				y := intArg(args, "y", bounds.Min.Y)
+				width := intArg(args, "width", bounds.Dx())
+				height := intArg(args, "height", bounds.Dy())
+
+				screenMu.Lock()
+				defer screenMu.Unlock()
+				screenshotPath := "tmp/agent_capture.png"
+				if err := CaptureScreenRegion(x, y, width, height, screenshotPath); err != nil {
+					return "", "", err
+				}
+				defer os.Remove(screenshotPath)
+
+				answer, err := vision.AnalyzeImagePaths(ctx, stringArg(args, "question"), []string{screenshotPath}, "")
+				return "", answer, err
+			},
+		})
+	}
+
+	return tools
+}
+
+func toolDefinition(name, description string, parameters llm.Schema) types.Tool {
+	return types.Tool{
+		Type: "function",
+		Function: types.ToolFunction{
+			Name:        name,
+			Description: description,
+			Parameters:  parameters,
+		},
+	}
+}
+
+func commandResult(output, fallback string) string {
+	if strings.TrimSpace(output) == "" {
+		return fallback
+	}
+	return strings.TrimSpace(output)
+}
+
+func stringArg(args map[string]interface{}, name string) string {
+	if v, ok := args[name].(string); ok {
+		return v
+	}
+	return ""
+}
+
+func intArg(args map[string]interface{}, name string, fallback int) int {
+	if v, ok := args[name].(float64); ok {
+		return int(v)
+	}
+	return fallback
+}
@@ -0,0 +1,77 @@
+package assistant
+
+import (
+	"WSA/pkg/llm"
+	"WSA/pkg/prompts"
+	"WSA/pkg/types"
+	"context"
+	"fmt"
+	"strings"
+)
+
+// maxVerifyOutput bounds how much command output is shown to the verifier.
+const maxVerifyOutput = 4000
+
+// Verdict is the verifier's judgement of a finished task.
+type Verdict struct {
+	Achieved bool   `json:"achieved"`
+	Reason   string `json:"reason"`
+}
+
+// CommandOutput is a command run for a task with its combined output.
+type CommandOutput struct {
+	Command string
+	Output  string
+}
+
+// VerifyTask asks the verifier models whether the commands run for task
+// achieved it. The verdict is reported to onEvent as a "verify" event.
+func VerifyTask(ctx context.Context, task string, results []CommandOutput, onEvent EventFunc) (*Verdict, error) {
+	vars := prompts.DefaultVars()
+	vars.Goal = task
+	systemPrompt, _, err := prompts.Render(prompts.VerifyTask, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	var evidence strings.Builder
+	evidence.WriteString("Task: " + task + "\n")
+	for _, result := range results {
+		output := strings.TrimSpace(result.Output)
+		if len(output) > maxVerifyOutput {
+			output = "..." + output[len(output)-maxVerifyOutput:]
+		}
+		if output == "" {
+			output = "(no output)"
+		}
+		evidence.WriteString("\n$ " + result.Command + "\n" + output + "\n")
+	}
+
+	provider := llm.For(ctx)
+	schema := llm.SchemaFor(Verdict{})
+	constrained := llm.SupportsFormat(provider)
+	chatRequest := llm.ChatRequest{
+		Messages: []types.PromptMessage{
+			{Role: "system", Content: systemPrompt},
+			{Role: "user", Content: evidence.String()},
+		},
+	}
+	if constrained {
+		chatRequest.Format = schema
+	}
+
+	var verdict Verdict
+	if _, err := chatWithRole(ctx, llm.RoleVerifier, provider, chatRequest, schema, constrained, &verdict, task, onEvent, nil); err != nil {
+		return nil, fmt.Errorf("failed to parse verifier's message as a verdict: %w", err)
+	}
+
+	message := fmt.Sprintf("Verifier: '%s' was achieved: %s", task, verdict.Reason)
+	if !verdict.Achieved {
+		message = fmt.Sprintf("Verifier: '%s' was not achieved: %s", task, verdict.Reason)
+	}
+	fmt.Println(message)
+	if onEvent != nil {
+		onEvent(types.StreamEvent{Type: "verify", Task: task, Data: message})
+	}
+	return &verdict, nil
+}
@@ -4,18 +4,54 @@ package assistant
 
 import (
 	"WSA/pkg/vision"
+	"context"
 	"fmt"
 	"image/png"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strings"
+	"sync"
 
 	"github.com/go-vgo/robotgo"
 	"github.com/kbinani/screenshot"
 )
 
+// screenMu makes mouse and keyboard input and screenshots of tasks running
+// at the same time take turns, as they share one screen and cursor.
+var screenMu sync.Mutex
+
+// guiCommandPattern matches shell commands that drive the desktop: typing,
+// clicking, moving windows or bringing apps to the front.
+var guiCommandPattern = regexp.MustCompile(`(^|\|)\s*(\S*/)?(osascript|xdotool|ydotool|wtype|cliclick|wmctrl|xdg-open|gtk-launch|open)(\s|$)`)
+
+// HasGUICommand reports whether any of commands drives the desktop, so the
+// task running them must not interleave with other tasks' input.
+func HasGUICommand(commands []string) bool {
+	for _, command := range commands {
+		if guiCommandPattern.MatchString(strings.TrimSpace(command)) {
+			return true
+		}
+	}
+	return false
+}
+
+// LockScreen waits until no other task uses the keyboard, mouse or screen
+// and keeps them for the caller until UnlockScreen. The vision helpers must
+// not be called in between.
+func LockScreen() {
+	screenMu.Lock()
+}
+
+// UnlockScreen releases the screen taken by LockScreen.
+func UnlockScreen() {
+	screenMu.Unlock()
+}
+
 // MoveMouse moves the mouse cursor to the specified (x, y) coordinates.
 func MoveMouse(x, y int) error {
+	screenMu.Lock()
+	defer screenMu.Unlock()
 	fmt.Printf("Moving mouse to (%d, %d)\n", x, y)
 	robotgo.Move(x, y)
 	return nil
@@ -23,6 +59,8 @@ func MoveMouse(x, y int) error {
 
 // TypeText types the specified text using the keyboard.
 func TypeText(text string) error {
+	screenMu.Lock()
+	defer screenMu.Unlock()
 	fmt.Printf("Typing text: %s\n", text)
 	robotgo.TypeStr(text)
 	return nil
@@ -61,7 +99,10 @@ func CaptureScreenRegion(x, y, width, height int, savePath string) error {
 
 // ConfirmMousePosition uses the vision model to verify that the mouse is at the correct position.
 // It captures a screenshot around the current mouse position and sends it to the vision model for confirmation.
-func ConfirmMousePosition(expectedElement string) (bool, error) {
+func ConfirmMousePosition(ctx context.Context, expectedElement string) (bool, error) {
+	screenMu.Lock()
+	defer screenMu.Unlock()
+
 	// Get current mouse position
 	x, y := robotgo.Location()
 	fmt.Printf("Current mouse position: (%d, %d)\n", x, y)
@@ -83,7 +124,7 @@ func ConfirmMousePosition(expectedElement string) (bool, error) {
 
 	// Process the image using the vision model
 	question := fmt.Sprintf("Is the '%s' element present in the captured image?", expectedElement)
-	visionResponse, err := vision.ProcessImage(screenshotPath, question)
+	visionResponse, err := vision.ProcessImage(ctx, screenshotPath, question)
 	if err != nil {
 		return false, fmt.Errorf("vision model processing failed: %w", err)
 	}
@@ -114,8 +155,10 @@ func ConfirmMousePosition(expectedElement string) (bool, error) {
 
 // UseVisionModel is a placeholder that can be expanded to perform
 // vision-assisted actions for the provided task description.
-func UseVisionModel(taskDescription string) error {
+func UseVisionModel(ctx context.Context, taskDescription string) error {
 	fmt.Printf("Vision model invoked for task: %s\n", taskDescription)
+	screenMu.Lock()
+	defer screenMu.Unlock()
 	// Quick sample: capture a small region around current cursor and ask a general question.
 	x, y := robotgo.Location()
 	captureWidth := 300
@@ -128,6 +171,6 @@ func UseVisionModel(taskDescription string) error {
 	}
 	defer os.Remove(screenshotPath)
 	question := fmt.Sprintf("Based on this screenshot, what should I do to: %s?", taskDescription)
-	_, err := vision.AnalyzeImagePaths(question, []string{screenshotPath}, "")
+	_, err := vision.AnalyzeImagePaths(ctx, question, []string{screenshotPath}, "")
 	return err
 }
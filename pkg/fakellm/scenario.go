@@ -0,0 +1,189 @@
+package fakellm
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	"WSA/pkg/types"
+)
+
+//go:embed scenarios
+var builtin embed.FS
+
+// Scenario scripts the replies of the fake server. It is read from JSON:
+//
+//	{
+//	  "models": [{"name": "llama3.2:latest", "capabilities": ["completion", "tools"]}],
+//	  "rules": [
+//	    {"system": "terminal commands", "match": "(?i)open (\\w+)",
+//	     "reply": "{\"nlResponse\": \"Opening $1.\", \"commands\": [\"gtk-launch $1\"], \"visionNeeded\": false}"},
+//	    {"match": "crash", "error": "model runner has unexpectedly stopped", "status": 500, "delay": "2s"}
+//	  ]
+//	}
+//
+// The first rule that matches a request answers it; without a match the
+// Fallback rule answers, or the request fails.
+type Scenario struct {
+	Models   []ModelSpec `json:"models,omitempty"` // Installed models; defaults to DefaultModels
+	Rules    []Rule      `json:"rules"`
+	Fallback *Rule       `json:"fallback,omitempty"`
+	// ChunkDelay is the pause between streamed chunks, as a Go duration
+	ChunkDelay string   `json:"chunkDelay,omitempty"`
+	Pull       PullSpec `json:"pull,omitempty"`
+
+	chunkDelay time.Duration
+}
+
+// ModelSpec is a model the fake server reports as installed.
+type ModelSpec struct {
+	Name          string   `json:"name"`
+	Family        string   `json:"family,omitempty"`
+	ParameterSize string   `json:"parameterSize,omitempty"`
+	Capabilities  []string `json:"capabilities,omitempty"` // Defaults to completion only
+	ContextLength int      `json:"contextLength,omitempty"`
+	Size          int64    `json:"size,omitempty"` // Bytes; also the download size of a pull
+}
+
+// Rule answers requests whose prompt matches. Match is a regular expression
+// applied to the last user message of a chat or the prompt of a generate
+// request; System and Model further restrict the rule to requests whose
+// system message or model name match. Reply may refer to groups of Match as
+// $1 or ${name}; write $$ for a literal $, e.g. "$$HOME".
+type Rule struct {
+	Endpoint  string           `json:"endpoint,omitempty"` // "chat" or "generate"; empty for both
+	Match     string           `json:"match,omitempty"`
+	System    string           `json:"system,omitempty"`
+	Model     string           `json:"model,omitempty"`
+	Reply     string           `json:"reply,omitempty"`
+	Thinking  string           `json:"thinking,omitempty"`
+	ToolCalls []types.ToolCall `json:"toolCalls,omitempty"`
+	Delay     string           `json:"delay,omitempty"`  // Go duration to wait before replying
+	Error     string           `json:"error,omitempty"`  // Fail the request with this message
+	Status    int              `json:"status,omitempty"` // HTTP status of Error; default 500
+	Times     int              `json:"times,omitempty"`  // Answer at most this many requests; 0 for no limit
+
+	match, system, model *regexp.Regexp
+	delay                time.Duration
+}
+
+// PullSpec scripts /api/pull.
+type PullSpec struct {
+	Delay string `json:"delay,omitempty"` // Go duration between progress updates
+	Error string `json:"error,omitempty"` // Fail pulls with this message, e.g. "no space left on device"
+
+	delay time.Duration
+}
+
+// DefaultModels are installed when a scenario lists none: a chat model with
+// tool support and a vision model, named like the llm package defaults.
+var DefaultModels = []ModelSpec{
+	{Name: "llama3.2:latest", Family: "llama", ParameterSize: "3.2B", Capabilities: []string{"completion", "tools"}, ContextLength: 131072, Size: 2019393189},
+	{Name: "llava:latest", Family: "llama", ParameterSize: "7B", Capabilities: []string{"completion", "vision"}, ContextLength: 32768, Size: 4733363377},
+}
+
+// LoadScenario reads a scenario file. An empty path, or the name of a
+// built-in scenario such as "demo", loads the built-in scenario.
+func LoadScenario(path string) (*Scenario, error) {
+	if path == "" {
+		path = "demo"
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		if builtinData, builtinErr := builtin.ReadFile("scenarios/" + path + ".json"); builtinErr == nil {
+			data, err = builtinData, nil
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scenario: %w", err)
+	}
+
+	var s Scenario
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse scenario %s: %w", path, err)
+	}
+	if err := s.compile(); err != nil {
+		return nil, fmt.Errorf("invalid scenario %s: %w", path, err)
+	}
+	return &s, nil
+}
+
+// compile parses the expressions and durations of the scenario.
+func (s *Scenario) compile() error {
+	if len(s.Models) == 0 {
+		s.Models = append([]ModelSpec{}, DefaultModels...)
+	}
+
+	var err error
+	if s.chunkDelay, err = parseDelay(s.ChunkDelay); err != nil {
+		return fmt.Errorf("chunkDelay: %w", err)
+	}
+	if s.Pull.delay, err = parseDelay(s.Pull.Delay); err != nil {
+		return fmt.Errorf("pull delay: %w", err)
+	}
+	for i := range s.Rules {
+		if err := s.Rules[i].compile(); err != nil {
+			return fmt.Errorf("rule %d: %w", i+1, err)
+		}
+	}
+	if s.Fallback != nil {
+		if err := s.Fallback.compile(); err != nil {
+			return fmt.Errorf("fallback: %w", err)
+		}
+	}
+	return nil
+}
+
+func (r *Rule) compile() error {
+	var err error
+	for _, field := range []struct {
+		expr string
+		re   **regexp.Regexp
+	}{{r.Match, &r.match}, {r.System, &r.system}, {r.Model, &r.model}} {
+		if field.expr == "" {
+			continue
+		}
+		if *field.re, err = regexp.Compile(field.expr); err != nil {
+			return err
+		}
+	}
+	if r.delay, err = parseDelay(r.Delay); err != nil {
+		return fmt.Errorf("delay: %w", err)
+	}
+	if r.Endpoint != "" && r.Endpoint != "chat" && r.Endpoint != "generate" {
+		return fmt.Errorf("unknown endpoint %q", r.Endpoint)
+	}
+	return nil
+}
+
+// matches reports whether r answers the request and returns the expanded
+// reply.
+func (r *Rule) matches(endpoint, model, system, prompt string) (string, bool) {
+	if r.Endpoint != "" && r.Endpoint != endpoint {
+		return "", false
+	}
+	if r.model != nil && !r.model.MatchString(model) {
+		return "", false
+	}
+	if r.system != nil && !r.system.MatchString(system) {
+		return "", false
+	}
+	if r.match == nil {
+		return r.Reply, true
+	}
+	groups := r.match.FindStringSubmatchIndex(prompt)
+	if groups == nil {
+		return "", false
+	}
+	return string(r.match.ExpandString(nil, r.Reply, prompt, groups)), true
+}
+
+func parseDelay(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(s)
+}
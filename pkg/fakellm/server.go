@@ -0,0 +1,419 @@
+// Package fakellm is an offline stand-in for the Ollama server. It answers
+// /api/chat, /api/generate, /api/tags, /api/pull and /api/show from a
+// scripted Scenario, so the backend runs end to end without a model:
+//
+//	wsa fake-llm -scenario demo.json &
+//	LLM_API_ENDPOINT=http://127.0.0.1:11434 wsa
+package fakellm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"WSA/pkg/types"
+)
+
+// Server serves a Scenario over the Ollama API.
+type Server struct {
+	scenario *Scenario
+	mux      *http.ServeMux
+
+	mu     sync.Mutex
+	uses   map[*Rule]int
+	models []ModelSpec
+}
+
+// NewServer returns a server for s.
+func NewServer(s *Scenario) *Server {
+	srv := &Server{
+		scenario: s,
+		mux:      http.NewServeMux(),
+		uses:     map[*Rule]int{},
+		models:   append([]ModelSpec{}, s.Models...),
+	}
+	srv.mux.HandleFunc("/api/chat", srv.chat)
+	srv.mux.HandleFunc("/api/generate", srv.generate)
+	srv.mux.HandleFunc("/api/tags", srv.tags)
+	srv.mux.HandleFunc("/api/pull", srv.pull)
+	srv.mux.HandleFunc("/api/show", srv.show)
+	srv.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		// Ollama answers its root path, which clients use as a health check
+		if r.URL.Path == "/" {
+			fmt.Fprint(w, "Ollama is running")
+			return
+		}
+		writeError(w, http.StatusNotFound, "404 page not found")
+	})
+	return srv
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) chat(w http.ResponseWriter, r *http.Request) {
+	var req types.ChatData
+	if !decode(w, r, &req) {
+		return
+	}
+	if !s.installed(w, req.Model) {
+		return
+	}
+
+	var system, prompt string
+	for _, m := range req.Messages {
+		switch m.Role {
+		case "system":
+			system = m.Content
+		case "user":
+			prompt = m.Content
+		}
+	}
+
+	rule, reply, ok := s.answer(w, r.Context(), "chat", req.Model, system, prompt)
+	if !ok {
+		return
+	}
+	promptTokens, replyTokens := tokens(system+prompt), tokens(rule.Thinking+reply)
+
+	if !req.Stream {
+		writeJSON(w, types.LLMResponse{
+			Model:     req.Model,
+			CreatedAt: now(),
+			Message: types.LLMMessage{
+				Role:      "assistant",
+				Content:   reply,
+				Thinking:  rule.Thinking,
+				ToolCalls: rule.ToolCalls,
+			},
+			Done:            true,
+			PromptEvalCount: promptTokens,
+			EvalCount:       replyTokens,
+		})
+		return
+	}
+
+	stream := s.stream(w, r.Context())
+	for _, chunk := range chunks(rule.Thinking) {
+		stream(types.LLMResponse{Model: req.Model, CreatedAt: now(), Message: types.LLMMessage{Role: "assistant", Thinking: chunk}})
+	}
+	for _, chunk := range chunks(reply) {
+		stream(types.LLMResponse{Model: req.Model, CreatedAt: now(), Message: types.LLMMessage{Role: "assistant", Content: chunk}})
+	}
+	if len(rule.ToolCalls) > 0 {
+		stream(types.LLMResponse{Model: req.Model, CreatedAt: now(), Message: types.LLMMessage{Role: "assistant", ToolCalls: rule.ToolCalls}})
+	}
+	stream(types.LLMResponse{
+		Model:           req.Model,
+		CreatedAt:       now(),
+		Message:         types.LLMMessage{Role: "assistant"},
+		Done:            true,
+		PromptEvalCount: promptTokens,
+		EvalCount:       replyTokens,
+	})
+}
+
+func (s *Server) generate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Model  string `json:"model"`
+		Prompt string `json:"prompt"`
+		Stream *bool  `json:"stream"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	if !s.installed(w, req.Model) {
+		return
+	}
+
+	// An empty prompt only loads or unloads the model
+	if req.Prompt == "" {
+		writeJSON(w, map[string]interface{}{"model": req.Model, "created_at": now(), "response": "", "done": true})
+		return
+	}
+
+	_, reply, ok := s.answer(w, r.Context(), "generate", req.Model, "", req.Prompt)
+	if !ok {
+		return
+	}
+
+	if req.Stream == nil || *req.Stream {
+		stream := s.stream(w, r.Context())
+		for _, chunk := range chunks(reply) {
+			stream(map[string]interface{}{"model": req.Model, "created_at": now(), "response": chunk, "done": false})
+		}
+		stream(map[string]interface{}{"model": req.Model, "created_at": now(), "response": "", "done": true})
+		return
+	}
+	writeJSON(w, map[string]interface{}{"model": req.Model, "created_at": now(), "response": reply, "done": true})
+}
+
+func (s *Server) tags(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	type model struct {
+		Name       string `json:"name"`
+		Model      string `json:"model"`
+		ModifiedAt string `json:"modified_at"`
+		Size       int64  `json:"size"`
+	}
+	models := []model{}
+	for _, m := range s.models {
+		models = append(models, model{Name: m.Name, Model: m.Name, ModifiedAt: now(), Size: m.Size})
+	}
+	writeJSON(w, map[string]interface{}{"models": models})
+}
+
+func (s *Server) show(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Model string `json:"model"`
+		Name  string `json:"name"` // Older clients
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	if req.Model == "" {
+		req.Model = req.Name
+	}
+
+	m, ok := s.find(req.Model)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("model '%s' not found", req.Model))
+		return
+	}
+
+	var show types.OllamaShowResponse
+	show.Details.Format = "gguf"
+	show.Details.Family = m.Family
+	show.Details.Families = []string{m.Family}
+	show.Details.ParameterSize = m.ParameterSize
+	show.Details.QuantizationLevel = "Q4_K_M"
+	show.Capabilities = m.Capabilities
+	if len(show.Capabilities) == 0 {
+		show.Capabilities = []string{"completion"}
+	}
+	if m.ContextLength > 0 {
+		show.ModelInfo = map[string]interface{}{m.Family + ".context_length": m.ContextLength}
+	}
+	writeJSON(w, show)
+}
+
+func (s *Server) pull(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Model  string `json:"model"`
+		Name   string `json:"name"` // Older clients
+		Stream *bool  `json:"stream"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	if req.Model == "" {
+		req.Model = req.Name
+	}
+
+	size := int64(1 << 30)
+	if m, ok := s.find(req.Model); ok && m.Size > 0 {
+		size = m.Size
+	}
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(req.Model)))
+	spec := s.scenario.Pull
+
+	if req.Stream != nil && !*req.Stream {
+		if !sleep(r.Context(), spec.delay) {
+			return
+		}
+		if spec.Error != "" {
+			writeError(w, http.StatusInternalServerError, spec.Error)
+			return
+		}
+		s.addModel(req.Model, size)
+		writeJSON(w, map[string]string{"status": "success"})
+		return
+	}
+
+	stream := s.stream(w, r.Context())
+	if !stream(map[string]interface{}{"status": "pulling manifest"}) {
+		return
+	}
+	const steps = 4
+	for i := 0; i <= steps; i++ {
+		if i > 0 && !sleep(r.Context(), spec.delay) {
+			return
+		}
+		if !stream(map[string]interface{}{"status": "pulling " + digest[7:19], "digest": digest, "total": size, "completed": size * int64(i) / steps}) {
+			return
+		}
+		// Fail halfway through, like a disk filling up
+		if spec.Error != "" && i == steps/2 {
+			stream(map[string]interface{}{"error": spec.Error})
+			return
+		}
+	}
+	s.addModel(req.Model, size)
+	for _, status := range []string{"verifying sha256 digest", "writing manifest", "success"} {
+		if !stream(map[string]interface{}{"status": status}) {
+			return
+		}
+	}
+}
+
+// answer finds the rule for a request, waits its delay and reports its error.
+// It returns false when the request was answered with an error.
+func (s *Server) answer(w http.ResponseWriter, ctx context.Context, endpoint, model, system, prompt string) (*Rule, string, bool) {
+	rule, reply := s.rule(endpoint, model, system, prompt)
+	if rule == nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("fake-llm: no rule matches %s prompt %q", endpoint, prompt))
+		return nil, "", false
+	}
+	if !sleep(ctx, rule.delay) {
+		return nil, "", false
+	}
+	if rule.Error != "" {
+		status := rule.Status
+		if status == 0 {
+			status = http.StatusInternalServerError
+		}
+		writeError(w, status, rule.Error)
+		return nil, "", false
+	}
+	return rule, reply, true
+}
+
+// rule returns the first rule that matches and has uses left, else the
+// fallback, and counts the use.
+func (s *Server) rule(endpoint, model, system, prompt string) (*Rule, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.scenario.Rules {
+		rule := &s.scenario.Rules[i]
+		if rule.Times > 0 && s.uses[rule] >= rule.Times {
+			continue
+		}
+		if reply, ok := rule.matches(endpoint, model, system, prompt); ok {
+			s.uses[rule]++
+			return rule, reply
+		}
+	}
+	if fallback := s.scenario.Fallback; fallback != nil {
+		if reply, ok := fallback.matches(endpoint, model, system, prompt); ok {
+			return fallback, reply
+		}
+	}
+	return nil, ""
+}
+
+// installed reports whether model is installed, answering like Ollama when
+// it is not.
+func (s *Server) installed(w http.ResponseWriter, model string) bool {
+	if _, ok := s.find(model); ok {
+		return true
+	}
+	writeError(w, http.StatusNotFound, fmt.Sprintf("model '%s' not found, try pulling it first", model))
+	return false
+}
+
+// find looks model up with and without its ":latest" tag.
+func (s *Server) find(model string) (ModelSpec, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range s.models {
+		if m.Name == model || m.Name == model+":latest" || strings.TrimSuffix(m.Name, ":latest") == model {
+			return m, true
+		}
+	}
+	return ModelSpec{}, false
+}
+
+func (s *Server) addModel(model string, size int64) {
+	if _, ok := s.find(model); ok {
+		return
+	}
+	if !strings.Contains(model, ":") {
+		model += ":latest"
+	}
+	s.mu.Lock()
+	s.models = append(s.models, ModelSpec{Name: model, Family: "llama", Capabilities: []string{"completion"}, Size: size})
+	s.mu.Unlock()
+}
+
+// stream starts an NDJSON response and returns a function writing one line,
+// pausing ChunkDelay between lines. It returns false once the client is gone.
+func (s *Server) stream(w http.ResponseWriter, ctx context.Context) func(v interface{}) bool {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	first := true
+	return func(v interface{}) bool {
+		if !first && !sleep(ctx, s.scenario.chunkDelay) {
+			return false
+		}
+		first = false
+		if err := json.NewEncoder(w).Encode(v); err != nil {
+			return false
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return true
+	}
+}
+
+// chunks splits text after each space, the way models stream words.
+func chunks(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.SplitAfter(text, " ")
+}
+
+// tokens estimates a token count at four characters per token.
+func tokens(text string) int {
+	return (len(text) + 3) / 4
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+func now() string {
+	return time.Now().UTC().Format(time.RFC3339Nano)
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
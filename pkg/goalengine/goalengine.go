@@ -1,21 +1,198 @@
 package goalengine
 
+import (
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// TaskStatus is the state of a task. Tasks move between states only along
+// the transitions Goal.Transition allows; Completed, Failed, Skipped and
+// Cancelled are terminal.
 type TaskStatus int
 
 const (
-	Pending TaskStatus = iota
-	InProgress
-	Completed
-	Failed
+	Pending   TaskStatus = iota // Waiting to run, or to be retried
+	Running                     // An attempt is in progress
+	Blocked                     // Waiting for the tasks it depends on
+	Completed                   // An attempt achieved the task
+	Failed                      // Every attempt failed
+	Skipped                     // The goal ended without running the task
+	Cancelled                   // The goal was cancelled before the task ended
+)
+
+var taskStatusNames = map[TaskStatus]string{
+	Pending:   "Pending",
+	Running:   "Running",
+	Blocked:   "Blocked",
+	Completed: "Completed",
+	Failed:    "Failed",
+	Skipped:   "Skipped",
+	Cancelled: "Cancelled",
+}
+
+func (s TaskStatus) String() string {
+	if name, ok := taskStatusNames[s]; ok {
+		return name
+	}
+	return "Unknown"
+}
+
+// Terminal reports whether a task in this state is done for good.
+func (s TaskStatus) Terminal() bool {
+	return s == Completed || s == Failed || s == Skipped || s == Cancelled
+}
+
+// taskTransitions lists the states each state may move to. Running moves
+// back to Pending when a failed attempt is retried.
+var taskTransitions = map[TaskStatus][]TaskStatus{
+	Pending: {Running, Blocked, Skipped, Cancelled},
+	Running: {Pending, Blocked, Completed, Failed, Cancelled},
+	Blocked: {Pending, Skipped, Failed, Cancelled},
+}
+
+// GoalStatus is the state of a goal. A goal ends in one of the outcomes
+// Succeeded, PartiallySucceeded, Failed or Cancelled.
+type GoalStatus int
+
+const (
+	GoalPending GoalStatus = iota
+	GoalRunning
+	GoalInterrupted        // The backend stopped while the goal ran; it can be resumed
+	GoalSucceeded          // Every task completed
+	GoalPartiallySucceeded // Some tasks completed and others did not
+	GoalFailed             // No task completed
+	GoalCancelled
+)
+
+var goalStatusNames = map[GoalStatus]string{
+	GoalPending:            "Pending",
+	GoalRunning:            "Running",
+	GoalInterrupted:        "Interrupted",
+	GoalSucceeded:          "Succeeded",
+	GoalPartiallySucceeded: "PartiallySucceeded",
+	GoalFailed:             "Failed",
+	GoalCancelled:          "Cancelled",
+}
+
+func (s GoalStatus) String() string {
+	if name, ok := goalStatusNames[s]; ok {
+		return name
+	}
+	return "Unknown"
+}
+
+// ParseTaskStatus returns the TaskStatus named name.
+func ParseTaskStatus(name string) (TaskStatus, error) {
+	for status, statusName := range taskStatusNames {
+		if statusName == name {
+			return status, nil
+		}
+	}
+	return Pending, fmt.Errorf("unknown task status %q", name)
+}
+
+// ParseGoalStatus returns the GoalStatus named name.
+func ParseGoalStatus(name string) (GoalStatus, error) {
+	for status, statusName := range goalStatusNames {
+		if statusName == name {
+			return status, nil
+		}
+	}
+	return GoalPending, fmt.Errorf("unknown goal status %q", name)
+}
+
+// Terminal reports whether a goal in this state has ended.
+func (s GoalStatus) Terminal() bool {
+	return s >= GoalSucceeded
+}
+
+// Transition is a change of state of a task, or of the goal when Task is
+// empty.
+type Transition struct {
+	Task   string `json:"task,omitempty"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Kinds of postconditions.
+const (
+	ProcessRunning    = "process_running"     // Target is a process or app name
+	ProcessNotRunning = "process_not_running" // Target is a process or app name
+	FileExists        = "file_exists"         // Target is a path; ~ and $VARS are expanded
+	DirExists         = "dir_exists"          // Target is a path; ~ and $VARS are expanded
+	AppFrontmost      = "app_frontmost"       // Target is an app name
+	OutputMatches     = "output_matches"      // Target is a command whose output must match Pattern
 )
 
+// Postcondition is a fact about the system that can be checked once a task
+// has run, e.g. that a process has stopped.
+type Postcondition struct {
+	Kind    string `json:"kind"`
+	Target  string `json:"target"`
+	Pattern string `json:"pattern,omitempty"` // Regular expression for output_matches
+}
+
+func (p Postcondition) String() string {
+	if p.Kind == OutputMatches {
+		return fmt.Sprintf("%s %q ~ /%s/", p.Kind, p.Target, p.Pattern)
+	}
+	return fmt.Sprintf("%s %q", p.Kind, p.Target)
+}
+
+// Validate reports whether p is a known kind of postcondition with what its
+// kind needs.
+func (p Postcondition) Validate() error {
+	switch p.Kind {
+	case ProcessRunning, ProcessNotRunning, FileExists, DirExists, AppFrontmost:
+	case OutputMatches:
+		if _, err := regexp.Compile(p.Pattern); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", p.Pattern, err)
+		}
+	default:
+		return fmt.Errorf("unknown postcondition kind %q", p.Kind)
+	}
+	if p.Target == "" {
+		return fmt.Errorf("postcondition %s has no target", p.Kind)
+	}
+	return nil
+}
+
 type Task struct {
+	ID          int64 // Row of the task in the goal store; 0 until stored
 	Description string
 	Status      TaskStatus
 	Commands    []string
 	Feedback    string
 	Attempt     int
 	MaxRetries  int
+	Thinking    string // Model reasoning behind the latest attempt's commands
+	// DependsOn lists the indexes in Goal.Tasks of the tasks that must
+	// complete before this one starts.
+	DependsOn []int
+	// GUI marks tasks that type text, move the mouse or bring an app to the
+	// front; they run one at a time. The planner sets it, and it is also set
+	// once a task's commands turn out to drive the desktop.
+	GUI bool
+	// Postconditions must hold after an attempt for the task to complete
+	Postconditions []Postcondition
+	// PromptVersion is the prompt template version of the latest attempt
+	PromptVersion string
+	// Samples is the number of command candidates voted on in the latest
+	// attempt, and Disagreement the share of them that lost the vote.
+	Samples      int
+	Disagreement float64
+}
+
+// Plan is one version of a goal's task list: the breakdown of the goal, or a
+// revision of the tasks left after some failed.
+type Plan struct {
+	Tasks         []*Task
+	Thinking      string // Planner reasoning behind the plan
+	PromptVersion string // Version of the planner prompt template
+	Reason        string // Failures that led to the plan; empty for the first plan
 }
 
 type State struct {
@@ -25,12 +202,34 @@ type State struct {
 }
 
 type Goal struct {
+	ID           int64 // Row of the goal in the goal store; 0 until stored
 	Description  string
+	Status       GoalStatus
 	Tasks        []*Task
 	CurrentState *State
 	DesiredState *State
 	Logs         []string
 	UseVision    bool
+	AgentMode    bool   // Complete tasks through tool calls instead of shell strings
+	Verify       bool   // Have the verifier model check tasks whose commands succeeded
+	Samples      int    // Command candidates sampled per task; above 1 they are voted on
+	Parallel     int    // Tasks that may run at the same time
+	MaxRetries   int    // Attempts per task; 0 keeps what the planner set
+	MaxReplans   int    // Times the goal may be replanned after tasks fail
+	PlanThinking string // Planner reasoning behind the task breakdown
+	// PlanPromptVersion is the planner prompt template version
+	PlanPromptVersion string
+	// PostconditionTimeout is how long task postconditions are given to hold
+	PostconditionTimeout time.Duration
+	// Plans are every plan of the goal, the current one last
+	Plans []*Plan
+	// OnTransition is told about every change of state of the goal and its
+	// tasks, if set.
+	OnTransition func(Transition)
+	// OnLog is told about every message added by AddLog, in order, if set.
+	OnLog func(msg string)
+
+	logMu sync.Mutex
 }
 
 func (g *Goal) IsGoalAchieved() bool {
@@ -41,3 +240,132 @@ func (g *Goal) IsGoalAchieved() bool {
 	}
 	return true
 }
+
+// AddPlan makes plan the current plan of the goal. Tasks of the previous
+// plan that completed are kept ahead of the plan's tasks, whose dependencies
+// are shifted to match; the others are skipped and stay in the previous
+// plan only.
+func (g *Goal) AddPlan(plan *Plan) {
+	var kept []*Task
+	for _, task := range g.Tasks {
+		switch {
+		case task.Status == Completed:
+			kept = append(kept, task)
+		case !task.Status.Terminal():
+			g.Transition(task, Skipped, "replanned")
+		}
+	}
+
+	for _, task := range plan.Tasks {
+		for i := range task.DependsOn {
+			task.DependsOn[i] += len(kept)
+		}
+		if g.MaxRetries > 0 {
+			task.MaxRetries = g.MaxRetries
+		}
+	}
+	g.Tasks = append(kept, plan.Tasks...)
+	g.PlanThinking = plan.Thinking
+	g.PlanPromptVersion = plan.PromptVersion
+	g.Plans = append(g.Plans, plan)
+}
+
+// Replans returns how many times the goal has been replanned.
+func (g *Goal) Replans() int {
+	if len(g.Plans) == 0 {
+		return 0
+	}
+	return len(g.Plans) - 1
+}
+
+// AddLog appends msg to the goal's logs and passes it to OnLog. It is safe
+// to call from tasks running at the same time.
+func (g *Goal) AddLog(msg string) {
+	g.logMu.Lock()
+	defer g.logMu.Unlock()
+	g.Logs = append(g.Logs, msg)
+	if g.OnLog != nil {
+		g.OnLog(msg)
+	}
+}
+
+// Transition moves task to status. It returns an error, leaving the task as
+// it is, when the state machine does not allow the move.
+func (g *Goal) Transition(task *Task, status TaskStatus, reason string) error {
+	allowed := false
+	for _, to := range taskTransitions[task.Status] {
+		if to == status {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("task '%s' cannot move from %s to %s", task.Description, task.Status, status)
+	}
+
+	from := task.Status
+	task.Status = status
+	g.emit(Transition{Task: task.Description, From: from.String(), To: status.String(), Reason: reason})
+	return nil
+}
+
+// Start moves the goal from Pending to Running, or resumes an interrupted
+// goal.
+func (g *Goal) Start() error {
+	if g.Status != GoalPending && g.Status != GoalInterrupted {
+		return fmt.Errorf("goal '%s' cannot start from %s", g.Description, g.Status)
+	}
+	from := g.Status
+	g.Status = GoalRunning
+	g.emit(Transition{From: from.String(), To: GoalRunning.String()})
+	return nil
+}
+
+// Finish ends the goal. Tasks that have not ended are cancelled if
+// cancelled is set; otherwise waiting tasks are skipped and running ones
+// fail. The goal's outcome follows from
+// its tasks and is returned; a goal that already ended keeps its outcome.
+func (g *Goal) Finish(cancelled bool, reason string) GoalStatus {
+	if g.Status.Terminal() {
+		return g.Status
+	}
+
+	leftover := Skipped
+	if cancelled {
+		leftover = Cancelled
+	}
+	completed := 0
+	for _, task := range g.Tasks {
+		switch {
+		case task.Status == Running && !cancelled:
+			// An attempt the caller never finished
+			g.Transition(task, Failed, reason)
+		case !task.Status.Terminal():
+			g.Transition(task, leftover, reason)
+		}
+		if task.Status == Completed {
+			completed++
+		}
+	}
+
+	outcome := GoalFailed
+	switch {
+	case cancelled:
+		outcome = GoalCancelled
+	case len(g.Tasks) > 0 && completed == len(g.Tasks):
+		outcome = GoalSucceeded
+	case completed > 0:
+		outcome = GoalPartiallySucceeded
+	}
+
+	from := g.Status
+	g.Status = outcome
+	g.emit(Transition{From: from.String(), To: outcome.String(), Reason: reason})
+	return outcome
+}
+
+func (g *Goal) emit(t Transition) {
+	if g.OnTransition != nil {
+		g.OnTransition(t)
+	}
+}
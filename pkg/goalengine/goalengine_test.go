@@ -0,0 +1,174 @@
+package goalengine
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTransition(t *testing.T) {
+	tests := []struct {
+		from, to TaskStatus
+		ok       bool
+	}{
+		{Pending, Running, true},
+		{Pending, Blocked, true},
+		{Pending, Skipped, true},
+		{Pending, Cancelled, true},
+		{Pending, Completed, false},
+		{Pending, Failed, false},
+		{Running, Pending, true},
+		{Running, Completed, true},
+		{Running, Failed, true},
+		{Running, Blocked, true},
+		{Running, Cancelled, true},
+		{Running, Skipped, false},
+		{Blocked, Pending, true},
+		{Blocked, Skipped, true},
+		{Blocked, Failed, true},
+		{Blocked, Cancelled, true},
+		{Blocked, Running, false},
+		{Blocked, Completed, false},
+		{Completed, Pending, false},
+		{Completed, Failed, false},
+		{Failed, Pending, false},
+		{Failed, Running, false},
+		{Skipped, Pending, false},
+		{Cancelled, Running, false},
+		{Pending, Pending, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.from.String()+"->"+tt.to.String(), func(t *testing.T) {
+			var transitions []Transition
+			g := &Goal{OnTransition: func(tr Transition) { transitions = append(transitions, tr) }}
+			task := &Task{Description: "task", Status: tt.from}
+
+			err := g.Transition(task, tt.to, "because")
+			if tt.ok {
+				if err != nil {
+					t.Fatalf("Transition(%s, %s) failed: %v", tt.from, tt.to, err)
+				}
+				want := []Transition{{Task: "task", From: tt.from.String(), To: tt.to.String(), Reason: "because"}}
+				if task.Status != tt.to || !reflect.DeepEqual(transitions, want) {
+					t.Errorf("Transition(%s, %s) left %s and reported %+v, want %+v", tt.from, tt.to, task.Status, transitions, want)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Transition(%s, %s) succeeded, want an error", tt.from, tt.to)
+			}
+			if task.Status != tt.from || len(transitions) != 0 {
+				t.Errorf("rejected Transition(%s, %s) left %s and reported %+v", tt.from, tt.to, task.Status, transitions)
+			}
+		})
+	}
+}
+
+func TestStart(t *testing.T) {
+	tests := []struct {
+		from GoalStatus
+		ok   bool
+	}{
+		{GoalPending, true},
+		{GoalInterrupted, true},
+		{GoalRunning, false},
+		{GoalSucceeded, false},
+		{GoalCancelled, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.from.String(), func(t *testing.T) {
+			g := &Goal{Status: tt.from}
+			err := g.Start()
+			if tt.ok != (err == nil) {
+				t.Fatalf("Start() from %s error = %v, want ok %v", tt.from, err, tt.ok)
+			}
+			if want := map[bool]GoalStatus{true: GoalRunning, false: tt.from}[tt.ok]; g.Status != want {
+				t.Errorf("Start() from %s left %s, want %s", tt.from, g.Status, want)
+			}
+		})
+	}
+}
+
+func TestFinish(t *testing.T) {
+	tests := []struct {
+		name      string
+		tasks     []TaskStatus
+		cancelled bool
+		want      GoalStatus
+		wantTasks []TaskStatus
+	}{
+		{"all completed", []TaskStatus{Completed, Completed}, false, GoalSucceeded, []TaskStatus{Completed, Completed}},
+		{"some completed", []TaskStatus{Completed, Failed, Pending}, false, GoalPartiallySucceeded, []TaskStatus{Completed, Failed, Skipped}},
+		{"none completed", []TaskStatus{Failed, Blocked}, false, GoalFailed, []TaskStatus{Failed, Skipped}},
+		{"no tasks", nil, false, GoalFailed, nil},
+		{"unfinished attempt", []TaskStatus{Running}, false, GoalFailed, []TaskStatus{Failed}},
+		{"cancelled", []TaskStatus{Completed, Running, Pending}, true, GoalCancelled, []TaskStatus{Completed, Cancelled, Cancelled}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &Goal{Status: GoalRunning}
+			for _, status := range tt.tasks {
+				g.Tasks = append(g.Tasks, &Task{Status: status})
+			}
+			if got := g.Finish(tt.cancelled, "done"); got != tt.want || g.Status != tt.want {
+				t.Errorf("Finish() = %s, goal %s, want %s", got, g.Status, tt.want)
+			}
+			for i, task := range g.Tasks {
+				if task.Status != tt.wantTasks[i] {
+					t.Errorf("task %d ended %s, want %s", i, task.Status, tt.wantTasks[i])
+				}
+			}
+			if got := g.Finish(!tt.cancelled, "again"); got != tt.want {
+				t.Errorf("second Finish() = %s, want the first outcome %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddPlan(t *testing.T) {
+	g := &Goal{MaxRetries: 3}
+	g.AddPlan(&Plan{Tasks: []*Task{
+		{Description: "a"},
+		{Description: "b", DependsOn: []int{0}},
+		{Description: "c", DependsOn: []int{1}},
+		{Description: "d"},
+	}})
+	if g.Replans() != 0 {
+		t.Errorf("Replans() = %d after the first plan, want 0", g.Replans())
+	}
+	first := g.Tasks
+	first[0].Status = Completed
+	first[1].Status = Failed
+	first[2].Status = Blocked
+	first[3].Status = Completed
+
+	g.AddPlan(&Plan{Reason: "b failed", Thinking: "retry b", Tasks: []*Task{
+		{Description: "b2"},
+		{Description: "c2", DependsOn: []int{0}},
+	}})
+
+	var got []string
+	for _, task := range g.Tasks {
+		got = append(got, task.Description)
+	}
+	if want := []string{"a", "d", "b2", "c2"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("tasks after replanning = %v, want %v", got, want)
+	}
+	if deps := g.Tasks[3].DependsOn; !reflect.DeepEqual(deps, []int{2}) {
+		t.Errorf("c2 depends on %v, want [2]", deps)
+	}
+	if g.Tasks[2].MaxRetries != 3 {
+		t.Errorf("b2 MaxRetries = %d, want the goal's 3", g.Tasks[2].MaxRetries)
+	}
+	if first[1].Status != Failed || first[2].Status != Skipped {
+		t.Errorf("replaced tasks ended %s and %s, want Failed and Skipped", first[1].Status, first[2].Status)
+	}
+	if g.Replans() != 1 || len(g.Plans) != 2 || g.PlanThinking != "retry b" {
+		t.Errorf("Replans() = %d with %d plans and thinking %q", g.Replans(), len(g.Plans), g.PlanThinking)
+	}
+	if len(g.Plans[0].Tasks) != 4 {
+		t.Errorf("first plan has %d tasks, want 4", len(g.Plans[0].Tasks))
+	}
+}
@@ -0,0 +1,124 @@
+package goalengine
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Run executes the goal's tasks, calling run for each attempt of a task
+// until the task leaves the Pending state. A task starts once every task it
+// depends on has completed, and is skipped if one of them ends any other
+// way. Up to limit tasks run at the same time (one if limit is below 1), and
+// GUI tasks never run alongside each other. Run stops starting tasks when
+// ctx is cancelled and returns once the running ones are done; tasks it did
+// not get to are left for Finish.
+func (g *Goal) Run(ctx context.Context, limit int, run func(*Task)) {
+	if limit < 1 {
+		limit = 1
+	}
+
+	for _, task := range g.Tasks {
+		if task.Status == Pending && len(task.DependsOn) > 0 {
+			g.Transition(task, Blocked, "waiting for "+g.dependencyNames(task))
+		}
+	}
+
+	// Only the goroutine running a task touches it until it is finished
+	started := map[*Task]bool{}
+	finished := map[*Task]bool{}
+	done := make(chan *Task)
+	running := 0
+	var guiTask *Task // The running task marked GUI when it started
+	for {
+		g.release(started, finished)
+
+		for _, task := range g.Tasks {
+			if ctx.Err() != nil || running >= limit {
+				break
+			}
+			if started[task] || task.Status != Pending || (task.GUI && guiTask != nil) {
+				continue
+			}
+			started[task] = true
+			running++
+			if task.GUI {
+				guiTask = task
+			}
+			go func(task *Task) {
+				// A failed attempt puts the task back to Pending for the retry
+				for task.Status == Pending && ctx.Err() == nil {
+					run(task)
+				}
+				done <- task
+			}(task)
+		}
+
+		if running == 0 {
+			return
+		}
+		task := <-done
+		finished[task] = true
+		running--
+		if task == guiTask {
+			guiTask = nil
+		}
+	}
+}
+
+// release unblocks the blocked tasks whose dependencies have all completed
+// and skips those with a dependency that ended any other way. Skipping
+// cascades to the tasks that depend on the skipped ones.
+func (g *Goal) release(started, finished map[*Task]bool) {
+	for changed := true; changed; {
+		changed = false
+		for _, task := range g.Tasks {
+			if started[task] || task.Status != Blocked {
+				continue
+			}
+			ready := true
+			for _, i := range task.DependsOn {
+				dep := g.dependency(i)
+				if dep == nil || dep == task {
+					continue
+				}
+				if started[dep] && !finished[dep] {
+					ready = false
+					continue
+				}
+				if dep.Status.Terminal() && dep.Status != Completed {
+					g.Transition(task, Skipped, fmt.Sprintf("depends on '%s', which ended %s", dep.Description, dep.Status))
+					changed = true
+					ready = false
+					break
+				}
+				if dep.Status != Completed {
+					ready = false
+				}
+			}
+			if ready {
+				g.Transition(task, Pending, "dependencies completed")
+				changed = true
+			}
+		}
+	}
+}
+
+// dependency returns the task at index i, or nil if there is none.
+func (g *Goal) dependency(i int) *Task {
+	if i < 0 || i >= len(g.Tasks) {
+		return nil
+	}
+	return g.Tasks[i]
+}
+
+// dependencyNames lists the descriptions of the tasks task depends on.
+func (g *Goal) dependencyNames(task *Task) string {
+	var names []string
+	for _, i := range task.DependsOn {
+		if dep := g.dependency(i); dep != nil {
+			names = append(names, "'"+dep.Description+"'")
+		}
+	}
+	return strings.Join(names, ", ")
+}
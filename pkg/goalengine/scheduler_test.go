@@ -0,0 +1,260 @@
+package goalengine
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeExecutor completes or fails tasks by description and records when
+// they ran.
+type fakeExecutor struct {
+	goal   *Goal
+	fail   map[string]bool // Tasks whose attempts fail
+	retry  map[string]bool // Tasks whose first attempt is retried
+	setGUI map[string]bool // Tasks found to drive the desktop while running
+	delay  map[string]time.Duration
+
+	mu         sync.Mutex
+	started    []string
+	ended      []string
+	attempts   map[string]int
+	running    int
+	maxRunning int
+	gui        int
+	maxGUI     int
+}
+
+func (e *fakeExecutor) run(task *Task) {
+	e.goal.Transition(task, Running, "")
+	e.mu.Lock()
+	if e.attempts == nil {
+		e.attempts = map[string]int{}
+	}
+	e.attempts[task.Description]++
+	attempt := e.attempts[task.Description]
+	e.started = append(e.started, task.Description)
+	e.running++
+	if e.running > e.maxRunning {
+		e.maxRunning = e.running
+	}
+	gui := task.GUI
+	if gui {
+		e.gui++
+		if e.gui > e.maxGUI {
+			e.maxGUI = e.gui
+		}
+	}
+	e.mu.Unlock()
+
+	if e.setGUI[task.Description] {
+		task.GUI = true
+	}
+	delay := e.delay[task.Description]
+	if delay == 0 {
+		delay = 5 * time.Millisecond
+	}
+	time.Sleep(delay)
+
+	e.mu.Lock()
+	e.running--
+	if gui {
+		e.gui--
+	}
+	e.ended = append(e.ended, task.Description)
+	e.mu.Unlock()
+
+	switch {
+	case e.retry[task.Description] && attempt == 1:
+		e.goal.Transition(task, Pending, "retry")
+	case e.fail[task.Description]:
+		e.goal.Transition(task, Failed, "failed")
+	default:
+		e.goal.Transition(task, Completed, "")
+	}
+}
+
+func newGoal(tasks ...*Task) *Goal {
+	g := &Goal{Status: GoalRunning}
+	g.Tasks = tasks
+	return g
+}
+
+func statuses(g *Goal) []TaskStatus {
+	var got []TaskStatus
+	for _, task := range g.Tasks {
+		got = append(got, task.Status)
+	}
+	return got
+}
+
+func TestRunOrdersDependencies(t *testing.T) {
+	g := newGoal(
+		&Task{Description: "c", DependsOn: []int{1}},
+		&Task{Description: "b", DependsOn: []int{2}},
+		&Task{Description: "a"},
+	)
+	var mu sync.Mutex
+	var transitions []Transition
+	g.OnTransition = func(tr Transition) {
+		mu.Lock()
+		transitions = append(transitions, tr)
+		mu.Unlock()
+	}
+	e := &fakeExecutor{goal: g}
+	g.Run(context.Background(), 3, e.run)
+
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(e.started, want) {
+		t.Errorf("tasks started in order %v, want %v", e.started, want)
+	}
+	if want := []TaskStatus{Completed, Completed, Completed}; !reflect.DeepEqual(statuses(g), want) {
+		t.Errorf("tasks ended %v, want %v", statuses(g), want)
+	}
+	// Dependent tasks are blocked first and released once their dependency completed
+	want := []Transition{
+		{Task: "c", From: "Pending", To: "Blocked", Reason: "waiting for 'b'"},
+		{Task: "b", From: "Pending", To: "Blocked", Reason: "waiting for 'a'"},
+	}
+	if !reflect.DeepEqual(transitions[:2], want) {
+		t.Errorf("first transitions = %+v, want %+v", transitions[:2], want)
+	}
+	released := 0
+	for _, tr := range transitions {
+		if tr.From == "Blocked" && tr.To == "Pending" && tr.Reason == "dependencies completed" {
+			released++
+		}
+	}
+	if released != 2 {
+		t.Errorf("%d tasks were released, want 2 in %+v", released, transitions)
+	}
+}
+
+func TestRunSkipsDependentsOfFailedTasks(t *testing.T) {
+	g := newGoal(
+		&Task{Description: "a"},
+		&Task{Description: "b", DependsOn: []int{0}},
+		&Task{Description: "c", DependsOn: []int{1}},
+		&Task{Description: "d"},
+		&Task{Description: "e", DependsOn: []int{3, 0}},
+	)
+	e := &fakeExecutor{goal: g, fail: map[string]bool{"a": true}}
+	g.Run(context.Background(), 2, e.run)
+
+	if want := []TaskStatus{Failed, Skipped, Skipped, Completed, Skipped}; !reflect.DeepEqual(statuses(g), want) {
+		t.Errorf("tasks ended %v, want %v", statuses(g), want)
+	}
+	sort.Strings(e.started)
+	if want := []string{"a", "d"}; !reflect.DeepEqual(e.started, want) {
+		t.Errorf("started %v, want %v", e.started, want)
+	}
+}
+
+func TestRunRetriesPendingTasks(t *testing.T) {
+	g := newGoal(&Task{Description: "a"}, &Task{Description: "b", DependsOn: []int{0}})
+	e := &fakeExecutor{goal: g, retry: map[string]bool{"a": true}}
+	g.Run(context.Background(), 1, e.run)
+
+	if want := []string{"a", "a", "b"}; !reflect.DeepEqual(e.started, want) {
+		t.Errorf("started %v, want %v", e.started, want)
+	}
+	if want := []TaskStatus{Completed, Completed}; !reflect.DeepEqual(statuses(g), want) {
+		t.Errorf("tasks ended %v, want %v", statuses(g), want)
+	}
+}
+
+func TestRunLimit(t *testing.T) {
+	tests := []struct {
+		limit int
+		want  int
+	}{
+		{-1, 1},
+		{0, 1},
+		{1, 1},
+		{2, 2},
+		{10, 5},
+	}
+
+	for _, tt := range tests {
+		g := newGoal()
+		for _, name := range []string{"a", "b", "c", "d", "e"} {
+			g.Tasks = append(g.Tasks, &Task{Description: name})
+		}
+		e := &fakeExecutor{goal: g, delay: map[string]time.Duration{}}
+		for _, task := range g.Tasks {
+			e.delay[task.Description] = 20 * time.Millisecond
+		}
+		g.Run(context.Background(), tt.limit, e.run)
+
+		if e.maxRunning != tt.want {
+			t.Errorf("Run(limit %d) ran %d tasks at once, want %d", tt.limit, e.maxRunning, tt.want)
+		}
+		if len(e.ended) != 5 {
+			t.Errorf("Run(limit %d) ran %d tasks, want 5", tt.limit, len(e.ended))
+		}
+	}
+}
+
+func TestRunSerializesGUITasks(t *testing.T) {
+	g := newGoal(
+		&Task{Description: "gui1", GUI: true},
+		&Task{Description: "shell1"},
+		&Task{Description: "gui2", GUI: true},
+		&Task{Description: "shell2"},
+		&Task{Description: "gui3", GUI: true},
+	)
+	e := &fakeExecutor{goal: g, delay: map[string]time.Duration{
+		"gui1": 20 * time.Millisecond, "gui2": 20 * time.Millisecond, "gui3": 20 * time.Millisecond,
+		"shell1": 20 * time.Millisecond, "shell2": 20 * time.Millisecond,
+	}}
+	g.Run(context.Background(), 5, e.run)
+
+	if e.maxGUI != 1 {
+		t.Errorf("%d GUI tasks ran at once, want 1", e.maxGUI)
+	}
+	if e.maxRunning < 2 {
+		t.Errorf("shell tasks did not run alongside GUI tasks")
+	}
+	if len(e.ended) != 5 {
+		t.Errorf("ran %d tasks, want 5", len(e.ended))
+	}
+}
+
+func TestRunKeepsGUILockOfTaskMarkedWhileRunning(t *testing.T) {
+	// "found" turns out to drive the desktop and ends while "gui1" still
+	// runs; "gui2" must keep waiting for "gui1".
+	g := newGoal(
+		&Task{Description: "gui1", GUI: true},
+		&Task{Description: "found"},
+		&Task{Description: "gui2", GUI: true},
+	)
+	e := &fakeExecutor{
+		goal:   g,
+		setGUI: map[string]bool{"found": true},
+		delay:  map[string]time.Duration{"gui1": 50 * time.Millisecond, "found": time.Millisecond},
+	}
+	g.Run(context.Background(), 3, e.run)
+
+	if len(e.started) != 3 || e.started[2] != "gui2" {
+		t.Fatalf("started %v, want gui2 last", e.started)
+	}
+	if want := []string{"found", "gui1", "gui2"}; !reflect.DeepEqual(e.ended, want) {
+		t.Errorf("ended %v, want %v", e.ended, want)
+	}
+}
+
+func TestRunStopsStartingTasksWhenCancelled(t *testing.T) {
+	g := newGoal(&Task{Description: "a"}, &Task{Description: "b"})
+	ctx, cancel := context.WithCancel(context.Background())
+	e := &fakeExecutor{goal: g}
+	g.Run(ctx, 1, func(task *Task) {
+		e.run(task)
+		cancel()
+	})
+
+	if want := []TaskStatus{Completed, Pending}; !reflect.DeepEqual(statuses(g), want) {
+		t.Errorf("tasks ended %v, want %v", statuses(g), want)
+	}
+}
@@ -0,0 +1,305 @@
+package llm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"WSA/pkg/types"
+)
+
+const (
+	// DefaultContextWindow is assumed when a model's context length is unknown.
+	DefaultContextWindow = 4096
+	// DefaultMaxContextWindow caps the window used for models with very long
+	// contexts; Ollama reserves memory for the whole window. LLM_CONTEXT_WINDOW
+	// overrides it.
+	DefaultMaxContextWindow = 8192
+	// DefaultReplyReserve is kept free for the reply when num_predict is unset.
+	DefaultReplyReserve = 1024
+	// messageOverhead approximates the tokens the chat template adds per message.
+	messageOverhead = 4
+	// ollamaDefaultContext is the num_ctx Ollama uses when none is sent.
+	// Requests that fit in it leave num_ctx unset, as changing it reloads
+	// the model.
+	ollamaDefaultContext = 2048
+	// maxSummaries bounds the summary cache; the oldest entries go first.
+	maxSummaries = 64
+)
+
+// TokenReport breaks down how the context window of one chat request is used.
+// Counts are estimates except PromptTokens and CompletionTokens, which are
+// filled in from the server's reply when it reports them.
+type TokenReport struct {
+	Model           string `json:"model"`
+	ContextWindow   int    `json:"contextWindow"`
+	System          int    `json:"system"`  // System prompt
+	Tools           int    `json:"tools"`   // Tool definitions and response schema
+	Summary         int    `json:"summary"` // Summary of older turns
+	History         int    `json:"history"` // Earlier turns sent verbatim
+	Current         int    `json:"current"` // Latest message
+	ReplyReserve    int    `json:"replyReserve"`
+	SummarizedTurns int    `json:"summarizedTurns,omitempty"`
+	DroppedTurns    int    `json:"droppedTurns,omitempty"`
+
+	PromptTokens     int `json:"promptTokens,omitempty"`
+	CompletionTokens int `json:"completionTokens,omitempty"`
+}
+
+// Estimated returns the estimated prompt size in tokens.
+func (r *TokenReport) Estimated() int {
+	return r.System + r.Tools + r.Summary + r.History + r.Current
+}
+
+// Record copies the server's token counts from resp into the report.
+func (r *TokenReport) Record(resp *ChatResponse) {
+	if resp != nil {
+		r.PromptTokens = resp.PromptTokens
+		r.CompletionTokens = resp.CompletionTokens
+	}
+}
+
+func (r *TokenReport) String() string {
+	s := fmt.Sprintf("Tokens for %s: ~%d of %d (system %d, tools %d, summary %d, history %d, current %d, reply reserve %d)",
+		r.Model, r.Estimated(), r.ContextWindow, r.System, r.Tools, r.Summary, r.History, r.Current, r.ReplyReserve)
+	if r.SummarizedTurns > 0 {
+		s += fmt.Sprintf("; %d older turns summarized", r.SummarizedTurns)
+	}
+	if r.DroppedTurns > 0 {
+		s += fmt.Sprintf("; %d older turns dropped", r.DroppedTurns)
+	}
+	if r.PromptTokens > 0 || r.CompletionTokens > 0 {
+		s += fmt.Sprintf("; server counted %d prompt and %d completion tokens", r.PromptTokens, r.CompletionTokens)
+	}
+	return s
+}
+
+// EstimateTokens approximates the token count of s at four characters per token.
+func EstimateTokens(s string) int {
+	return (utf8.RuneCountInString(s) + 3) / 4
+}
+
+func estimateMessage(m types.PromptMessage) int {
+	n := messageOverhead + EstimateTokens(m.Content)
+	if len(m.ToolCalls) > 0 {
+		data, _ := json.Marshal(m.ToolCalls)
+		n += EstimateTokens(string(data))
+	}
+	return n
+}
+
+func estimateMessages(messages []types.PromptMessage) int {
+	n := 0
+	for _, m := range messages {
+		n += estimateMessage(m)
+	}
+	return n
+}
+
+// ContextWindow returns the number of tokens to budget for model: its
+// context length as reported by p, capped at the maximum window of the
+// options carried by ctx or LLM_CONTEXT_WINDOW.
+func ContextWindow(ctx context.Context, p Provider, model string) int {
+	window := DefaultContextWindow
+	if d, err := ShowModel(ctx, p, model); err == nil && d.ContextLength > 0 {
+		window = d.ContextLength
+	}
+	if max := maxContextWindow(ctx); window > max {
+		window = max
+	}
+	return window
+}
+
+func maxContextWindow(ctx context.Context) int {
+	if n := OptionsFrom(ctx).ContextWindow; n > 0 {
+		return n
+	}
+	if n, err := strconv.Atoi(os.Getenv("LLM_CONTEXT_WINDOW")); err == nil && n > 0 {
+		return n
+	}
+	return DefaultMaxContextWindow
+}
+
+// FitContext makes req fit the model's context window. Leading system
+// messages and the latest message are always kept. When the conversation in
+// between does not fit, its older turns are summarized by the model into the
+// system prompt, or dropped if that fails. On Ollama the window is also sent
+// as num_ctx when the request needs more than the server default, so the
+// server does not truncate the prompt itself.
+func FitContext(ctx context.Context, p Provider, req *ChatRequest) *TokenReport {
+	report := &TokenReport{
+		Model:         req.Model,
+		ContextWindow: ContextWindow(ctx, p, req.Model),
+		ReplyReserve:  DefaultReplyReserve,
+	}
+	if n, ok := req.Options["num_predict"].(int); ok && n > 0 {
+		report.ReplyReserve = n
+	}
+	defer setNumCtx(p, req, report)
+	if len(req.Tools) > 0 || req.Format != nil {
+		data, _ := json.Marshal(struct {
+			Tools  []types.Tool
+			Format Schema
+		}{req.Tools, req.Format})
+		report.Tools = EstimateTokens(string(data))
+	}
+
+	messages := req.Messages
+	systemEnd := 0
+	for systemEnd < len(messages) && messages[systemEnd].Role == "system" {
+		systemEnd++
+	}
+	if systemEnd == len(messages) {
+		report.System = estimateMessages(messages)
+		return report
+	}
+	system := messages[:systemEnd]
+	history := messages[systemEnd : len(messages)-1]
+	current := messages[len(messages)-1]
+	report.System = estimateMessages(system)
+	report.Current = estimateMessage(current)
+	report.History = estimateMessages(history)
+
+	available := report.ContextWindow - report.ReplyReserve - report.System - report.Tools - report.Current
+	if report.History <= available {
+		return report
+	}
+
+	// Keep the newest turns that fit in three quarters of the remaining
+	// space, leaving the rest for a summary of everything older.
+	keep := len(history)
+	kept := 0
+	for keep > 0 && kept+estimateMessage(history[keep-1]) <= available*3/4 {
+		keep--
+		kept += estimateMessage(history[keep])
+	}
+	// Never start the kept turns with tool results whose call was cut off
+	for keep < len(history) && history[keep].Role == "tool" {
+		kept -= estimateMessage(history[keep])
+		keep++
+	}
+	older, recent := history[:keep], history[keep:]
+	report.History = kept
+
+	fitted := append([]types.PromptMessage{}, system...)
+	summary, err := summarizeTurns(ctx, p, req.Model, older, available-kept)
+	if err == nil && summary != "" {
+		note := "\n\nSummary of the earlier conversation:\n" + summary
+		if len(fitted) == 0 {
+			fitted = append(fitted, types.PromptMessage{Role: "system"})
+		}
+		fitted[len(fitted)-1].Content += note
+		report.Summary = EstimateTokens(note)
+		report.SummarizedTurns = len(older)
+	} else {
+		if err != nil {
+			fmt.Printf("Failed to summarize older turns, dropping them: %v\n", err)
+		}
+		report.DroppedTurns = len(older)
+	}
+	fitted = append(fitted, recent...)
+	req.Messages = append(fitted, current)
+	return report
+}
+
+// setNumCtx sends the context window as num_ctx to Ollama when the fitted
+// request and its reply do not fit in the server's default window.
+func setNumCtx(p Provider, req *ChatRequest, report *TokenReport) {
+	if p.Name() != ProviderOllama || report.Estimated()+report.ReplyReserve <= ollamaDefaultContext {
+		return
+	}
+	if _, ok := req.Options["num_ctx"]; ok {
+		return
+	}
+	options := map[string]interface{}{"num_ctx": report.ContextWindow}
+	for k, v := range req.Options {
+		options[k] = v
+	}
+	req.Options = options
+}
+
+var (
+	summaryMu    sync.Mutex
+	summaries    = map[string]string{}
+	summaryOrder []string // Keys of summaries, oldest first
+)
+
+// summarizeTurns asks the model for a summary of turns of at most maxTokens.
+// Summaries are cached, since the same older turns are summarized again on
+// every later request of a goal.
+func summarizeTurns(ctx context.Context, p Provider, model string, turns []types.PromptMessage, maxTokens int) (string, error) {
+	if len(turns) == 0 {
+		return "", nil
+	}
+	if maxTokens < 64 {
+		return "", fmt.Errorf("no room left for a summary")
+	}
+	if maxTokens > 512 {
+		maxTokens = 512
+	}
+
+	var transcript strings.Builder
+	for _, turn := range turns {
+		role := turn.Role
+		if turn.ToolName != "" {
+			role += " (" + turn.ToolName + ")"
+		}
+		transcript.WriteString(role + ": " + turn.Content + "\n")
+	}
+	text := transcript.String()
+
+	// The transcript itself has to fit the window, so keep its newest part
+	window := ContextWindow(ctx, p, model)
+	limit := (window - DefaultReplyReserve) * 4
+	if limit > 0 && len(text) > limit {
+		start := len(text) - limit
+		for start < len(text) && !utf8.RuneStart(text[start]) {
+			start++
+		}
+		text = text[start:]
+	}
+
+	sum := sha256.Sum256([]byte(model + "\x00" + text))
+	key := hex.EncodeToString(sum[:])
+	summaryMu.Lock()
+	cached, ok := summaries[key]
+	summaryMu.Unlock()
+	if ok {
+		return cached, nil
+	}
+
+	req := ChatRequest{
+		Model: model,
+		Messages: []types.PromptMessage{
+			{Role: "system", Content: "Summarize the conversation below in a few sentences. " +
+				"Keep application names, file paths, commands and errors that later steps may need. Reply with the summary only."},
+			{Role: "user", Content: text},
+		},
+		Options: map[string]interface{}{"num_predict": maxTokens},
+	}
+	setNumCtx(p, &req, &TokenReport{ContextWindow: window, Current: estimateMessages(req.Messages), ReplyReserve: maxTokens})
+	resp, err := p.Chat(ctx, req)
+	if err != nil {
+		return "", err
+	}
+
+	summary := strings.TrimSpace(resp.Content)
+	summaryMu.Lock()
+	if _, ok := summaries[key]; !ok {
+		summaryOrder = append(summaryOrder, key)
+		if len(summaryOrder) > maxSummaries {
+			delete(summaries, summaryOrder[0])
+			summaryOrder = summaryOrder[1:]
+		}
+	}
+	summaries[key] = summary
+	summaryMu.Unlock()
+	return summary, nil
+}
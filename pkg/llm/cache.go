@@ -0,0 +1,213 @@
+package llm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"WSA/pkg/types"
+)
+
+// CacheKey identifies a cached response: the provider and its endpoint, the
+// model, a hash of the prompt and the request options as JSON.
+type CacheKey struct {
+	Provider   string // Name and endpoint, e.g. ollama@http://localhost:11434
+	Model      string
+	PromptHash string
+	Options    string
+}
+
+// String returns the key as a single hash.
+func (k CacheKey) String() string {
+	sum := sha256.Sum256([]byte(k.Provider + "\x00" + k.Model + "\x00" + k.PromptHash + "\x00" + k.Options))
+	return hex.EncodeToString(sum[:])
+}
+
+// Cache stores encoded model responses. Implementations own expiry and
+// size limits; Get reports a miss for expired entries.
+type Cache interface {
+	Get(key CacheKey) (value []byte, createdAt time.Time, ok bool)
+	Put(key CacheKey, value []byte)
+}
+
+var cache Cache
+
+// SetCache makes every provider returned by Named and Default serve repeated
+// Chat and Generate requests from c. Passing nil disables caching.
+func SetCache(c Cache) {
+	mu.Lock()
+	cache = c
+	mu.Unlock()
+}
+
+// cachedProvider serves Chat and Generate requests from a Cache and forwards
+// everything else to the wrapped provider.
+type cachedProvider struct {
+	Provider
+	cache Cache
+}
+
+func withCache(p Provider, c Cache) Provider {
+	if c == nil {
+		return p
+	}
+	return &cachedProvider{Provider: p, cache: c}
+}
+
+// Chat returns the cached response for req, or calls the provider and caches
+// its response.
+func (p *cachedProvider) Chat(ctx context.Context, req ChatRequest) (*ChatResponse, error) {
+	key := p.chatKey(req)
+	if resp, ok := p.getChat(key); ok {
+		return resp, nil
+	}
+	resp, err := p.Provider.Chat(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	p.put(key, resp)
+	return resp, nil
+}
+
+// ChatStream replays a cached response as a single chunk, or streams from
+// the provider and caches the accumulated response.
+func (p *cachedProvider) ChatStream(ctx context.Context, req ChatRequest, fn StreamFunc) (*ChatResponse, error) {
+	key := p.chatKey(req)
+	if resp, ok := p.getChat(key); ok {
+		fn(StreamChunk{Content: resp.Content, Thinking: resp.Thinking, Done: true})
+		return resp, nil
+	}
+	resp, err := ChatStream(ctx, p.Provider, req, fn)
+	if err != nil {
+		return nil, err
+	}
+	p.put(key, resp)
+	return resp, nil
+}
+
+// Generate returns the cached response for req, or calls the provider and
+// caches its response.
+func (p *cachedProvider) Generate(ctx context.Context, req GenerateRequest) (*GenerateResponse, error) {
+	key := CacheKey{
+		Provider:   providerID(p.Provider),
+		Model:      req.Model,
+		PromptHash: hashJSON(req.Prompt),
+		Options:    optionsJSON(req.Options),
+	}
+	if value, createdAt, ok := p.cache.Get(key); ok {
+		var resp GenerateResponse
+		if err := json.Unmarshal(value, &resp); err == nil {
+			resp.CachedAt = createdAt
+			return &resp, nil
+		}
+	}
+	resp, err := p.Provider.Generate(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	p.put(key, resp)
+	return resp, nil
+}
+
+// Endpoint, SupportsFormat, ShowModel, PullModel and the ModelLoader and ModelManager
+// methods keep the wrapped provider's optional capabilities visible through
+// the cache.
+func (p *cachedProvider) Endpoint() string {
+	if e, ok := p.Provider.(Endpointer); ok {
+		return e.Endpoint()
+	}
+	return ""
+}
+
+func (p *cachedProvider) SupportsFormat() bool {
+	return SupportsFormat(p.Provider)
+}
+
+func (p *cachedProvider) ShowModel(ctx context.Context, model string) (*ModelDetails, error) {
+	inspector, ok := p.Provider.(ModelInspector)
+	if !ok {
+		return nil, fmt.Errorf("provider %s cannot describe models", p.Name())
+	}
+	return inspector.ShowModel(ctx, model)
+}
+
+func (p *cachedProvider) PullModel(ctx context.Context, model string, fn PullFunc) error {
+	return Pull(ctx, p.Provider, model, fn)
+}
+
+func (p *cachedProvider) LoadModel(ctx context.Context, model, keepAlive string) error {
+	return Load(ctx, p.Provider, model, keepAlive)
+}
+
+func (p *cachedProvider) UnloadModel(ctx context.Context, model string) error {
+	return Unload(ctx, p.Provider, model)
+}
+
+func (p *cachedProvider) LoadedModels(ctx context.Context) ([]LoadedModel, error) {
+	return Loaded(ctx, p.Provider)
+}
+
+func (p *cachedProvider) DeleteModel(ctx context.Context, model string) error {
+	return Delete(ctx, p.Provider, model)
+}
+
+func (p *cachedProvider) CopyModel(ctx context.Context, source, destination string) error {
+	return Copy(ctx, p.Provider, source, destination)
+}
+
+func (p *cachedProvider) CreateModel(ctx context.Context, req CreateModelRequest) error {
+	return Create(ctx, p.Provider, req)
+}
+
+func (p *cachedProvider) chatKey(req ChatRequest) CacheKey {
+	return CacheKey{
+		Provider: providerID(p.Provider),
+		Model:    req.Model,
+		PromptHash: hashJSON(struct {
+			Messages []types.PromptMessage
+			Format   Schema
+			Tools    []types.Tool
+		}{req.Messages, req.Format, req.Tools}),
+		Options: optionsJSON(req.Options),
+	}
+}
+
+func (p *cachedProvider) getChat(key CacheKey) (*ChatResponse, bool) {
+	value, createdAt, ok := p.cache.Get(key)
+	if !ok {
+		return nil, false
+	}
+	var resp ChatResponse
+	if err := json.Unmarshal(value, &resp); err != nil {
+		return nil, false
+	}
+	resp.CachedAt = createdAt
+	fmt.Printf("LLM response for %s/%s (prompt %.12s) served from cache, stored %s\n", key.Provider, key.Model, key.PromptHash, createdAt.Format(time.RFC3339))
+	return &resp, true
+}
+
+func (p *cachedProvider) put(key CacheKey, resp interface{}) {
+	value, err := json.Marshal(resp)
+	if err != nil {
+		return
+	}
+	p.cache.Put(key, value)
+}
+
+func hashJSON(v interface{}) string {
+	data, _ := json.Marshal(v)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// optionsJSON encodes options with sorted keys so equal options match.
+func optionsJSON(options map[string]interface{}) string {
+	if len(options) == 0 {
+		return "{}"
+	}
+	data, _ := json.Marshal(options)
+	return string(data)
+}
@@ -0,0 +1,422 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// CassetteVersion is the format version written to cassette files.
+const CassetteVersion = 1
+
+// Cassette holds the model traffic of one goal: every request sent through
+// the provider and what came back, in order, with timing and streamed
+// chunks. A cassette filled by Record can be replayed by Replay to answer
+// the same requests offline and without a model.
+type Cassette struct {
+	Version    int       `json:"version"`
+	RecordedAt time.Time `json:"recordedAt"`
+	Provider   string    `json:"provider"`       // Name of the recorded provider
+	Format     bool      `json:"supportsFormat"` // Whether it constrained replies to a schema
+	// Roles and ContextWindow are the model chains and token budget in
+	// effect while recording; replays use them too so requests match.
+	Roles         map[Role][]string `json:"roles"`
+	ContextWindow int               `json:"contextWindow"`
+	// Request is the application's description of what was run, e.g. the
+	// goal and its options, for replaying it the same way.
+	Request      json.RawMessage `json:"request,omitempty"`
+	Interactions []Interaction   `json:"interactions"`
+	// Values are inputs of the prompts that change from run to run, such as
+	// the time, by name in the order they were taken; see Recorded.
+	Values map[string][]string `json:"values,omitempty"`
+
+	mu         sync.Mutex
+	started    time.Time
+	used       []bool
+	valuesUsed map[string]int
+}
+
+// Kinds of Interaction.
+const (
+	KindChat     = "chat" // Chat and ChatStream
+	KindGenerate = "generate"
+	KindVision   = "vision"
+	KindList     = "list"
+	KindShow     = "show"
+)
+
+// Interaction is one recorded provider call.
+type Interaction struct {
+	Kind       string          `json:"kind"`
+	Model      string          `json:"model,omitempty"`
+	Request    json.RawMessage `json:"request"`
+	Response   json.RawMessage `json:"response,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	Chunks     []RecordedChunk `json:"chunks,omitempty"` // Set for streamed chats
+	StartMs    int64           `json:"startMs"`          // Milliseconds after recording started
+	DurationMs int64           `json:"durationMs"`
+}
+
+// RecordedChunk is a streamed chunk and when it arrived.
+type RecordedChunk struct {
+	AtMs     int64  `json:"atMs"` // Milliseconds after the request was sent
+	Content  string `json:"content,omitempty"`
+	Thinking string `json:"thinking,omitempty"`
+	Done     bool   `json:"done,omitempty"`
+}
+
+// NewCassette returns an empty cassette to record into.
+func NewCassette() *Cassette {
+	return &Cassette{Version: CassetteVersion, RecordedAt: time.Now(), Interactions: []Interaction{}}
+}
+
+// LoadCassette reads a cassette written by Save.
+func LoadCassette(path string) (*Cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cassette: %w", err)
+	}
+	var c Cassette
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("failed to parse cassette %s: %w", path, err)
+	}
+	if c.Version != CassetteVersion {
+		return nil, fmt.Errorf("cassette %s has unsupported version %d", path, c.Version)
+	}
+	return &c, nil
+}
+
+// Save writes the cassette to path, creating its directory.
+func (c *Cassette) Save(path string) error {
+	c.mu.Lock()
+	data, err := json.MarshalIndent(c, "", "  ")
+	c.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode cassette: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create cassette directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write cassette: %w", err)
+	}
+	return nil
+}
+
+// Record returns a copy of ctx whose model calls, made through For, are
+// recorded to c along with the model chains and token budget of ctx.
+func Record(ctx context.Context, c *Cassette) context.Context {
+	c.mu.Lock()
+	c.started = time.Now()
+	c.Roles = map[Role][]string{}
+	for _, role := range Roles {
+		c.Roles[role] = RoleModels(ctx, role)
+	}
+	c.ContextWindow = maxContextWindow(ctx)
+	c.mu.Unlock()
+
+	opts := OptionsFrom(ctx)
+	opts.Record = c
+	return WithOptions(ctx, opts)
+}
+
+// ErrNotRecorded is returned during a replay for a request the cassette did
+// not record.
+var ErrNotRecorded = errors.New("request was not recorded")
+
+// Replay returns a copy of ctx whose model calls, made through For, are
+// answered from c instead of a model server. Each request must match a
+// recorded interaction of the same kind exactly; one that differs from the
+// recording, e.g. because the prompt names another user, fails with
+// ErrNotRecorded. The recorded model chains and token budget replace those
+// of ctx.
+func Replay(ctx context.Context, c *Cassette) context.Context {
+	c.mu.Lock()
+	c.used = make([]bool, len(c.Interactions))
+	c.valuesUsed = map[string]int{}
+	c.mu.Unlock()
+
+	opts := OptionsFrom(ctx)
+	opts.Replay = c
+	opts.Record = nil
+	opts.Roles = c.Roles
+	opts.ContextWindow = c.ContextWindow
+	return WithOptions(ctx, opts)
+}
+
+// Recorded returns value(). While recording, the result is saved to the
+// cassette under name; during a replay, the values recorded under name are
+// returned in order instead, so prompts built from them match the recording.
+// A replay that runs out of recorded values falls back to value().
+func Recorded(ctx context.Context, name string, value func() string) string {
+	opts := OptionsFrom(ctx)
+	if c := opts.Replay; c != nil {
+		c.mu.Lock()
+		values, i := c.Values[name], c.valuesUsed[name]
+		if i < len(values) {
+			c.valuesUsed[name]++
+			c.mu.Unlock()
+			return values[i]
+		}
+		c.mu.Unlock()
+		fmt.Printf("Replay has no recorded %s value left; taking a new one\n", name)
+		return value()
+	}
+
+	v := value()
+	if c := opts.Record; c != nil {
+		c.mu.Lock()
+		if c.Values == nil {
+			c.Values = map[string][]string{}
+		}
+		c.Values[name] = append(c.Values[name], v)
+		c.mu.Unlock()
+	}
+	return v
+}
+
+// add appends an interaction that started at start.
+func (c *Cassette) add(i Interaction, start time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i.StartMs = start.Sub(c.started).Milliseconds()
+	i.DurationMs = time.Since(start).Milliseconds()
+	c.Interactions = append(c.Interactions, i)
+}
+
+// next returns the first unused recorded interaction of kind whose request
+// is the same as request, marking it used. Model metadata (lists and
+// details) may be served more than once.
+func (c *Cassette) next(kind string, request json.RawMessage) (Interaction, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := requestKey(request)
+	reuse := -1
+	for i, recorded := range c.Interactions {
+		if recorded.Kind != kind || requestKey(recorded.Request) != key {
+			continue
+		}
+		if !c.used[i] {
+			c.used[i] = true
+			return recorded, nil
+		}
+		if kind == KindList || kind == KindShow {
+			reuse = i
+		}
+	}
+	if reuse >= 0 {
+		return c.Interactions[reuse], nil
+	}
+
+	err := fmt.Errorf("%w: no %s interaction left for request %s", ErrNotRecorded, kind, key[:12])
+	fmt.Printf("Replay failed: %v\n", err)
+	return Interaction{}, err
+}
+
+// requestKey hashes request regardless of the indentation Save adds.
+func requestKey(request json.RawMessage) string {
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, request); err != nil {
+		compact.Write(request)
+	}
+	sum := sha256.Sum256(compact.Bytes())
+	return hex.EncodeToString(sum[:])
+}
+
+// requestJSON encodes a request for a cassette. Images are replaced by their
+// hashes to keep screenshots out of the file.
+func requestJSON(req interface{}) json.RawMessage {
+	if v, ok := req.(VisionRequest); ok {
+		images := make([]string, len(v.Images))
+		for i, image := range v.Images {
+			sum := sha256.Sum256([]byte(image))
+			images[i] = "sha256:" + hex.EncodeToString(sum[:])
+		}
+		v.Images = images
+		req = v
+	}
+	data, _ := json.Marshal(req)
+	return data
+}
+
+// recordingProvider passes calls to the wrapped provider and records them.
+type recordingProvider struct {
+	Provider
+	cassette *Cassette
+}
+
+func withRecording(p Provider, c *Cassette) Provider {
+	c.mu.Lock()
+	c.Provider = p.Name()
+	c.Format = SupportsFormat(p)
+	c.mu.Unlock()
+	return &recordingProvider{Provider: p, cassette: c}
+}
+
+func (p *recordingProvider) record(kind, model string, req interface{}, start time.Time, resp interface{}, err error, chunks []RecordedChunk) {
+	i := Interaction{Kind: kind, Model: model, Request: requestJSON(req), Chunks: chunks}
+	if err != nil {
+		i.Error = err.Error()
+	} else {
+		i.Response, _ = json.Marshal(resp)
+	}
+	p.cassette.add(i, start)
+}
+
+func (p *recordingProvider) Chat(ctx context.Context, req ChatRequest) (*ChatResponse, error) {
+	start := time.Now()
+	resp, err := p.Provider.Chat(ctx, req)
+	p.record(KindChat, req.Model, req, start, resp, err, nil)
+	return resp, err
+}
+
+func (p *recordingProvider) ChatStream(ctx context.Context, req ChatRequest, fn StreamFunc) (*ChatResponse, error) {
+	start := time.Now()
+	var chunks []RecordedChunk
+	resp, err := ChatStream(ctx, p.Provider, req, func(chunk StreamChunk) {
+		chunks = append(chunks, RecordedChunk{
+			AtMs:     time.Since(start).Milliseconds(),
+			Content:  chunk.Content,
+			Thinking: chunk.Thinking,
+			Done:     chunk.Done,
+		})
+		fn(chunk)
+	})
+	p.record(KindChat, req.Model, req, start, resp, err, chunks)
+	return resp, err
+}
+
+func (p *recordingProvider) Generate(ctx context.Context, req GenerateRequest) (*GenerateResponse, error) {
+	start := time.Now()
+	resp, err := p.Provider.Generate(ctx, req)
+	p.record(KindGenerate, req.Model, req, start, resp, err, nil)
+	return resp, err
+}
+
+func (p *recordingProvider) Vision(ctx context.Context, req VisionRequest) (*GenerateResponse, error) {
+	start := time.Now()
+	resp, err := p.Provider.Vision(ctx, req)
+	p.record(KindVision, req.Model, req, start, resp, err, nil)
+	return resp, err
+}
+
+func (p *recordingProvider) ListModels(ctx context.Context) ([]ModelInfo, error) {
+	start := time.Now()
+	models, err := p.Provider.ListModels(ctx)
+	p.record(KindList, "", struct{}{}, start, models, err, nil)
+	return models, err
+}
+
+func (p *recordingProvider) SupportsFormat() bool {
+	return SupportsFormat(p.Provider)
+}
+
+func (p *recordingProvider) ShowModel(ctx context.Context, model string) (*ModelDetails, error) {
+	inspector, ok := p.Provider.(ModelInspector)
+	if !ok {
+		return nil, fmt.Errorf("provider %s cannot describe models", p.Name())
+	}
+	start := time.Now()
+	details, err := inspector.ShowModel(ctx, model)
+	p.record(KindShow, model, map[string]string{"model": model}, start, details, err, nil)
+	return details, err
+}
+
+// bypassDetailsCache makes ShowModel ask for details on every call, so each
+// one is recorded.
+func (p *recordingProvider) bypassDetailsCache() {}
+
+// replayProvider answers calls from a cassette.
+type replayProvider struct {
+	cassette *Cassette
+}
+
+func (p *replayProvider) replay(kind string, req interface{}, resp interface{}) (Interaction, error) {
+	recorded, err := p.cassette.next(kind, requestJSON(req))
+	if err != nil {
+		return recorded, err
+	}
+	if recorded.Error != "" {
+		return recorded, errors.New(recorded.Error)
+	}
+	if err := json.Unmarshal(recorded.Response, resp); err != nil {
+		return recorded, fmt.Errorf("failed to decode recorded %s response: %w", kind, err)
+	}
+	return recorded, nil
+}
+
+func (p *replayProvider) Name() string {
+	return p.cassette.Provider
+}
+
+func (p *replayProvider) SupportsFormat() bool {
+	return p.cassette.Format
+}
+
+func (p *replayProvider) Chat(ctx context.Context, req ChatRequest) (*ChatResponse, error) {
+	var resp ChatResponse
+	if _, err := p.replay(KindChat, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ChatStream passes the recorded chunks to fn, or the whole response as one
+// chunk if it was not streamed when recorded.
+func (p *replayProvider) ChatStream(ctx context.Context, req ChatRequest, fn StreamFunc) (*ChatResponse, error) {
+	var resp ChatResponse
+	recorded, err := p.replay(KindChat, req, &resp)
+	for _, chunk := range recorded.Chunks {
+		fn(StreamChunk{Content: chunk.Content, Thinking: chunk.Thinking, Done: chunk.Done})
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(recorded.Chunks) == 0 {
+		fn(StreamChunk{Content: resp.Content, Thinking: resp.Thinking, Done: true})
+	}
+	return &resp, nil
+}
+
+func (p *replayProvider) Generate(ctx context.Context, req GenerateRequest) (*GenerateResponse, error) {
+	var resp GenerateResponse
+	if _, err := p.replay(KindGenerate, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (p *replayProvider) Vision(ctx context.Context, req VisionRequest) (*GenerateResponse, error) {
+	var resp GenerateResponse
+	if _, err := p.replay(KindVision, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (p *replayProvider) ListModels(ctx context.Context) ([]ModelInfo, error) {
+	var models []ModelInfo
+	if _, err := p.replay(KindList, struct{}{}, &models); err != nil {
+		return nil, err
+	}
+	return models, nil
+}
+
+func (p *replayProvider) ShowModel(ctx context.Context, model string) (*ModelDetails, error) {
+	var details ModelDetails
+	if _, err := p.replay(KindShow, map[string]string{"model": model}, &details); err != nil {
+		return nil, err
+	}
+	return &details, nil
+}
+
+func (p *replayProvider) bypassDetailsCache() {}
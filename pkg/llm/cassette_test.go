@@ -0,0 +1,232 @@
+package llm
+
+import (
+	"WSA/pkg/types"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// stubProvider answers every call from its arguments and counts the calls.
+type stubProvider struct {
+	calls int
+}
+
+func (p *stubProvider) Name() string { return "stub" }
+
+func (p *stubProvider) Chat(ctx context.Context, req ChatRequest) (*ChatResponse, error) {
+	p.calls++
+	return &ChatResponse{Model: req.Model, Content: "re: " + req.Messages[len(req.Messages)-1].Content}, nil
+}
+
+func (p *stubProvider) ChatStream(ctx context.Context, req ChatRequest, fn StreamFunc) (*ChatResponse, error) {
+	resp, _ := p.Chat(ctx, req)
+	fn(StreamChunk{Thinking: "hmm"})
+	fn(StreamChunk{Content: resp.Content[:3]})
+	fn(StreamChunk{Content: resp.Content[3:], Done: true})
+	return resp, nil
+}
+
+func (p *stubProvider) Generate(ctx context.Context, req GenerateRequest) (*GenerateResponse, error) {
+	p.calls++
+	return &GenerateResponse{Model: req.Model, Response: "gen: " + req.Prompt}, nil
+}
+
+func (p *stubProvider) Vision(ctx context.Context, req VisionRequest) (*GenerateResponse, error) {
+	p.calls++
+	return &GenerateResponse{Model: req.Model, Response: "seen"}, nil
+}
+
+func (p *stubProvider) ListModels(ctx context.Context) ([]ModelInfo, error) {
+	p.calls++
+	return []ModelInfo{{Name: "m"}}, nil
+}
+
+func (p *stubProvider) ShowModel(ctx context.Context, model string) (*ModelDetails, error) {
+	p.calls++
+	return &ModelDetails{Name: model, ContextLength: 4096}, nil
+}
+
+func chatRequest(content string) ChatRequest {
+	return ChatRequest{Model: "m", Messages: []types.PromptMessage{{Role: "user", Content: content}}}
+}
+
+// recordCassette records a chat, a streamed chat, a generation and model
+// metadata through a stub provider, saves the cassette and loads it again.
+func recordCassette(t *testing.T) (*Cassette, *stubProvider) {
+	t.Helper()
+	stub := &stubProvider{}
+	SetDefault(stub)
+	t.Cleanup(func() { SetDefault(nil) })
+
+	c := NewCassette()
+	c.Request = []byte(`{"goal": "test"}`)
+	ctx := Record(context.Background(), c)
+	p := For(ctx)
+	if _, err := p.Chat(ctx, chatRequest("hello")); err != nil {
+		t.Fatalf("Chat failed: %v", err)
+	}
+	if _, err := ChatStream(ctx, p, chatRequest("stream"), func(StreamChunk) {}); err != nil {
+		t.Fatalf("ChatStream failed: %v", err)
+	}
+	if _, err := p.Generate(ctx, GenerateRequest{Model: "m", Prompt: "p"}); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if _, err := p.ListModels(ctx); err != nil {
+		t.Fatalf("ListModels failed: %v", err)
+	}
+	if _, err := p.(ModelInspector).ShowModel(ctx, "m"); err != nil {
+		t.Fatalf("ShowModel failed: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "cassettes", "test.json")
+	if err := c.Save(path); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loaded, err := LoadCassette(path)
+	if err != nil {
+		t.Fatalf("LoadCassette failed: %v", err)
+	}
+	return loaded, stub
+}
+
+func TestCassetteRecordAndReplay(t *testing.T) {
+	c, stub := recordCassette(t)
+	if len(c.Interactions) != 5 {
+		t.Fatalf("recorded %d interactions, want 5", len(c.Interactions))
+	}
+	var request struct{ Goal string }
+	if err := json.Unmarshal(c.Request, &request); err != nil || c.Provider != "stub" || request.Goal != "test" {
+		t.Errorf("loaded cassette provider %q request %s", c.Provider, c.Request)
+	}
+	recordedCalls := stub.calls
+
+	ctx := Replay(context.Background(), c)
+	p := For(ctx)
+	resp, err := p.Chat(ctx, chatRequest("hello"))
+	if err != nil || resp.Content != "re: hello" {
+		t.Errorf("replayed Chat = %+v, %v, want re: hello", resp, err)
+	}
+	gen, err := p.Generate(ctx, GenerateRequest{Model: "m", Prompt: "p"})
+	if err != nil || gen.Response != "gen: p" {
+		t.Errorf("replayed Generate = %+v, %v, want gen: p", gen, err)
+	}
+	for i := 0; i < 2; i++ {
+		models, err := p.ListModels(ctx)
+		if err != nil || len(models) != 1 || models[0].Name != "m" {
+			t.Errorf("replayed ListModels call %d = %+v, %v", i+1, models, err)
+		}
+		details, err := p.(ModelInspector).ShowModel(ctx, "m")
+		if err != nil || details.ContextLength != 4096 {
+			t.Errorf("replayed ShowModel call %d = %+v, %v", i+1, details, err)
+		}
+	}
+	if stub.calls != recordedCalls {
+		t.Errorf("replay called the provider %d times", stub.calls-recordedCalls)
+	}
+}
+
+func TestCassetteReplaysStreamedChunks(t *testing.T) {
+	c, _ := recordCassette(t)
+	ctx := Replay(context.Background(), c)
+
+	var chunks []StreamChunk
+	resp, err := ChatStream(ctx, For(ctx), chatRequest("stream"), func(chunk StreamChunk) {
+		chunks = append(chunks, chunk)
+	})
+	if err != nil {
+		t.Fatalf("replayed ChatStream failed: %v", err)
+	}
+	want := []StreamChunk{{Thinking: "hmm"}, {Content: "re:"}, {Content: " stream", Done: true}}
+	if !reflect.DeepEqual(chunks, want) {
+		t.Errorf("replayed chunks = %+v, want %+v", chunks, want)
+	}
+	if resp.Content != "re: stream" {
+		t.Errorf("replayed ChatStream content = %q, want %q", resp.Content, "re: stream")
+	}
+
+	// A chat recorded without streaming is delivered as one chunk
+	chunks = nil
+	if _, err := ChatStream(ctx, For(ctx), chatRequest("hello"), func(chunk StreamChunk) {
+		chunks = append(chunks, chunk)
+	}); err != nil {
+		t.Fatalf("replayed ChatStream failed: %v", err)
+	}
+	if want := []StreamChunk{{Content: "re: hello", Done: true}}; !reflect.DeepEqual(chunks, want) {
+		t.Errorf("replayed chunks = %+v, want %+v", chunks, want)
+	}
+}
+
+func TestCassetteChatsAreUsedOnce(t *testing.T) {
+	c, _ := recordCassette(t)
+	ctx := Replay(context.Background(), c)
+	p := For(ctx)
+	if _, err := p.Chat(ctx, chatRequest("hello")); err != nil {
+		t.Fatalf("first replayed Chat failed: %v", err)
+	}
+	if _, err := p.Chat(ctx, chatRequest("hello")); !errors.Is(err, ErrNotRecorded) {
+		t.Errorf("second replayed Chat error = %v, want ErrNotRecorded", err)
+	}
+}
+
+func TestCassetteRejectsChangedRequests(t *testing.T) {
+	c, _ := recordCassette(t)
+	ctx := Replay(context.Background(), c)
+	p := For(ctx)
+
+	tests := []struct {
+		name string
+		call func() error
+	}{
+		{"chat content", func() error { _, err := p.Chat(ctx, chatRequest("goodbye")); return err }},
+		{"chat model", func() error {
+			req := chatRequest("hello")
+			req.Model = "other"
+			_, err := p.Chat(ctx, req)
+			return err
+		}},
+		{"generate prompt", func() error {
+			_, err := p.Generate(ctx, GenerateRequest{Model: "m", Prompt: "q"})
+			return err
+		}},
+		{"unrecorded kind", func() error {
+			_, err := p.Vision(ctx, VisionRequest{Model: "m", Prompt: "p"})
+			return err
+		}},
+		{"model details", func() error { _, err := p.(ModelInspector).ShowModel(ctx, "other"); return err }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.call(); !errors.Is(err, ErrNotRecorded) {
+				t.Errorf("error = %v, want ErrNotRecorded", err)
+			}
+		})
+	}
+}
+
+func TestRequestKeyIgnoresIndentation(t *testing.T) {
+	compact := requestKey([]byte(`{"model":"m","messages":[{"role":"user"}]}`))
+	indented := requestKey([]byte("{\n  \"model\": \"m\",\n  \"messages\": [\n    {\"role\": \"user\"}\n  ]\n}"))
+	if compact != indented {
+		t.Errorf("requestKey differs for indented JSON: %s != %s", compact, indented)
+	}
+	if other := requestKey([]byte(`{"model":"n","messages":[{"role":"user"}]}`)); other == compact {
+		t.Error("requestKey is the same for different requests")
+	}
+}
+
+func TestLoadCassetteRejectsOtherVersions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "old.json")
+	if err := os.WriteFile(path, []byte(`{"version": 99, "interactions": []}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := LoadCassette(path)
+	if err == nil || !strings.Contains(err.Error(), "unsupported version 99") {
+		t.Errorf("LoadCassette error = %v, want unsupported version 99", err)
+	}
+}
@@ -0,0 +1,253 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"WSA/pkg/types"
+)
+
+// Provider names accepted by New and the LLM_PROVIDER environment variable.
+const (
+	ProviderOllama = "ollama"
+	ProviderOpenAI = "openai"
+)
+
+// DefaultEndpoint is the base URL of a local Ollama server.
+const DefaultEndpoint = "http://localhost:11434"
+
+// DefaultTimeout bounds a single non-streaming request to the model server.
+// Reasoning models routinely take 20-40 seconds to plan a goal.
+const DefaultTimeout = 120 * time.Second
+
+// ChatRequest is a provider-neutral chat completion request. Format, when
+// set, asks providers that implement FormatSupporter to constrain the reply
+// to that JSON schema. Tools lists the functions the model may call.
+type ChatRequest struct {
+	Model    string
+	Messages []types.PromptMessage
+	Options  map[string]interface{}
+	Format   Schema
+	Tools    []types.Tool
+}
+
+// ChatResponse is the assistant message returned for a ChatRequest.
+type ChatResponse struct {
+	Model     string
+	Content   string
+	Thinking  string
+	ToolCalls []types.ToolCall
+	Raw       []byte `json:"-"`
+
+	// Token counts reported by the server, or 0 if it reported none.
+	PromptTokens     int
+	CompletionTokens int
+
+	// CachedAt is when the response was stored if it was served from the
+	// response cache, and zero otherwise.
+	CachedAt time.Time `json:"-"`
+}
+
+// splitThinking moves a leading <think>...</think> block, which some
+// reasoning models emit inline instead of in a separate thinking field, out of
+// the content. Responses that already carry thinking are left as they are.
+func (r *ChatResponse) splitThinking() {
+	if r.Thinking != "" {
+		return
+	}
+	trimmed := strings.TrimSpace(r.Content)
+	if !strings.HasPrefix(trimmed, "<think>") {
+		return
+	}
+	thinking, rest, found := strings.Cut(strings.TrimPrefix(trimmed, "<think>"), "</think>")
+	if !found {
+		return
+	}
+	r.Thinking = strings.TrimSpace(thinking)
+	r.Content = strings.TrimSpace(rest)
+}
+
+// GenerateRequest is a single-prompt completion request.
+type GenerateRequest struct {
+	Model   string
+	Prompt  string
+	Options map[string]interface{}
+}
+
+// VisionRequest is a prompt plus one or more base64-encoded images.
+type VisionRequest struct {
+	Model   string
+	Prompt  string
+	Images  []string
+	Options map[string]interface{}
+}
+
+// GenerateResponse is the text returned for a GenerateRequest or VisionRequest.
+type GenerateResponse struct {
+	Model    string
+	Response string
+	Raw      []byte    `json:"-"`
+	CachedAt time.Time `json:"-"` // Set when served from the response cache
+}
+
+// ModelInfo describes a model the provider can serve.
+type ModelInfo struct {
+	Name       string `json:"name"`
+	ModifiedAt string `json:"modified_at"`
+	Size       int64  `json:"size"`
+}
+
+// Provider is implemented by every LLM backend. All model calls in the
+// application go through a Provider so backends, timeouts and test doubles
+// can be swapped in one place.
+type Provider interface {
+	Name() string
+	Chat(ctx context.Context, req ChatRequest) (*ChatResponse, error)
+	Generate(ctx context.Context, req GenerateRequest) (*GenerateResponse, error)
+	Vision(ctx context.Context, req VisionRequest) (*GenerateResponse, error)
+	ListModels(ctx context.Context) ([]ModelInfo, error)
+}
+
+// Endpointer is implemented by providers that talk to a server, so caches
+// can keep the answers of servers of the same kind apart.
+type Endpointer interface {
+	Endpoint() string
+}
+
+// providerID identifies p and the server it talks to.
+func providerID(p Provider) string {
+	if e, ok := p.(Endpointer); ok && e.Endpoint() != "" {
+		return p.Name() + "@" + e.Endpoint()
+	}
+	return p.Name()
+}
+
+var (
+	mu        sync.Mutex
+	override  Provider
+	providers = map[string]Provider{}
+)
+
+// New creates a provider by name. An empty name selects Ollama.
+func New(name, endpoint, apiKey string) (Provider, error) {
+	return newProvider(name, endpoint, apiKey, DefaultTimeout)
+}
+
+func newProvider(name, endpoint, apiKey string, timeout time.Duration) (Provider, error) {
+	switch {
+	case isOpenAI(name):
+		return NewOpenAIProvider(endpoint, apiKey, timeout), nil
+	case strings.TrimSpace(name) == "" || strings.EqualFold(strings.TrimSpace(name), ProviderOllama):
+		return NewOllamaProvider(endpoint, timeout), nil
+	default:
+		return nil, fmt.Errorf("unknown LLM provider %q", name)
+	}
+}
+
+// Default returns the provider selected by LLM_PROVIDER, or the provider
+// installed with SetDefault. Unknown names fall back to Ollama.
+func Default() Provider {
+	p, err := Named(os.Getenv("LLM_PROVIDER"))
+	if err != nil {
+		p, _ = Named(ProviderOllama)
+	}
+	return p
+}
+
+// Named returns the provider for name configured from the environment.
+// Ollama reads LLM_API_ENDPOINT; OpenAI-compatible servers read
+// OPENAI_API_BASE, OPENAI_API_KEY and OPENAI_JSON_SCHEMA, which set to false
+// stops sending response schemas. Providers are cached per configuration.
+func Named(name string) (Provider, error) {
+	return configured(name, "", "", 0)
+}
+
+// configured returns the provider for name, reading the endpoint and API key
+// from the environment when they are empty.
+func configured(name, endpoint, apiKey string, timeout time.Duration) (Provider, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if override != nil {
+		return withCache(override, cache), nil
+	}
+
+	jsonSchema := true
+	if isOpenAI(name) {
+		if value, err := strconv.ParseBool(os.Getenv("OPENAI_JSON_SCHEMA")); err == nil {
+			jsonSchema = value
+		}
+		if endpoint == "" {
+			endpoint = os.Getenv("OPENAI_API_BASE")
+		}
+		if apiKey == "" {
+			apiKey = os.Getenv("OPENAI_API_KEY")
+		}
+	} else if endpoint == "" {
+		endpoint = os.Getenv("LLM_API_ENDPOINT")
+	}
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	key := strings.ToLower(name) + "|" + endpoint + "|" + apiKey + "|" + timeout.String() + "|" + strconv.FormatBool(jsonSchema)
+	if p, ok := providers[key]; ok {
+		return withCache(p, cache), nil
+	}
+	p, err := newProvider(name, endpoint, apiKey, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if openai, ok := p.(*OpenAIProvider); ok {
+		openai.JSONSchema = jsonSchema
+	}
+	providers[key] = p
+	return withCache(p, cache), nil
+}
+
+// SetDefault installs p as the provider for every call, e.g. a test double.
+// Passing nil restores environment-based selection.
+func SetDefault(p Provider) {
+	mu.Lock()
+	override = p
+	mu.Unlock()
+}
+
+// OpenAIConfigured reports whether an OpenAI-compatible endpoint is set.
+func OpenAIConfigured() bool {
+	return os.Getenv("OPENAI_API_BASE") != ""
+}
+
+func isOpenAI(name string) bool {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case ProviderOpenAI, "openai-compatible", "llamacpp", "vllm":
+		return true
+	}
+	return false
+}
+
+// BaseURL strips any API path from endpoint, falling back to DefaultEndpoint.
+func BaseURL(endpoint string) string {
+	endpoint = strings.TrimSpace(endpoint)
+	if endpoint == "" {
+		return DefaultEndpoint
+	}
+	if i := strings.Index(endpoint, "/api/"); i >= 0 {
+		endpoint = endpoint[:i]
+	}
+	return strings.TrimSuffix(endpoint, "/")
+}
+
+// ModelFromEnv returns model if set, otherwise LLM_MODEL, otherwise fallback.
+func ModelFromEnv(model, fallback string) string {
+	if model != "" {
+		return model
+	}
+	if env := os.Getenv("LLM_MODEL"); env != "" {
+		return env
+	}
+	return fallback
+}
@@ -0,0 +1,99 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// CreateModelRequest describes a model derived from an installed one.
+type CreateModelRequest struct {
+	Model      string                 `json:"model"`                // Name of the new model
+	From       string                 `json:"from"`                 // Installed model it is based on
+	System     string                 `json:"system,omitempty"`     // Built-in system prompt
+	Parameters map[string]interface{} `json:"parameters,omitempty"` // Built-in options, e.g. temperature
+}
+
+// ModelManager is implemented by providers that manage their model store.
+type ModelManager interface {
+	DeleteModel(ctx context.Context, model string) error
+	CopyModel(ctx context.Context, source, destination string) error
+	CreateModel(ctx context.Context, req CreateModelRequest) error
+}
+
+func managerOf(p Provider) (ModelManager, error) {
+	manager, ok := p.(ModelManager)
+	if !ok {
+		return nil, fmt.Errorf("provider %s cannot manage models", p.Name())
+	}
+	return manager, nil
+}
+
+// Delete removes model from p's model store.
+func Delete(ctx context.Context, p Provider, model string) error {
+	manager, err := managerOf(p)
+	if err != nil {
+		return err
+	}
+	if err := manager.DeleteModel(ctx, model); err != nil {
+		return err
+	}
+	ForgetModel(model)
+	return nil
+}
+
+// Copy stores model source under the name destination as well, e.g. to tag
+// it.
+func Copy(ctx context.Context, p Provider, source, destination string) error {
+	manager, err := managerOf(p)
+	if err != nil {
+		return err
+	}
+	if err := manager.CopyModel(ctx, source, destination); err != nil {
+		return err
+	}
+	ForgetModel(destination)
+	return nil
+}
+
+// Create builds the model described by req, replacing any model of the same
+// name.
+func Create(ctx context.Context, p Provider, req CreateModelRequest) error {
+	manager, err := managerOf(p)
+	if err != nil {
+		return err
+	}
+	if err := manager.CreateModel(ctx, req); err != nil {
+		return err
+	}
+	ForgetModel(req.Model)
+	return nil
+}
+
+// DeleteModel removes model via /api/delete.
+func (p *OllamaProvider) DeleteModel(ctx context.Context, model string) error {
+	if _, err := p.send(ctx, http.MethodDelete, "/api/delete", map[string]string{"model": model}); err != nil {
+		return fmt.Errorf("failed to delete model %s: %w", model, err)
+	}
+	return nil
+}
+
+// CopyModel copies source to destination via /api/copy.
+func (p *OllamaProvider) CopyModel(ctx context.Context, source, destination string) error {
+	if _, err := p.post(ctx, "/api/copy", map[string]string{"source": source, "destination": destination}); err != nil {
+		return fmt.Errorf("failed to copy model %s to %s: %w", source, destination, err)
+	}
+	return nil
+}
+
+// CreateModel creates req.Model via /api/create without streaming progress.
+func (p *OllamaProvider) CreateModel(ctx context.Context, req CreateModelRequest) error {
+	payload := struct {
+		CreateModelRequest
+		Stream bool `json:"stream"`
+	}{CreateModelRequest: req}
+	if _, err := p.post(ctx, "/api/create", payload); err != nil {
+		return fmt.Errorf("failed to create model %s: %w", req.Model, err)
+	}
+	return nil
+}
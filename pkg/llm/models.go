@@ -0,0 +1,100 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// installedTTL is how long the list of installed models used for routing is
+// reused before the provider is asked again.
+const installedTTL = 30 * time.Second
+
+// ModelDetails is the metadata a provider reports for a single model.
+type ModelDetails struct {
+	Name              string   `json:"name"`
+	Family            string   `json:"family,omitempty"`
+	ParameterSize     string   `json:"parameterSize,omitempty"`
+	QuantizationLevel string   `json:"quantizationLevel,omitempty"`
+	ContextLength     int      `json:"contextLength,omitempty"` // Trained context length in tokens
+	Capabilities      []string `json:"capabilities,omitempty"`
+}
+
+// ModelInspector is implemented by providers that can describe a model.
+type ModelInspector interface {
+	ShowModel(ctx context.Context, model string) (*ModelDetails, error)
+}
+
+var (
+	detailsMu sync.Mutex
+	details   = map[string]*ModelDetails{}
+	installed = map[string]installedList{} // Keyed by provider and endpoint
+)
+
+type installedList struct {
+	models   []ModelInfo
+	listedAt time.Time
+}
+
+// ShowModel returns the details p reports for model. Results are cached per
+// provider, endpoint and model, since they only change when the model is
+// replaced.
+func ShowModel(ctx context.Context, p Provider, model string) (*ModelDetails, error) {
+	inspector, ok := p.(ModelInspector)
+	if !ok {
+		return nil, fmt.Errorf("provider %s cannot describe models", p.Name())
+	}
+
+	// Recorded and replayed providers see every call
+	if _, ok := p.(interface{ bypassDetailsCache() }); ok {
+		d, err := inspector.ShowModel(ctx, model)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get details of model %s: %w", model, err)
+		}
+		return d, nil
+	}
+
+	key := providerID(p) + "|" + model
+	detailsMu.Lock()
+	cached, ok := details[key]
+	detailsMu.Unlock()
+	if ok {
+		return cached, nil
+	}
+
+	d, err := inspector.ShowModel(ctx, model)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get details of model %s: %w", model, err)
+	}
+
+	detailsMu.Lock()
+	details[key] = d
+	detailsMu.Unlock()
+	return d, nil
+}
+
+// installedModels returns the models installed on p, reusing the list for
+// installedTTL so routing every request does not list them again.
+func installedModels(ctx context.Context, p Provider) ([]ModelInfo, error) {
+	if _, ok := p.(interface{ bypassDetailsCache() }); ok {
+		return p.ListModels(ctx)
+	}
+
+	key := providerID(p)
+	detailsMu.Lock()
+	cached, ok := installed[key]
+	detailsMu.Unlock()
+	if ok && time.Since(cached.listedAt) < installedTTL {
+		return cached.models, nil
+	}
+
+	models, err := p.ListModels(ctx)
+	if err != nil {
+		return nil, err
+	}
+	detailsMu.Lock()
+	installed[key] = installedList{models: models, listedAt: time.Now()}
+	detailsMu.Unlock()
+	return models, nil
+}
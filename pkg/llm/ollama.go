@@ -0,0 +1,322 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"WSA/pkg/types"
+)
+
+// OllamaProvider talks to an Ollama server over its native /api endpoints.
+type OllamaProvider struct {
+	BaseURL string
+	Client  *http.Client
+}
+
+// NewOllamaProvider creates a provider for the Ollama server at baseURL.
+func NewOllamaProvider(baseURL string, timeout time.Duration) *OllamaProvider {
+	return &OllamaProvider{
+		BaseURL: BaseURL(baseURL),
+		Client:  &http.Client{Timeout: timeout},
+	}
+}
+
+// Name identifies the provider.
+func (p *OllamaProvider) Name() string {
+	return "ollama"
+}
+
+// Endpoint returns the base URL of the Ollama server.
+func (p *OllamaProvider) Endpoint() string {
+	return p.BaseURL
+}
+
+// SupportsFormat reports that Ollama constrains replies to the format schema.
+func (p *OllamaProvider) SupportsFormat() bool {
+	return true
+}
+
+// Chat sends a non-streaming request to /api/chat.
+func (p *OllamaProvider) Chat(ctx context.Context, req ChatRequest) (*ChatResponse, error) {
+	chatData := types.ChatData{
+		Model:    req.Model,
+		Messages: req.Messages,
+		Stream:   false,
+		Options:  req.Options,
+		Tools:    req.Tools,
+	}
+	if req.Format != nil {
+		chatData.Format = req.Format
+	}
+
+	respBody, err := p.post(ctx, "/api/chat", chatData)
+	if err != nil {
+		return nil, err
+	}
+
+	var llmResponse types.LLMResponse
+	if err := json.Unmarshal(respBody, &llmResponse); err != nil {
+		return nil, fmt.Errorf("failed to decode LLM response: %w\nResponse body: %s", err, string(respBody))
+	}
+
+	result := &ChatResponse{
+		Model:     llmResponse.Model,
+		Content:   llmResponse.Message.Content,
+		Thinking:  llmResponse.Message.Thinking,
+		ToolCalls: llmResponse.Message.ToolCalls,
+		Raw:       respBody,
+
+		PromptTokens:     llmResponse.PromptEvalCount,
+		CompletionTokens: llmResponse.EvalCount,
+	}
+	result.splitThinking()
+	return result, nil
+}
+
+// ChatStream sends a streaming request to /api/chat and reads the NDJSON
+// reply line by line, passing each delta to fn.
+func (p *OllamaProvider) ChatStream(ctx context.Context, req ChatRequest, fn StreamFunc) (*ChatResponse, error) {
+	chatData := types.ChatData{
+		Model:    req.Model,
+		Messages: req.Messages,
+		Stream:   true,
+		Options:  req.Options,
+		Tools:    req.Tools,
+	}
+	if req.Format != nil {
+		chatData.Format = req.Format
+	}
+
+	body, err := json.Marshal(chatData)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.BaseURL+"/api/chat", bytes.NewBuffer(body))
+	if err != nil {
+		return nil, fmt.Errorf("error creating LLM API request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	fmt.Printf("LLM Request Sent:\n%s\n", string(body))
+
+	resp, err := p.Client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("error making LLM API request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("LLM API returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+
+	var content, thinking strings.Builder
+	result := &ChatResponse{Model: req.Model}
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+
+		var chunk struct {
+			types.LLMResponse
+			Error string `json:"error"`
+		}
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			return nil, fmt.Errorf("failed to decode LLM stream chunk: %w\nChunk: %s", err, string(line))
+		}
+		if chunk.Error != "" {
+			return nil, fmt.Errorf("LLM stream error: %s", chunk.Error)
+		}
+
+		content.WriteString(chunk.Message.Content)
+		thinking.WriteString(chunk.Message.Thinking)
+		result.ToolCalls = append(result.ToolCalls, chunk.Message.ToolCalls...)
+		if chunk.Model != "" {
+			result.Model = chunk.Model
+		}
+		if chunk.Done {
+			result.PromptTokens = chunk.PromptEvalCount
+			result.CompletionTokens = chunk.EvalCount
+		}
+		fn(StreamChunk{
+			Content:  chunk.Message.Content,
+			Thinking: chunk.Message.Thinking,
+			Done:     chunk.Done,
+		})
+		if chunk.Done {
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading LLM stream: %w", err)
+	}
+
+	result.Content = content.String()
+	result.Thinking = thinking.String()
+	result.splitThinking()
+	fmt.Printf("LLM Response Received (streamed):\n%s\n", result.Content)
+	return result, nil
+}
+
+// Generate sends a non-streaming request to /api/generate.
+func (p *OllamaProvider) Generate(ctx context.Context, req GenerateRequest) (*GenerateResponse, error) {
+	return p.generate(ctx, req.Model, req.Prompt, nil, req.Options)
+}
+
+// Vision sends a prompt with base64 images to /api/generate.
+func (p *OllamaProvider) Vision(ctx context.Context, req VisionRequest) (*GenerateResponse, error) {
+	return p.generate(ctx, req.Model, req.Prompt, req.Images, req.Options)
+}
+
+func (p *OllamaProvider) generate(ctx context.Context, model, prompt string, images []string, options map[string]interface{}) (*GenerateResponse, error) {
+	payload := map[string]interface{}{
+		"model":  model,
+		"prompt": prompt,
+		"stream": false,
+	}
+	if len(images) > 0 {
+		payload["images"] = images
+	}
+	if len(options) > 0 {
+		payload["options"] = options
+	}
+
+	respBody, err := p.post(ctx, "/api/generate", payload)
+	if err != nil {
+		return nil, err
+	}
+
+	var ollamaResponse types.OllamaResponse
+	if err := json.Unmarshal(respBody, &ollamaResponse); err != nil {
+		return nil, fmt.Errorf("failed to decode Ollama response: %w\nResponse body: %s", err, string(respBody))
+	}
+
+	return &GenerateResponse{
+		Model:    ollamaResponse.Model,
+		Response: ollamaResponse.Response,
+		Raw:      respBody,
+	}, nil
+}
+
+// ListModels returns the models installed on the server via /api/tags.
+func (p *OllamaProvider) ListModels(ctx context.Context) ([]ModelInfo, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, p.BaseURL+"/api/tags", nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating Ollama request: %w", err)
+	}
+
+	resp, err := p.Client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Ollama: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Ollama API returned status %d", resp.StatusCode)
+	}
+
+	var tags struct {
+		Models []ModelInfo `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return nil, fmt.Errorf("failed to decode models response: %w", err)
+	}
+	return tags.Models, nil
+}
+
+// ShowModel returns the details Ollama reports for model via /api/show.
+func (p *OllamaProvider) ShowModel(ctx context.Context, model string) (*ModelDetails, error) {
+	respBody, err := p.post(ctx, "/api/show", map[string]string{"model": model})
+	if err != nil {
+		return nil, err
+	}
+
+	var show types.OllamaShowResponse
+	if err := json.Unmarshal(respBody, &show); err != nil {
+		return nil, fmt.Errorf("failed to decode model details: %w", err)
+	}
+
+	details := &ModelDetails{
+		Name:              model,
+		Family:            show.Details.Family,
+		ParameterSize:     show.Details.ParameterSize,
+		QuantizationLevel: show.Details.QuantizationLevel,
+		Capabilities:      show.Capabilities,
+	}
+	vision := false
+	for key, value := range show.ModelInfo {
+		if n, ok := value.(float64); ok && strings.HasSuffix(key, ".context_length") {
+			details.ContextLength = int(n)
+		}
+		if strings.Contains(key, ".vision.") {
+			vision = true
+		}
+	}
+
+	// Older servers do not report capabilities; infer them from the model
+	if len(details.Capabilities) == 0 {
+		details.Capabilities = []string{CapabilityCompletion}
+		for _, family := range show.Details.Families {
+			if family == "clip" || family == "mllama" {
+				vision = true
+			}
+		}
+		if vision {
+			details.Capabilities = append(details.Capabilities, CapabilityVision)
+		}
+		if strings.Contains(show.Template, ".Tools") {
+			details.Capabilities = append(details.Capabilities, CapabilityTools)
+		}
+	}
+	return details, nil
+}
+
+// post marshals payload, sends it to path and returns the raw response body.
+func (p *OllamaProvider) post(ctx context.Context, path string, payload interface{}) ([]byte, error) {
+	return p.send(ctx, http.MethodPost, path, payload)
+}
+
+// send is post with any method.
+func (p *OllamaProvider) send(ctx context.Context, method, path string, payload interface{}) ([]byte, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, method, p.BaseURL+path, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, fmt.Errorf("error creating LLM API request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	fmt.Printf("LLM Request Sent:\n%s\n", string(body))
+
+	resp, err := p.Client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("error making LLM API request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading LLM response body: %w", err)
+	}
+
+	fmt.Printf("LLM Response Received:\n%s\n", string(respBody))
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("LLM API returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	return respBody, nil
+}
@@ -0,0 +1,297 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"WSA/pkg/types"
+)
+
+// DefaultOpenAIEndpoint is the base URL of a local vLLM server.
+const DefaultOpenAIEndpoint = "http://localhost:8000/v1"
+
+// OpenAIProvider talks to any server implementing the OpenAI
+// /v1/chat/completions and /v1/models protocol, such as llama.cpp or vLLM.
+type OpenAIProvider struct {
+	BaseURL string
+	APIKey  string
+	Client  *http.Client
+	// JSONSchema sends schemas as json_schema response formats. Turn it off
+	// for servers that ignore or reject them.
+	JSONSchema bool
+}
+
+// NewOpenAIProvider creates a provider for the OpenAI-compatible server at baseURL.
+func NewOpenAIProvider(baseURL, apiKey string, timeout time.Duration) *OpenAIProvider {
+	return &OpenAIProvider{
+		BaseURL:    OpenAIBaseURL(baseURL),
+		APIKey:     apiKey,
+		Client:     &http.Client{Timeout: timeout},
+		JSONSchema: true,
+	}
+}
+
+// OpenAIBaseURL strips endpoint paths such as /chat/completions, keeping the
+// /v1 prefix, and falls back to DefaultOpenAIEndpoint.
+func OpenAIBaseURL(endpoint string) string {
+	endpoint = strings.TrimSpace(endpoint)
+	if endpoint == "" {
+		return DefaultOpenAIEndpoint
+	}
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	for _, suffix := range []string{"/chat/completions", "/completions", "/models"} {
+		endpoint = strings.TrimSuffix(endpoint, suffix)
+	}
+	return endpoint
+}
+
+// Name identifies the provider.
+func (p *OpenAIProvider) Name() string {
+	return "openai"
+}
+
+// Endpoint returns the base URL of the server.
+func (p *OpenAIProvider) Endpoint() string {
+	return p.BaseURL
+}
+
+// SupportsFormat reports whether schemas are sent as json_schema response
+// formats, which llama.cpp and vLLM honour.
+func (p *OpenAIProvider) SupportsFormat() bool {
+	return p.JSONSchema
+}
+
+// Chat sends a non-streaming request to /chat/completions.
+func (p *OpenAIProvider) Chat(ctx context.Context, req ChatRequest) (*ChatResponse, error) {
+	messages := make([]types.OpenAIMessage, 0, len(req.Messages))
+	for _, m := range req.Messages {
+		msg := types.OpenAIMessage{Role: m.Role, Content: m.Content, ToolCallID: m.ToolCallID}
+		for i, call := range m.ToolCalls {
+			args, err := json.Marshal(call.Function.Arguments)
+			if err != nil {
+				return nil, fmt.Errorf("error marshaling tool call arguments: %w", err)
+			}
+			openaiCall := types.OpenAIToolCall{ID: call.ID, Type: "function"}
+			if openaiCall.ID == "" {
+				openaiCall.ID = fmt.Sprintf("call_%d", i)
+			}
+			openaiCall.Function.Name = call.Function.Name
+			openaiCall.Function.Arguments = string(args)
+			msg.ToolCalls = append(msg.ToolCalls, openaiCall)
+		}
+		messages = append(messages, msg)
+	}
+	return p.complete(ctx, req.Model, messages, req.Options, req.Format, req.Tools)
+}
+
+// Generate sends prompt as a single user message.
+func (p *OpenAIProvider) Generate(ctx context.Context, req GenerateRequest) (*GenerateResponse, error) {
+	resp, err := p.complete(ctx, req.Model, []types.OpenAIMessage{{Role: "user", Content: req.Prompt}}, req.Options, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &GenerateResponse{Model: resp.Model, Response: resp.Content, Raw: resp.Raw}, nil
+}
+
+// Vision sends the prompt and images as content parts, encoding each image as
+// a base64 data URL.
+func (p *OpenAIProvider) Vision(ctx context.Context, req VisionRequest) (*GenerateResponse, error) {
+	parts := []types.OpenAIContentPart{{Type: "text", Text: req.Prompt}}
+	for _, img := range req.Images {
+		parts = append(parts, types.OpenAIContentPart{
+			Type:     "image_url",
+			ImageURL: &types.OpenAIImageURL{URL: imageDataURL(img)},
+		})
+	}
+
+	resp, err := p.complete(ctx, req.Model, []types.OpenAIMessage{{Role: "user", Content: parts}}, req.Options, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &GenerateResponse{Model: resp.Model, Response: resp.Content, Raw: resp.Raw}, nil
+}
+
+// ListModels returns the models served at /models.
+func (p *OpenAIProvider) ListModels(ctx context.Context) ([]ModelInfo, error) {
+	modelsResp, err := p.models(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	models := make([]ModelInfo, 0, len(modelsResp.Data))
+	for _, m := range modelsResp.Data {
+		models = append(models, ModelInfo{
+			Name:       m.ID,
+			ModifiedAt: time.Unix(m.Created, 0).UTC().Format(time.RFC3339),
+		})
+	}
+	return models, nil
+}
+
+// ShowModel returns the context length the server reports for model in its
+// /models listing. OpenAI-compatible servers report no other details.
+func (p *OpenAIProvider) ShowModel(ctx context.Context, model string) (*ModelDetails, error) {
+	modelsResp, err := p.models(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range modelsResp.Data {
+		if m.ID != model {
+			continue
+		}
+		details := &ModelDetails{Name: m.ID, ContextLength: m.MaxModelLen}
+		if details.ContextLength == 0 {
+			details.ContextLength = m.Meta.NCtxTrain
+		}
+		return details, nil
+	}
+	return nil, fmt.Errorf("model %s is not served by the OpenAI-compatible server", model)
+}
+
+func (p *OpenAIProvider) models(ctx context.Context) (*types.OpenAIModelsResponse, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, p.BaseURL+"/models", nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating models request: %w", err)
+	}
+	p.authorize(httpReq)
+
+	resp, err := p.Client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to OpenAI-compatible server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OpenAI-compatible API returned status %d", resp.StatusCode)
+	}
+
+	var modelsResp types.OpenAIModelsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&modelsResp); err != nil {
+		return nil, fmt.Errorf("failed to decode models response: %w", err)
+	}
+	return &modelsResp, nil
+}
+
+func (p *OpenAIProvider) complete(ctx context.Context, model string, messages []types.OpenAIMessage, options map[string]interface{}, format Schema, tools []types.Tool) (*ChatResponse, error) {
+	chatReq := types.OpenAIChatRequest{
+		Model:    model,
+		Messages: messages,
+		Stream:   false,
+		Tools:    tools,
+	}
+	if format != nil && p.JSONSchema {
+		chatReq.ResponseFormat = map[string]interface{}{
+			"type": "json_schema",
+			"json_schema": map[string]interface{}{
+				"name":   "response",
+				"schema": format,
+			},
+		}
+	}
+	if t, ok := options["temperature"].(float64); ok {
+		chatReq.Temperature = &t
+	}
+	if n, ok := options["num_predict"].(int); ok {
+		chatReq.MaxTokens = n
+	}
+
+	body, err := json.Marshal(chatReq)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.BaseURL+"/chat/completions", bytes.NewBuffer(body))
+	if err != nil {
+		return nil, fmt.Errorf("error creating LLM API request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	p.authorize(httpReq)
+
+	fmt.Printf("LLM Request Sent:\n%s\n", string(body))
+
+	resp, err := p.Client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("error making LLM API request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading LLM response body: %w", err)
+	}
+
+	fmt.Printf("LLM Response Received:\n%s\n", string(respBody))
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("LLM API returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+
+	var chatResp types.OpenAIChatResponse
+	if err := json.Unmarshal(respBody, &chatResp); err != nil {
+		return nil, fmt.Errorf("failed to decode LLM response: %w\nResponse body: %s", err, string(respBody))
+	}
+	if len(chatResp.Choices) == 0 {
+		return nil, fmt.Errorf("LLM response contained no choices\nResponse body: %s", string(respBody))
+	}
+
+	message := chatResp.Choices[0].Message
+	content, _ := message.Content.(string)
+	var toolCalls []types.ToolCall
+	for _, call := range message.ToolCalls {
+		var args map[string]interface{}
+		if call.Function.Arguments != "" {
+			if err := json.Unmarshal([]byte(call.Function.Arguments), &args); err != nil {
+				return nil, fmt.Errorf("failed to decode arguments of tool call %s: %w", call.Function.Name, err)
+			}
+		}
+		toolCalls = append(toolCalls, types.ToolCall{
+			ID:       call.ID,
+			Type:     call.Type,
+			Function: types.ToolCallFunction{Name: call.Function.Name, Arguments: args},
+		})
+	}
+	result := &ChatResponse{
+		Model:     chatResp.Model,
+		Content:   content,
+		Thinking:  message.ReasoningContent,
+		ToolCalls: toolCalls,
+		Raw:       respBody,
+
+		PromptTokens:     chatResp.Usage.PromptTokens,
+		CompletionTokens: chatResp.Usage.CompletionTokens,
+	}
+	result.splitThinking()
+	return result, nil
+}
+
+// authorize adds the bearer token when an API key is configured.
+func (p *OpenAIProvider) authorize(req *http.Request) {
+	if p.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.APIKey)
+	}
+}
+
+// imageDataURL wraps raw base64 image data in a data URL, sniffing the type
+// from the decoded header. Values that are already URLs pass through.
+func imageDataURL(img string) string {
+	if strings.HasPrefix(img, "data:") || strings.HasPrefix(img, "http://") || strings.HasPrefix(img, "https://") {
+		return img
+	}
+	mime := "image/png"
+	switch {
+	case strings.HasPrefix(img, "/9j/"):
+		mime = "image/jpeg"
+	case strings.HasPrefix(img, "R0lGOD"):
+		mime = "image/gif"
+	case strings.HasPrefix(img, "UklGR"):
+		mime = "image/webp"
+	}
+	return "data:" + mime + ";base64," + img
+}
@@ -0,0 +1,75 @@
+package llm
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// Options configure the model calls of a single request. They travel with
+// the request's context, so concurrent requests can use different models and
+// servers. Empty fields fall back to the process-wide defaults read from the
+// environment.
+type Options struct {
+	Provider string `json:"provider,omitempty"`
+	// Endpoint is the base URL of the server: an Ollama server, or an
+	// OpenAI-compatible one when Provider selects it.
+	Endpoint string `json:"endpoint,omitempty"`
+	APIKey   string `json:"-"`
+	// Model is tried first for every text role.
+	Model string `json:"model,omitempty"`
+	// Roles lists models tried first for a role, before Model.
+	Roles map[Role][]string `json:"roles,omitempty"`
+	// ContextWindow caps the tokens budgeted per request, like LLM_CONTEXT_WINDOW.
+	ContextWindow int `json:"contextWindow,omitempty"`
+	// Timeout bounds a single non-streaming model call, like DefaultTimeout.
+	Timeout time.Duration `json:"timeout,omitempty"`
+	// Record and Replay are set by the functions of the same name.
+	Record *Cassette `json:"-"`
+	Replay *Cassette `json:"-"`
+}
+
+type optionsKey struct{}
+
+// WithOptions returns a copy of ctx carrying opts.
+func WithOptions(ctx context.Context, opts Options) context.Context {
+	return context.WithValue(ctx, optionsKey{}, opts)
+}
+
+// OptionsFrom returns the options carried by ctx, or zero Options.
+func OptionsFrom(ctx context.Context) Options {
+	opts, _ := ctx.Value(optionsKey{}).(Options)
+	return opts
+}
+
+// For returns the provider selected by the options carried by ctx, falling
+// back to Default for anything they leave unset. Under Replay it returns a
+// provider answering from the cassette; under Record the selected provider
+// records to it.
+func For(ctx context.Context) Provider {
+	opts := OptionsFrom(ctx)
+	if opts.Replay != nil {
+		return &replayProvider{cassette: opts.Replay}
+	}
+	p := selected(opts)
+	if opts.Record != nil {
+		return withRecording(p, opts.Record)
+	}
+	return p
+}
+
+func selected(opts Options) Provider {
+	if opts.Provider == "" && opts.Endpoint == "" && opts.APIKey == "" && opts.Timeout == 0 {
+		return Default()
+	}
+
+	name := opts.Provider
+	if name == "" {
+		name = os.Getenv("LLM_PROVIDER")
+	}
+	p, err := configured(name, opts.Endpoint, opts.APIKey, opts.Timeout)
+	if err != nil {
+		p, _ = configured(ProviderOllama, opts.Endpoint, "", opts.Timeout)
+	}
+	return p
+}
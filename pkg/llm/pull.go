@@ -0,0 +1,126 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ErrDiskFull is returned when the model server runs out of disk space while
+// downloading a model.
+var ErrDiskFull = errors.New("not enough disk space for the model")
+
+// PullProgress is one progress update of a model download. Digest, Total and
+// Completed describe the layer being downloaded, if any.
+type PullProgress struct {
+	Status    string `json:"status"`
+	Digest    string `json:"digest,omitempty"`
+	Total     int64  `json:"total,omitempty"`     // Size of the layer in bytes
+	Completed int64  `json:"completed,omitempty"` // Bytes of the layer downloaded so far
+}
+
+// PullFunc receives progress updates as they arrive.
+type PullFunc func(PullProgress)
+
+// ModelPuller is implemented by providers that can download models.
+type ModelPuller interface {
+	PullModel(ctx context.Context, model string, fn PullFunc) error
+}
+
+// Pull downloads model through p, passing progress to fn. Cancelling ctx
+// stops the download. Cached details of the model are dropped once it is in
+// place.
+func Pull(ctx context.Context, p Provider, model string, fn PullFunc) error {
+	puller, ok := p.(ModelPuller)
+	if !ok {
+		return fmt.Errorf("provider %s cannot download models", p.Name())
+	}
+	if err := puller.PullModel(ctx, model, fn); err != nil {
+		return err
+	}
+	ForgetModel(model)
+	return nil
+}
+
+// PullModel downloads model via /api/pull, reading the NDJSON progress
+// stream. The request is not bound by the client timeout, since large models
+// take much longer than a chat reply; cancel ctx to stop it.
+func (p *OllamaProvider) PullModel(ctx context.Context, model string, fn PullFunc) error {
+	body, err := json.Marshal(map[string]interface{}{"model": model, "stream": true})
+	if err != nil {
+		return fmt.Errorf("error marshaling request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.BaseURL+"/api/pull", bytes.NewBuffer(body))
+	if err != nil {
+		return fmt.Errorf("error creating Ollama request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Transport: p.Client.Transport}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("failed to connect to Ollama: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return pullError(model, fmt.Sprintf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody))))
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	success := false
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var chunk struct {
+			PullProgress
+			Error string `json:"error"`
+		}
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			return fmt.Errorf("failed to decode pull progress: %w\nChunk: %s", err, string(line))
+		}
+		if chunk.Error != "" {
+			return pullError(model, chunk.Error)
+		}
+		if fn != nil {
+			fn(chunk.PullProgress)
+		}
+		if chunk.Status == "success" {
+			success = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("error reading pull progress: %w", err)
+	}
+	if !success {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("pull of %s ended before it completed", model)
+	}
+	return nil
+}
+
+// pullError describes a failed pull, recognizing a full disk.
+func pullError(model, message string) error {
+	lower := strings.ToLower(message)
+	if strings.Contains(lower, "no space left on device") || strings.Contains(lower, "disk full") ||
+		strings.Contains(lower, "not enough space") {
+		return fmt.Errorf("%w: pulling %s failed: %s", ErrDiskFull, model, message)
+	}
+	return fmt.Errorf("pulling %s failed: %s", model, message)
+}
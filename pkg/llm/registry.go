@@ -0,0 +1,141 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Capabilities reported by Ollama /api/show.
+const (
+	CapabilityCompletion = "completion"
+	CapabilityVision     = "vision"
+	CapabilityTools      = "tools"
+	CapabilityThinking   = "thinking"
+)
+
+// Models used when no model is configured.
+const (
+	DefaultChatModel   = "llama3.2"
+	DefaultVisionModel = "llava"
+)
+
+// ErrUnsupported is returned when no available model has a required capability.
+var ErrUnsupported = errors.New("model does not support the requested capability")
+
+// ModelCapabilities is the registry entry of an installed model.
+type ModelCapabilities struct {
+	Name          string `json:"name"`
+	Provider      string `json:"provider"`
+	Vision        bool   `json:"vision"`
+	Tools         bool   `json:"tools"`
+	Thinking      bool   `json:"thinking"`
+	ContextLength int    `json:"contextLength,omitempty"`
+	Quantization  string `json:"quantization,omitempty"`
+	ParameterSize string `json:"parameterSize,omitempty"`
+	Family        string `json:"family,omitempty"`
+	Size          int64  `json:"size,omitempty"`
+	ModifiedAt    string `json:"modifiedAt,omitempty"`
+	// Known is false when the provider could not describe the model, in
+	// which case the capability flags are unknown rather than false.
+	Known bool `json:"known"`
+}
+
+// Has reports whether the model has capability. Models whose capabilities
+// are unknown are assumed to have it, so unknown models are not rejected.
+func (c ModelCapabilities) Has(capability string) bool {
+	if !c.Known {
+		return true
+	}
+	switch capability {
+	case CapabilityVision:
+		return c.Vision
+	case CapabilityTools:
+		return c.Tools
+	case CapabilityThinking:
+		return c.Thinking
+	}
+	return true
+}
+
+// Registry lists the models installed on p with their capabilities. Details
+// come from ShowModel and are cached per model.
+func Registry(ctx context.Context, p Provider) ([]ModelCapabilities, error) {
+	models, err := p.ListModels(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	registry := make([]ModelCapabilities, 0, len(models))
+	for _, m := range models {
+		c := capabilitiesOf(ctx, p, m.Name)
+		c.Size = m.Size
+		c.ModifiedAt = m.ModifiedAt
+		registry = append(registry, c)
+	}
+	return registry, nil
+}
+
+// Capabilities returns the registry entry of a single model.
+func Capabilities(ctx context.Context, p Provider, model string) ModelCapabilities {
+	return capabilitiesOf(ctx, p, model)
+}
+
+func capabilitiesOf(ctx context.Context, p Provider, model string) ModelCapabilities {
+	c := ModelCapabilities{Name: model, Provider: p.Name()}
+	d, err := ShowModel(ctx, p, model)
+	if err != nil {
+		return c
+	}
+
+	c.Known = len(d.Capabilities) > 0
+	for _, capability := range d.Capabilities {
+		switch capability {
+		case CapabilityVision:
+			c.Vision = true
+		case CapabilityTools:
+			c.Tools = true
+		case CapabilityThinking:
+			c.Thinking = true
+		}
+	}
+	c.ContextLength = d.ContextLength
+	c.Quantization = d.QuantizationLevel
+	c.ParameterSize = d.ParameterSize
+	c.Family = d.Family
+	return c
+}
+
+// ResolveModel returns model if it has capability. Otherwise it reroutes to
+// the first installed model that has it, or fails with ErrUnsupported. The
+// returned bool reports whether the request was rerouted.
+func ResolveModel(ctx context.Context, p Provider, model, capability string) (string, bool, error) {
+	if Capabilities(ctx, p, model).Has(capability) {
+		return model, false, nil
+	}
+
+	registry, err := Registry(ctx, p)
+	if err == nil {
+		for _, c := range registry {
+			if c.Known && c.Has(capability) {
+				return c.Name, true, nil
+			}
+		}
+	}
+	return "", false, fmt.Errorf("%w: %s has no %s support and no installed model does", ErrUnsupported, model, capability)
+}
+
+// ForgetModel drops the cached details of model on every provider, e.g.
+// after it was pulled again or deleted, along with the cached lists of
+// installed models.
+func ForgetModel(model string) {
+	detailsMu.Lock()
+	defer detailsMu.Unlock()
+	installed = map[string]installedList{}
+	for key := range details {
+		if strings.HasSuffix(key, "|"+model) {
+			delete(details, key)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LoadedModel is a model held in memory by the server.
+type LoadedModel struct {
+	Name          string    `json:"name"`
+	Digest        string    `json:"digest,omitempty"`
+	Size          int64     `json:"size"`     // Bytes of memory in use, VRAM included
+	SizeVRAM      int64     `json:"sizeVram"` // Bytes of Size held in GPU memory
+	ContextLength int       `json:"contextLength,omitempty"`
+	ExpiresAt     time.Time `json:"expiresAt"` // When the server unloads it unless it is used again
+}
+
+// SizeRAM is the bytes of the model held in system memory.
+func (m LoadedModel) SizeRAM() int64 {
+	return m.Size - m.SizeVRAM
+}
+
+// ModelLoader is implemented by providers that control which models stay in
+// memory.
+type ModelLoader interface {
+	// LoadModel loads model and keeps it in memory for keepAlive: a Go
+	// duration such as "30m", a number of seconds, or "-1" to keep it until
+	// unloaded. An empty keepAlive uses the server's default.
+	LoadModel(ctx context.Context, model, keepAlive string) error
+	UnloadModel(ctx context.Context, model string) error
+	LoadedModels(ctx context.Context) ([]LoadedModel, error)
+}
+
+// Load loads model through p. See ModelLoader.
+func Load(ctx context.Context, p Provider, model, keepAlive string) error {
+	loader, ok := p.(ModelLoader)
+	if !ok {
+		return fmt.Errorf("provider %s cannot load models", p.Name())
+	}
+	if _, err := keepAliveValue(keepAlive); err != nil {
+		return err
+	}
+	return loader.LoadModel(ctx, model, keepAlive)
+}
+
+// Unload frees the memory p holds for model.
+func Unload(ctx context.Context, p Provider, model string) error {
+	loader, ok := p.(ModelLoader)
+	if !ok {
+		return fmt.Errorf("provider %s cannot unload models", p.Name())
+	}
+	return loader.UnloadModel(ctx, model)
+}
+
+// Loaded returns the models p holds in memory.
+func Loaded(ctx context.Context, p Provider) ([]LoadedModel, error) {
+	loader, ok := p.(ModelLoader)
+	if !ok {
+		return nil, fmt.Errorf("provider %s cannot report loaded models", p.Name())
+	}
+	return loader.LoadedModels(ctx)
+}
+
+// keepAliveValue converts keepAlive to the JSON value Ollama expects: a
+// number of seconds or a duration string.
+func keepAliveValue(keepAlive string) (interface{}, error) {
+	keepAlive = strings.TrimSpace(keepAlive)
+	if keepAlive == "" {
+		return nil, nil
+	}
+	if seconds, err := strconv.Atoi(keepAlive); err == nil {
+		return seconds, nil
+	}
+	if _, err := time.ParseDuration(keepAlive); err != nil {
+		return nil, fmt.Errorf("invalid keep-alive %q: use a duration such as 30m, seconds, or -1", keepAlive)
+	}
+	return keepAlive, nil
+}
+
+// LoadModel sends an empty /api/generate request, which loads model without
+// generating anything.
+func (p *OllamaProvider) LoadModel(ctx context.Context, model, keepAlive string) error {
+	payload := map[string]interface{}{"model": model}
+	value, err := keepAliveValue(keepAlive)
+	if err != nil {
+		return err
+	}
+	if value != nil {
+		payload["keep_alive"] = value
+	}
+	if _, err := p.post(ctx, "/api/generate", payload); err != nil {
+		return fmt.Errorf("failed to load model %s: %w", model, err)
+	}
+	return nil
+}
+
+// UnloadModel sends an empty /api/generate request with a keep_alive of 0.
+func (p *OllamaProvider) UnloadModel(ctx context.Context, model string) error {
+	if _, err := p.post(ctx, "/api/generate", map[string]interface{}{"model": model, "keep_alive": 0}); err != nil {
+		return fmt.Errorf("failed to unload model %s: %w", model, err)
+	}
+	return nil
+}
+
+// LoadedModels returns the models in memory via /api/ps.
+func (p *OllamaProvider) LoadedModels(ctx context.Context) ([]LoadedModel, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, p.BaseURL+"/api/ps", nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating Ollama request: %w", err)
+	}
+
+	resp, err := p.Client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Ollama: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Ollama API returned status %d", resp.StatusCode)
+	}
+
+	var ps struct {
+		Models []struct {
+			Name          string    `json:"name"`
+			Digest        string    `json:"digest"`
+			Size          int64     `json:"size"`
+			SizeVRAM      int64     `json:"size_vram"`
+			ContextLength int       `json:"context_length"`
+			ExpiresAt     time.Time `json:"expires_at"`
+		} `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&ps); err != nil {
+		return nil, fmt.Errorf("failed to decode loaded models response: %w", err)
+	}
+
+	models := make([]LoadedModel, 0, len(ps.Models))
+	for _, m := range ps.Models {
+		models = append(models, LoadedModel(m))
+	}
+	return models, nil
+}
@@ -0,0 +1,121 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Role is a job a model does for the assistant. Each role has its own chain
+// of models so, for example, a reasoning model can plan while a smaller model
+// writes commands.
+type Role string
+
+const (
+	RolePlanner   Role = "planner"   // Breaks goals down into tasks
+	RoleCommander Role = "commander" // Writes shell commands or tool calls for a task
+	RoleVision    Role = "vision"    // Looks at screenshots
+	RoleVerifier  Role = "verifier"  // Checks whether a task achieved its goal
+)
+
+// Roles lists every role.
+var Roles = []Role{RolePlanner, RoleCommander, RoleVision, RoleVerifier}
+
+// RoleEnv returns the environment variable holding the comma-separated model
+// chain of role, e.g. LLM_PLANNER_MODEL.
+func RoleEnv(role Role) string {
+	return "LLM_" + strings.ToUpper(string(role)) + "_MODEL"
+}
+
+// RoleModels returns the model chain for role in fallback order: the models
+// the options carried by ctx name for role and, for text roles, their Model;
+// then the models in the role's RoleEnv variable; then LLM_MODEL for text
+// roles. The built-in default is used only when none of these name a model.
+func RoleModels(ctx context.Context, role Role) []string {
+	opts := OptionsFrom(ctx)
+	var chain []string
+	for _, model := range opts.Roles[role] {
+		chain = appendModel(chain, model)
+	}
+	if role != RoleVision {
+		chain = appendModel(chain, opts.Model)
+	}
+	for _, model := range strings.Split(os.Getenv(RoleEnv(role)), ",") {
+		chain = appendModel(chain, model)
+	}
+
+	if role != RoleVision {
+		chain = appendModel(chain, os.Getenv("LLM_MODEL"))
+	}
+	if len(chain) > 0 {
+		return chain
+	}
+	if role == RoleVision {
+		return []string{DefaultVisionModel}
+	}
+	return []string{DefaultChatModel}
+}
+
+func appendModel(chain []string, model string) []string {
+	model = strings.TrimSpace(model)
+	if model == "" {
+		return chain
+	}
+	for _, m := range chain {
+		if m == model {
+			return chain
+		}
+	}
+	return append(chain, model)
+}
+
+// FallbackFunc is told when a model of a chain is skipped and why.
+type FallbackFunc func(model string, err error)
+
+// WithFallback calls fn with each model of chain that is installed on p, in
+// order, until fn succeeds. Missing models are skipped; if none are installed,
+// or the installed models cannot be listed, every model is tried. The list of
+// installed models is cached briefly. Each skipped or failed model is passed
+// to onFallback if set. The error of the last attempt is returned when every
+// model fails.
+func WithFallback(ctx context.Context, p Provider, chain []string, onFallback FallbackFunc, fn func(model string) error) error {
+	if len(chain) == 0 {
+		return fmt.Errorf("no model configured")
+	}
+
+	candidates := chain
+	if installed, err := installedModels(ctx, p); err == nil {
+		var available []string
+		for _, model := range chain {
+			if isListed(installed, model) {
+				available = append(available, model)
+			} else if onFallback != nil {
+				onFallback(model, fmt.Errorf("model %s is not installed", model))
+			}
+		}
+		if len(available) > 0 {
+			candidates = available
+		}
+	}
+
+	var err error
+	for i, model := range candidates {
+		if err = fn(model); err == nil {
+			return nil
+		}
+		if onFallback != nil && i < len(candidates)-1 {
+			onFallback(model, err)
+		}
+	}
+	return err
+}
+
+func isListed(installed []ModelInfo, model string) bool {
+	for _, m := range installed {
+		if m.Name == model || m.Name == model+":latest" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,202 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Schema is a JSON Schema document, sent as Ollama's format parameter or an
+// OpenAI json_schema response format.
+type Schema map[string]interface{}
+
+// FormatSupporter is implemented by providers that can constrain their output
+// to a JSON schema. Providers without it get the schema only in the prompt.
+type FormatSupporter interface {
+	SupportsFormat() bool
+}
+
+// SupportsFormat reports whether p constrains output to ChatRequest.Format.
+func SupportsFormat(p Provider) bool {
+	f, ok := p.(FormatSupporter)
+	return ok && f.SupportsFormat()
+}
+
+// SchemaFor builds a JSON schema from the json tags of v's type. Fields
+// without omitempty are required, except those whose zero value is a usable
+// answer: booleans, numbers and lists. Models are asked not to add unknown
+// properties, but Validate ignores them.
+func SchemaFor(v interface{}) Schema {
+	return schemaForType(reflect.TypeOf(v))
+}
+
+func schemaForType(t reflect.Type) Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return Schema{"type": "string"}
+	case reflect.Bool:
+		return Schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Schema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return Schema{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return Schema{"type": "array", "items": schemaForType(t.Elem())}
+	case reflect.Map:
+		return Schema{"type": "object", "additionalProperties": schemaForType(t.Elem())}
+	case reflect.Struct:
+		properties := Schema{}
+		required := []string{}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			properties[name] = schemaForType(field.Type)
+			if !strings.Contains(opts, "omitempty") && !hasUsableZero(field.Type) {
+				required = append(required, name)
+			}
+		}
+		return Schema{
+			"type":                 "object",
+			"properties":           properties,
+			"required":             required,
+			"additionalProperties": false,
+		}
+	default:
+		return Schema{}
+	}
+}
+
+// hasUsableZero reports whether a missing value of type t can stand for its
+// zero value, so a reply leaving out visionNeeded means false rather than
+// being rejected.
+func hasUsableZero(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Bool, reflect.Slice, reflect.Array, reflect.Map,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// DecodeWithSchema validates data against schema and then decodes it into v.
+func DecodeWithSchema(data []byte, schema Schema, v interface{}) error {
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	if err := Validate(doc, schema); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Validate checks a decoded JSON value against the subset of JSON Schema
+// produced by SchemaFor: type, properties, required, items and
+// additionalProperties schemas. Properties the schema does not know are
+// ignored, as decoding drops them anyway.
+func Validate(doc interface{}, schema Schema) error {
+	return validate(doc, schema, "$")
+}
+
+func validate(doc interface{}, schema Schema, path string) error {
+	switch schema["type"] {
+	case "string":
+		if _, ok := doc.(string); !ok {
+			return fmt.Errorf("%s: expected string, got %s", path, jsonType(doc))
+		}
+	case "boolean":
+		if _, ok := doc.(bool); !ok {
+			return fmt.Errorf("%s: expected boolean, got %s", path, jsonType(doc))
+		}
+	case "integer":
+		n, ok := doc.(float64)
+		if !ok || n != float64(int64(n)) {
+			return fmt.Errorf("%s: expected integer, got %s", path, jsonType(doc))
+		}
+	case "number":
+		if _, ok := doc.(float64); !ok {
+			return fmt.Errorf("%s: expected number, got %s", path, jsonType(doc))
+		}
+	case "array":
+		items, ok := doc.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected array, got %s", path, jsonType(doc))
+		}
+		if itemSchema, ok := schema["items"].(Schema); ok {
+			for i, item := range items {
+				if err := validate(item, itemSchema, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case "object":
+		obj, ok := doc.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected object, got %s", path, jsonType(doc))
+		}
+		if required, ok := schema["required"].([]string); ok {
+			for _, name := range required {
+				if _, ok := obj[name]; !ok {
+					return fmt.Errorf("%s: missing required property %q", path, name)
+				}
+			}
+		}
+		properties, _ := schema["properties"].(Schema)
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if propSchema, ok := properties[k].(Schema); ok {
+				if err := validate(obj[k], propSchema, path+"."+k); err != nil {
+					return err
+				}
+				continue
+			}
+			if extra, ok := schema["additionalProperties"].(Schema); ok {
+				if err := validate(obj[k], extra, path+"."+k); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func jsonType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
@@ -0,0 +1,106 @@
+package llm
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+type schemaStep struct {
+	Command string `json:"command"`
+	Done    bool   `json:"done"`
+}
+
+type schemaReply struct {
+	Name   string         `json:"name"`
+	Note   string         `json:"note,omitempty"`
+	Count  int            `json:"count"`
+	Ratio  float64        `json:"ratio"`
+	Flag   bool           `json:"flag"`
+	Tags   []string       `json:"tags"`
+	Steps  [][]schemaStep `json:"steps"`
+	Labels map[string]int `json:"labels"`
+	Skip   string         `json:"-"`
+}
+
+func TestSchemaForRequired(t *testing.T) {
+	schema := SchemaFor(schemaReply{})
+	required, _ := schema["required"].([]string)
+	if strings.Join(required, ",") != "name" {
+		t.Errorf("required = %v, want [name]", required)
+	}
+	properties := schema["properties"].(Schema)
+	if _, ok := properties["Skip"]; ok {
+		t.Error("fields tagged json:\"-\" must not be in the schema")
+	}
+	if _, ok := properties["note"]; !ok {
+		t.Error("omitempty fields must still be in the schema")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	schema := SchemaFor(schemaReply{})
+	tests := []struct {
+		name    string
+		doc     string
+		wantErr string // Substring of the error; empty if the document is valid
+	}{
+		{name: "complete", doc: `{"name": "a", "note": "n", "count": 1, "ratio": 0.5, "flag": true, "tags": ["x"], "steps": [[{"command": "ls", "done": true}]], "labels": {"a": 1}}`},
+		{name: "only required fields", doc: `{"name": "a"}`},
+		{name: "extra keys are ignored", doc: `{"name": "a", "extra": {"deep": [1]}, "steps": [[{"command": "ls", "other": 1}]]}`},
+		{name: "missing required field", doc: `{"count": 1}`, wantErr: `$: missing required property "name"`},
+		{name: "missing required nested field", doc: `{"name": "a", "steps": [[{"done": true}]]}`, wantErr: `$.steps[0][0]: missing required property "command"`},
+		{name: "string for integer", doc: `{"name": "a", "count": "1"}`, wantErr: "$.count: expected integer, got string"},
+		{name: "fraction for integer", doc: `{"name": "a", "count": 1.5}`, wantErr: "$.count: expected integer, got number"},
+		{name: "integer for number", doc: `{"name": "a", "ratio": 2}`},
+		{name: "string for boolean", doc: `{"name": "a", "flag": "true"}`, wantErr: "$.flag: expected boolean, got string"},
+		{name: "null for string", doc: `{"name": null}`, wantErr: "$.name: expected string, got null"},
+		{name: "object for array", doc: `{"name": "a", "tags": {}}`, wantErr: "$.tags: expected array, got object"},
+		{name: "wrong item type", doc: `{"name": "a", "tags": ["x", 2]}`, wantErr: "$.tags[1]: expected string, got number"},
+		{name: "flat array for nested array", doc: `{"name": "a", "steps": [{"command": "ls"}]}`, wantErr: "$.steps[0]: expected array, got object"},
+		{name: "wrong nested item type", doc: `{"name": "a", "steps": [[{"command": "ls", "done": 1}]]}`, wantErr: "$.steps[0][0].done: expected boolean, got number"},
+		{name: "wrong map value type", doc: `{"name": "a", "labels": {"a": "1"}}`, wantErr: "$.labels.a: expected integer, got string"},
+		{name: "array at the top", doc: `[{"name": "a"}]`, wantErr: "$: expected object, got array"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var doc interface{}
+			if err := json.Unmarshal([]byte(tt.doc), &doc); err != nil {
+				t.Fatalf("invalid test document: %v", err)
+			}
+			err := Validate(doc, schema)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("Validate(%s) failed: %v", tt.doc, err)
+			case tt.wantErr != "" && err == nil:
+				t.Errorf("Validate(%s) succeeded, want error %q", tt.doc, tt.wantErr)
+			case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+				t.Errorf("Validate(%s) = %v, want error %q", tt.doc, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDecodeWithSchema(t *testing.T) {
+	schema := SchemaFor([]schemaStep{})
+
+	var steps []schemaStep
+	if err := DecodeWithSchema([]byte(`[{"command": "ls", "done": true}, {"command": "pwd"}]`), schema, &steps); err != nil {
+		t.Fatalf("DecodeWithSchema failed: %v", err)
+	}
+	if len(steps) != 2 || steps[0] != (schemaStep{"ls", true}) || steps[1] != (schemaStep{"pwd", false}) {
+		t.Errorf("decoded %+v", steps)
+	}
+
+	steps = nil
+	if err := DecodeWithSchema([]byte(`[{"done": true}]`), schema, &steps); err == nil {
+		t.Error("DecodeWithSchema accepted a step without a command")
+	}
+	if steps != nil {
+		t.Errorf("DecodeWithSchema decoded %+v from an invalid document", steps)
+	}
+	if err := DecodeWithSchema([]byte(`[{"command": "ls"`), schema, &steps); err == nil {
+		t.Error("DecodeWithSchema accepted invalid JSON")
+	}
+}
@@ -0,0 +1,38 @@
+package llm
+
+import (
+	"context"
+)
+
+// StreamChunk is an incremental piece of a streamed chat response.
+type StreamChunk struct {
+	Content  string
+	Thinking string
+	Done     bool
+}
+
+// StreamFunc receives chunks as they arrive.
+type StreamFunc func(StreamChunk)
+
+// Streamer is implemented by providers that can stream chat responses.
+// The returned ChatResponse holds the fully accumulated message.
+type Streamer interface {
+	ChatStream(ctx context.Context, req ChatRequest, fn StreamFunc) (*ChatResponse, error)
+}
+
+// ChatStream streams req through p when it supports streaming. Otherwise it
+// falls back to a single Chat call delivered to fn as one chunk.
+func ChatStream(ctx context.Context, p Provider, req ChatRequest, fn StreamFunc) (*ChatResponse, error) {
+	if s, ok := p.(Streamer); ok && fn != nil {
+		return s.ChatStream(ctx, req, fn)
+	}
+
+	resp, err := p.Chat(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if fn != nil {
+		fn(StreamChunk{Content: resp.Content, Thinking: resp.Thinking, Done: true})
+	}
+	return resp, nil
+}
@@ -0,0 +1,429 @@
+package logging
+
+import (
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "time"
+
+    "WSA/pkg/goalengine"
+    "WSA/pkg/types"
+)
+
+// ErrGoalNotFound is returned by LoadGoal for an id no goal is stored under.
+var ErrGoalNotFound = errors.New("goal not found")
+
+// StoredGoal is a goal read back from the goal store with the request that
+// started it and its chat history.
+type StoredGoal struct {
+    Goal        *goalengine.Goal
+    Request     json.RawMessage
+    ChatHistory []types.PromptMessage
+}
+
+// GoalSummary describes a stored goal for the /goals endpoint.
+type GoalSummary struct {
+    ID          int64     `json:"id"`
+    Description string    `json:"description"`
+    Status      string    `json:"status"`
+    Tasks       int       `json:"tasks"`     // Tasks of the current plan
+    Completed   int       `json:"completed"` // Completed tasks of the current plan
+    CreatedAt   time.Time `json:"createdAt"`
+    UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// createGoalTables creates the goal store: goals with their plans, the
+// attempts of their tasks, the commands those ran and the chat history. The
+// tasks themselves are rows of the tasks table.
+func createGoalTables() {
+    statements := []string{
+        `CREATE TABLE IF NOT EXISTS goals (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            description TEXT,
+            status TEXT,
+            request TEXT,  -- JSON of the request that started the goal
+            logs TEXT,     -- JSON array
+            created_at INTEGER, -- Unix seconds
+            updated_at INTEGER
+        );`,
+        `CREATE TABLE IF NOT EXISTS plans (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            goal_id INTEGER,
+            position INTEGER, -- 0 for the first plan of the goal
+            reason TEXT,
+            thinking TEXT,
+            prompt_version TEXT
+        );`,
+        `CREATE TABLE IF NOT EXISTS attempts (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            goal_id INTEGER,
+            task_id INTEGER,
+            attempt INTEGER,
+            status TEXT,
+            feedback TEXT,
+            commands TEXT, -- JSON array
+            thinking TEXT,
+            prompt_version TEXT,
+            samples INTEGER,
+            disagreement REAL,
+            timestamp DATETIME DEFAULT CURRENT_TIMESTAMP
+        );`,
+        `CREATE TABLE IF NOT EXISTS command_results (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            goal_id INTEGER,
+            task_id INTEGER,
+            attempt INTEGER,
+            command TEXT,
+            output TEXT,
+            error TEXT,
+            timestamp DATETIME DEFAULT CURRENT_TIMESTAMP
+        );`,
+        `CREATE TABLE IF NOT EXISTS chat_messages (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            goal_id INTEGER,
+            role TEXT,
+            content TEXT,
+            timestamp DATETIME DEFAULT CURRENT_TIMESTAMP
+        );`,
+    }
+    for _, statement := range statements {
+        if _, err := db.Exec(statement); err != nil {
+            log.Fatalf("Failed to create goal store tables: %v", err)
+        }
+    }
+
+    // Tasks of stored goals; rows logged by earlier versions have no goal
+    addColumnIfMissing("tasks", "goal_id", "INTEGER")
+    addColumnIfMissing("tasks", "plan", "INTEGER")     // Position of the plan that added the task
+    addColumnIfMissing("tasks", "position", "INTEGER") // Index in the goal's current tasks; -1 once replanned away
+    addColumnIfMissing("tasks", "attempt", "INTEGER")
+    addColumnIfMissing("tasks", "max_retries", "INTEGER")
+    addColumnIfMissing("tasks", "commands", "TEXT")
+    addColumnIfMissing("tasks", "depends_on", "TEXT")
+    addColumnIfMissing("tasks", "gui", "INTEGER")
+    addColumnIfMissing("tasks", "postconditions", "TEXT")
+}
+
+// CreateGoal stores a new goal with the request that started it, along with
+// its plans and tasks, and sets the IDs of the goal and its tasks.
+func CreateGoal(goal *goalengine.Goal, request []byte) error {
+    if db == nil {
+        return fmt.Errorf("database is not open")
+    }
+
+    now := time.Now().Unix()
+    result, err := db.Exec(`INSERT INTO goals (description, status, request, logs, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)`,
+        goal.Description, goal.Status.String(), string(request), "[]", now, now)
+    if err != nil {
+        return fmt.Errorf("failed to store goal: %w", err)
+    }
+    if goal.ID, err = result.LastInsertId(); err != nil {
+        return fmt.Errorf("failed to store goal: %w", err)
+    }
+    return SaveGoal(goal)
+}
+
+// SaveGoal updates the stored status, logs, plans and tasks of goal, storing
+// the plans and tasks added since it was last saved. It must not be called
+// while tasks of the goal are running.
+func SaveGoal(goal *goalengine.Goal) error {
+    if db == nil {
+        return fmt.Errorf("database is not open")
+    }
+    if goal.ID == 0 {
+        return fmt.Errorf("goal '%s' is not stored", goal.Description)
+    }
+
+    tx, err := db.Begin()
+    if err != nil {
+        return fmt.Errorf("failed to save goal: %w", err)
+    }
+    defer tx.Rollback()
+
+    logs, _ := json.Marshal(goal.Logs)
+    if _, err := tx.Exec(`UPDATE goals SET status = ?, logs = ?, updated_at = ? WHERE id = ?`,
+        goal.Status.String(), string(logs), time.Now().Unix(), goal.ID); err != nil {
+        return fmt.Errorf("failed to save goal: %w", err)
+    }
+
+    var storedPlans int
+    if err := tx.QueryRow(`SELECT COUNT(*) FROM plans WHERE goal_id = ?`, goal.ID).Scan(&storedPlans); err != nil {
+        return fmt.Errorf("failed to save goal plans: %w", err)
+    }
+    positions := map[*goalengine.Task]int{}
+    for i, task := range goal.Tasks {
+        positions[task] = i
+    }
+    for p, plan := range goal.Plans {
+        if p >= storedPlans {
+            if _, err := tx.Exec(`INSERT INTO plans (goal_id, position, reason, thinking, prompt_version) VALUES (?, ?, ?, ?, ?)`,
+                goal.ID, p, plan.Reason, plan.Thinking, plan.PromptVersion); err != nil {
+                return fmt.Errorf("failed to save goal plans: %w", err)
+            }
+        }
+        for _, task := range plan.Tasks {
+            position, current := positions[task]
+            if !current {
+                position = -1
+            }
+            if err := saveTask(tx, goal, task, p, position); err != nil {
+                return err
+            }
+        }
+    }
+    return tx.Commit()
+}
+
+// saveTask inserts or updates the row of a task of a stored goal.
+func saveTask(tx *sql.Tx, goal *goalengine.Goal, task *goalengine.Task, plan, position int) error {
+    commands, _ := json.Marshal(task.Commands)
+    dependsOn, _ := json.Marshal(task.DependsOn)
+    postconditions, _ := json.Marshal(task.Postconditions)
+    if task.ID == 0 {
+        result, err := tx.Exec(`INSERT INTO tasks (goal_id, plan, position, description, status, feedback, thinking, plan_thinking, prompt_version, plan_prompt_version,
+            samples, disagreement, attempt, max_retries, commands, depends_on, gui, postconditions) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+            goal.ID, plan, position, task.Description, task.Status.String(), task.Feedback, task.Thinking, goal.PlanThinking, task.PromptVersion, goal.PlanPromptVersion,
+            task.Samples, task.Disagreement, task.Attempt, task.MaxRetries, string(commands), string(dependsOn), task.GUI, string(postconditions))
+        if err != nil {
+            return fmt.Errorf("failed to save task '%s': %w", task.Description, err)
+        }
+        task.ID, err = result.LastInsertId()
+        return err
+    }
+
+    _, err := tx.Exec(`UPDATE tasks SET position = ?, status = ?, feedback = ?, attempt = ?, max_retries = ?, commands = ?, depends_on = ?, gui = ? WHERE id = ?`,
+        position, task.Status.String(), task.Feedback, task.Attempt, task.MaxRetries, string(commands), string(dependsOn), task.GUI, task.ID)
+    if err != nil {
+        return fmt.Errorf("failed to save task '%s': %w", task.Description, err)
+    }
+    return nil
+}
+
+// logAttempt updates the stored task after an attempt and records the
+// attempt.
+func logAttempt(goal *goalengine.Goal, task *goalengine.Task) {
+    commands, _ := json.Marshal(task.Commands)
+    _, err := db.Exec(`UPDATE tasks SET status = ?, feedback = ?, thinking = ?, prompt_version = ?, samples = ?, disagreement = ?, attempt = ?, commands = ?, gui = ? WHERE id = ?`,
+        task.Status.String(), task.Feedback, task.Thinking, task.PromptVersion, task.Samples, task.Disagreement, task.Attempt, string(commands), task.GUI, task.ID)
+    if err != nil {
+        log.Printf("Failed to log task execution: %v", err)
+    }
+
+    _, err = db.Exec(`INSERT INTO attempts (goal_id, task_id, attempt, status, feedback, commands, thinking, prompt_version, samples, disagreement) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+        goal.ID, task.ID, task.Attempt, task.Status.String(), task.Feedback, string(commands), task.Thinking, task.PromptVersion, task.Samples, task.Disagreement)
+    if err != nil {
+        log.Printf("Failed to log task attempt: %v", err)
+    }
+}
+
+// AppendGoalLog adds msg to the stored logs of goal as soon as it is logged,
+// so they are kept if the backend stops before the goal is next saved.
+func AppendGoalLog(goal *goalengine.Goal, msg string) {
+    if db == nil || goal.ID == 0 {
+        return
+    }
+
+    _, err := db.Exec(`UPDATE goals SET logs = json_insert(logs, '$[#]', ?), updated_at = ? WHERE id = ?`,
+        msg, time.Now().Unix(), goal.ID)
+    if err != nil {
+        log.Printf("Failed to log goal message: %v", err)
+    }
+}
+
+// LogCommandResult records a command run by the current attempt of a task of
+// a stored goal, with its output and error if it failed.
+func LogCommandResult(goal *goalengine.Goal, task *goalengine.Task, command, output string, cmdErr error) {
+    if db == nil || goal.ID == 0 {
+        return
+    }
+
+    errorText := ""
+    if cmdErr != nil {
+        errorText = cmdErr.Error()
+    }
+    _, err := db.Exec(`INSERT INTO command_results (goal_id, task_id, attempt, command, output, error) VALUES (?, ?, ?, ?, ?, ?)`,
+        goal.ID, task.ID, task.Attempt, command, output, errorText)
+    if err != nil {
+        log.Printf("Failed to log command result: %v", err)
+    }
+}
+
+// LogChatMessages appends messages to the stored chat history of goal.
+func LogChatMessages(goal *goalengine.Goal, messages []types.PromptMessage) {
+    if db == nil || goal.ID == 0 {
+        return
+    }
+
+    for _, message := range messages {
+        if _, err := db.Exec(`INSERT INTO chat_messages (goal_id, role, content) VALUES (?, ?, ?)`,
+            goal.ID, message.Role, message.Content); err != nil {
+            log.Printf("Failed to log chat message: %v", err)
+            return
+        }
+    }
+}
+
+// MarkInterruptedGoals marks the stored goals that were pending or running
+// when the backend last stopped as Interrupted, and returns how many there
+// were. Call it once at startup, before any goal runs.
+func MarkInterruptedGoals() (int64, error) {
+    if db == nil {
+        return 0, fmt.Errorf("database is not open")
+    }
+
+    result, err := db.Exec(`UPDATE goals SET status = ?, updated_at = ? WHERE status IN (?, ?)`,
+        goalengine.GoalInterrupted.String(), time.Now().Unix(), goalengine.GoalPending.String(), goalengine.GoalRunning.String())
+    if err != nil {
+        return 0, fmt.Errorf("failed to mark interrupted goals: %w", err)
+    }
+    return result.RowsAffected()
+}
+
+// ListGoals lists the stored goals, most recently updated first, keeping
+// only those with the given status if it is not empty.
+func ListGoals(status string) ([]GoalSummary, error) {
+    if db == nil {
+        return nil, fmt.Errorf("database is not open")
+    }
+
+    rows, err := db.Query(`SELECT g.id, g.description, g.status, g.created_at, g.updated_at,
+            (SELECT COUNT(*) FROM tasks t WHERE t.goal_id = g.id AND t.position >= 0),
+            (SELECT COUNT(*) FROM tasks t WHERE t.goal_id = g.id AND t.position >= 0 AND t.status = ?)
+        FROM goals g WHERE ? = '' OR g.status = ? ORDER BY g.updated_at DESC, g.id DESC`,
+        goalengine.Completed.String(), status, status)
+    if err != nil {
+        return nil, fmt.Errorf("failed to query goals: %w", err)
+    }
+    defer rows.Close()
+
+    goals := []GoalSummary{}
+    for rows.Next() {
+        var goal GoalSummary
+        var createdAt, updatedAt int64
+        if err := rows.Scan(&goal.ID, &goal.Description, &goal.Status, &createdAt, &updatedAt, &goal.Tasks, &goal.Completed); err != nil {
+            return nil, fmt.Errorf("failed to read goal: %w", err)
+        }
+        goal.CreatedAt = time.Unix(createdAt, 0)
+        goal.UpdatedAt = time.Unix(updatedAt, 0)
+        goals = append(goals, goal)
+    }
+    return goals, rows.Err()
+}
+
+// LoadGoal reads back the stored goal id with its plans, tasks, logs and
+// chat history. Only what the goal store keeps is filled in; options such
+// as AgentMode come from the returned request.
+func LoadGoal(id int64) (*StoredGoal, error) {
+    if db == nil {
+        return nil, fmt.Errorf("database is not open")
+    }
+
+    stored := &StoredGoal{Goal: &goalengine.Goal{ID: id, CurrentState: &goalengine.State{}, DesiredState: &goalengine.State{}}}
+    goal := stored.Goal
+    var status, request, logs string
+    err := db.QueryRow(`SELECT description, status, request, logs FROM goals WHERE id = ?`, id).Scan(&goal.Description, &status, &request, &logs)
+    if err == sql.ErrNoRows {
+        return nil, fmt.Errorf("%w: id %d", ErrGoalNotFound, id)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to load goal %d: %w", id, err)
+    }
+    if goal.Status, err = goalengine.ParseGoalStatus(status); err != nil {
+        return nil, fmt.Errorf("failed to load goal %d: %w", id, err)
+    }
+    stored.Request = json.RawMessage(request)
+    if err := json.Unmarshal([]byte(logs), &goal.Logs); err != nil || goal.Logs == nil {
+        goal.Logs = []string{}
+    }
+
+    if err := loadPlans(goal); err != nil {
+        return nil, err
+    }
+    if err := loadTasks(goal); err != nil {
+        return nil, err
+    }
+    if len(goal.Plans) > 0 {
+        last := goal.Plans[len(goal.Plans)-1]
+        goal.PlanThinking, goal.PlanPromptVersion = last.Thinking, last.PromptVersion
+    }
+
+    rows, err := db.Query(`SELECT role, content FROM chat_messages WHERE goal_id = ? ORDER BY id`, id)
+    if err != nil {
+        return nil, fmt.Errorf("failed to load chat history of goal %d: %w", id, err)
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var message types.PromptMessage
+        if err := rows.Scan(&message.Role, &message.Content); err != nil {
+            return nil, fmt.Errorf("failed to load chat history of goal %d: %w", id, err)
+        }
+        stored.ChatHistory = append(stored.ChatHistory, message)
+    }
+    return stored, rows.Err()
+}
+
+func loadPlans(goal *goalengine.Goal) error {
+    rows, err := db.Query(`SELECT reason, thinking, prompt_version FROM plans WHERE goal_id = ? ORDER BY position`, goal.ID)
+    if err != nil {
+        return fmt.Errorf("failed to load plans of goal %d: %w", goal.ID, err)
+    }
+    defer rows.Close()
+    for rows.Next() {
+        plan := &goalengine.Plan{}
+        if err := rows.Scan(&plan.Reason, &plan.Thinking, &plan.PromptVersion); err != nil {
+            return fmt.Errorf("failed to load plans of goal %d: %w", goal.ID, err)
+        }
+        goal.Plans = append(goal.Plans, plan)
+    }
+    return rows.Err()
+}
+
+// loadTasks reads the tasks of goal into its plans, and those with a
+// position into its current tasks.
+func loadTasks(goal *goalengine.Goal) error {
+    rows, err := db.Query(`SELECT id, plan, position, description, status, feedback, thinking, prompt_version, samples, disagreement,
+            attempt, max_retries, commands, depends_on, gui, postconditions
+        FROM tasks WHERE goal_id = ? ORDER BY id`, goal.ID)
+    if err != nil {
+        return fmt.Errorf("failed to load tasks of goal %d: %w", goal.ID, err)
+    }
+    defer rows.Close()
+
+    current := map[int]*goalengine.Task{}
+    for rows.Next() {
+        task := &goalengine.Task{}
+        var plan, position int
+        var status, commands, dependsOn, postconditions string
+        if err := rows.Scan(&task.ID, &plan, &position, &task.Description, &status, &task.Feedback, &task.Thinking, &task.PromptVersion,
+            &task.Samples, &task.Disagreement, &task.Attempt, &task.MaxRetries, &commands, &dependsOn, &task.GUI, &postconditions); err != nil {
+            return fmt.Errorf("failed to load tasks of goal %d: %w", goal.ID, err)
+        }
+        if task.Status, err = goalengine.ParseTaskStatus(status); err != nil {
+            return fmt.Errorf("failed to load task '%s': %w", task.Description, err)
+        }
+        json.Unmarshal([]byte(commands), &task.Commands)
+        json.Unmarshal([]byte(dependsOn), &task.DependsOn)
+        json.Unmarshal([]byte(postconditions), &task.Postconditions)
+
+        if plan >= 0 && plan < len(goal.Plans) {
+            goal.Plans[plan].Tasks = append(goal.Plans[plan].Tasks, task)
+        }
+        if position >= 0 {
+            current[position] = task
+        }
+    }
+    if err := rows.Err(); err != nil {
+        return fmt.Errorf("failed to load tasks of goal %d: %w", goal.ID, err)
+    }
+
+    for i := 0; i < len(current); i++ {
+        task, ok := current[i]
+        if !ok {
+            return fmt.Errorf("goal %d is missing task %d", goal.ID, i)
+        }
+        goal.Tasks = append(goal.Tasks, task)
+    }
+    return nil
+}
@@ -0,0 +1,150 @@
+package logging
+
+import (
+    "errors"
+    "log"
+    "os"
+    "reflect"
+    "testing"
+
+    "WSA/pkg/goalengine"
+    "WSA/pkg/types"
+)
+
+// setupTestStore opens a goal store in a temporary directory and closes it
+// when the test ends.
+func setupTestStore(t *testing.T) {
+    t.Helper()
+    wd, err := os.Getwd()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := os.Chdir(t.TempDir()); err != nil {
+        t.Fatal(err)
+    }
+    SetupLogging()
+    t.Cleanup(func() {
+        db.Close()
+        db = nil
+        log.SetOutput(os.Stderr)
+        os.Chdir(wd)
+    })
+}
+
+func TestGoalStoreRoundTrip(t *testing.T) {
+    setupTestStore(t)
+
+    goal := &goalengine.Goal{Description: "tidy downloads", Status: goalengine.GoalRunning}
+    goal.AddPlan(&goalengine.Plan{Thinking: "first", PromptVersion: "4", Tasks: []*goalengine.Task{
+        {Description: "list files", MaxRetries: 2},
+        {Description: "move files", MaxRetries: 2, DependsOn: []int{0}, GUI: true,
+            Postconditions: []goalengine.Postcondition{{Kind: goalengine.DirExists, Target: "~/Downloads/old"}}},
+    }})
+    if err := CreateGoal(goal, []byte(`{"goal":"tidy downloads"}`)); err != nil {
+        t.Fatalf("CreateGoal failed: %v", err)
+    }
+    if goal.ID == 0 || goal.Tasks[0].ID == 0 || goal.Tasks[1].ID == 0 {
+        t.Fatalf("CreateGoal left goal %d with task IDs %d and %d", goal.ID, goal.Tasks[0].ID, goal.Tasks[1].ID)
+    }
+
+    // The first task completes and the second fails, then the goal is replanned
+    list, move := goal.Tasks[0], goal.Tasks[1]
+    list.Status, list.Attempt, list.Commands = goalengine.Completed, 1, []string{"ls ~/Downloads"}
+    LogTaskExecution(goal, list)
+    move.Status, move.Attempt, move.Commands, move.Feedback = goalengine.Failed, 1, []string{"mv a b"}, "permission denied"
+    LogTaskExecution(goal, move)
+    goal.AddPlan(&goalengine.Plan{Reason: "move files failed", Thinking: "second", PromptVersion: "4", Tasks: []*goalengine.Task{
+        {Description: "move files with sudo", MaxRetries: 2},
+    }})
+    goal.AddLog("replanned")
+    if err := SaveGoal(goal); err != nil {
+        t.Fatalf("SaveGoal failed: %v", err)
+    }
+    AppendGoalLog(goal, "appended")
+    LogChatMessages(goal, []types.PromptMessage{{Role: "user", Content: "tidy downloads"}})
+
+    stored, err := LoadGoal(goal.ID)
+    if err != nil {
+        t.Fatalf("LoadGoal failed: %v", err)
+    }
+    loaded := stored.Goal
+    if loaded.Description != goal.Description || loaded.Status != goalengine.GoalRunning {
+        t.Errorf("loaded goal %q %s, want %q Running", loaded.Description, loaded.Status, goal.Description)
+    }
+    if string(stored.Request) != `{"goal":"tidy downloads"}` {
+        t.Errorf("loaded request %s", stored.Request)
+    }
+    if want := []string{"replanned", "appended"}; !reflect.DeepEqual(loaded.Logs, want) {
+        t.Errorf("loaded logs %q, want %q", loaded.Logs, want)
+    }
+    if want := []types.PromptMessage{{Role: "user", Content: "tidy downloads"}}; !reflect.DeepEqual(stored.ChatHistory, want) {
+        t.Errorf("loaded chat history %+v, want %+v", stored.ChatHistory, want)
+    }
+
+    if len(loaded.Plans) != 2 || loaded.Plans[1].Reason != "move files failed" || loaded.PlanThinking != "second" {
+        t.Fatalf("loaded %d plans with thinking %q", len(loaded.Plans), loaded.PlanThinking)
+    }
+    if len(loaded.Plans[0].Tasks) != 2 || len(loaded.Plans[1].Tasks) != 1 {
+        t.Errorf("loaded plans with %d and %d tasks, want 2 and 1", len(loaded.Plans[0].Tasks), len(loaded.Plans[1].Tasks))
+    }
+    var current []string
+    for _, task := range loaded.Tasks {
+        current = append(current, task.Description)
+    }
+    if want := []string{"list files", "move files with sudo"}; !reflect.DeepEqual(current, want) {
+        t.Errorf("loaded current tasks %q, want %q", current, want)
+    }
+
+    gotMove := loaded.Plans[0].Tasks[1]
+    wantMove := *move
+    if !reflect.DeepEqual(*gotMove, wantMove) {
+        t.Errorf("loaded task %+v, want %+v", *gotMove, wantMove)
+    }
+
+    var attempts int
+    if err := db.QueryRow(`SELECT COUNT(*) FROM attempts WHERE goal_id = ?`, goal.ID).Scan(&attempts); err != nil || attempts != 2 {
+        t.Errorf("stored %d attempts (%v), want 2", attempts, err)
+    }
+}
+
+func TestLoadGoalNotFound(t *testing.T) {
+    setupTestStore(t)
+
+    if _, err := LoadGoal(42); !errors.Is(err, ErrGoalNotFound) {
+        t.Errorf("LoadGoal(42) error = %v, want ErrGoalNotFound", err)
+    }
+}
+
+func TestMarkInterruptedGoals(t *testing.T) {
+    setupTestStore(t)
+
+    statuses := []goalengine.GoalStatus{goalengine.GoalPending, goalengine.GoalRunning, goalengine.GoalSucceeded, goalengine.GoalCancelled}
+    var goals []*goalengine.Goal
+    for _, status := range statuses {
+        goal := &goalengine.Goal{Description: status.String(), Status: status}
+        if err := CreateGoal(goal, nil); err != nil {
+            t.Fatalf("CreateGoal failed: %v", err)
+        }
+        goals = append(goals, goal)
+    }
+
+    marked, err := MarkInterruptedGoals()
+    if err != nil || marked != 2 {
+        t.Fatalf("MarkInterruptedGoals() = %d, %v, want 2", marked, err)
+    }
+    want := []goalengine.GoalStatus{goalengine.GoalInterrupted, goalengine.GoalInterrupted, goalengine.GoalSucceeded, goalengine.GoalCancelled}
+    for i, goal := range goals {
+        stored, err := LoadGoal(goal.ID)
+        if err != nil {
+            t.Fatalf("LoadGoal failed: %v", err)
+        }
+        if stored.Goal.Status != want[i] {
+            t.Errorf("goal stored as %s is now %s, want %s", statuses[i], stored.Goal.Status, want[i])
+        }
+    }
+
+    interrupted, err := ListGoals(goalengine.GoalInterrupted.String())
+    if err != nil || len(interrupted) != 2 {
+        t.Errorf("ListGoals(Interrupted) = %d goals, %v, want 2", len(interrupted), err)
+    }
+}
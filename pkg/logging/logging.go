@@ -24,8 +24,9 @@ func SetupLogging() {
     log.SetOutput(mw)
     log.Println("Logging started")
 
-    // Initialize SQLite database using the pure Go driver
-    db, err = sql.Open("sqlite", "./app.db")
+    // Initialize SQLite database using the pure Go driver. Tasks running at
+    // the same time write to it, so wait for locks instead of failing.
+    db, err = sql.Open("sqlite", "./app.db?_pragma=busy_timeout(5000)")
     if err != nil {
         log.Fatalf("Failed to open database: %v", err)
     }
@@ -45,6 +46,12 @@ func createTables() {
         description TEXT,
         status TEXT,
         feedback TEXT,
+        thinking TEXT,
+        plan_thinking TEXT,
+        prompt_version TEXT,
+        plan_prompt_version TEXT,
+        samples INTEGER,
+        disagreement REAL,
         timestamp DATETIME DEFAULT CURRENT_TIMESTAMP
     );`
 
@@ -52,28 +59,58 @@ func createTables() {
     if err != nil {
         log.Fatalf("Failed to create tasks table: %v", err)
     }
+
+    // Databases created by earlier versions lack these columns
+    addColumnIfMissing("tasks", "thinking", "TEXT")
+    addColumnIfMissing("tasks", "plan_thinking", "TEXT")
+    addColumnIfMissing("tasks", "prompt_version", "TEXT")
+    addColumnIfMissing("tasks", "plan_prompt_version", "TEXT")
+    addColumnIfMissing("tasks", "samples", "INTEGER")
+    addColumnIfMissing("tasks", "disagreement", "REAL")
+
+    createCacheTable()
+    createGoalTables()
 }
 
-// LogTaskExecution logs each task's execution details
-func LogTaskExecution(task *goalengine.Task) {
-    _, err := db.Exec(`INSERT INTO tasks (description, status, feedback) VALUES (?, ?, ?)`,
-        task.Description, taskStatusToString(task.Status), task.Feedback)
+// addColumnIfMissing adds a column to an existing table.
+func addColumnIfMissing(table, column, definition string) {
+    rows, err := db.Query(`SELECT name FROM pragma_table_info(?)`, table)
     if err != nil {
-        log.Printf("Failed to log task execution: %v", err)
+        log.Fatalf("Failed to inspect %s table: %v", table, err)
+    }
+    found := false
+    for rows.Next() {
+        var name string
+        if err := rows.Scan(&name); err == nil && name == column {
+            found = true
+        }
+    }
+    rows.Close()
+    if found {
+        return
+    }
+
+    if _, err := db.Exec(`ALTER TABLE ` + table + ` ADD COLUMN ` + column + ` ` + definition); err != nil {
+        log.Fatalf("Failed to add %s column to %s table: %v", column, table, err)
     }
 }
 
-func taskStatusToString(status goalengine.TaskStatus) string {
-    switch status {
-    case goalengine.Pending:
-        return "Pending"
-    case goalengine.InProgress:
-        return "InProgress"
-    case goalengine.Completed:
-        return "Completed"
-    case goalengine.Failed:
-        return "Failed"
-    default:
-        return "Unknown"
+// LogTaskExecution logs each task's execution details, including the model's
+// thinking and the prompt template versions for the task and its goal's plan.
+// Tasks of stored goals have their row updated and the attempt recorded.
+func LogTaskExecution(goal *goalengine.Goal, task *goalengine.Task) {
+    if db == nil {
+        return
+    }
+    if task.ID != 0 {
+        logAttempt(goal, task)
+        return
+    }
+
+    _, err := db.Exec(`INSERT INTO tasks (description, status, feedback, thinking, plan_thinking, prompt_version, plan_prompt_version, samples, disagreement) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+        task.Description, task.Status.String(), task.Feedback, task.Thinking, goal.PlanThinking,
+        task.PromptVersion, goal.PlanPromptVersion, task.Samples, task.Disagreement)
+    if err != nil {
+        log.Printf("Failed to log task execution: %v", err)
     }
 }
@@ -0,0 +1,142 @@
+package logging
+
+import (
+    "fmt"
+    "log"
+    "time"
+
+    "WSA/pkg/llm"
+)
+
+// ResponseCache is an llm.Cache stored in the llm_cache table of app.db.
+// Entries older than TTL are misses, and only the MaxEntries most recently
+// used entries are kept.
+type ResponseCache struct {
+    TTL        time.Duration
+    MaxEntries int
+}
+
+// CacheEntry describes a cached response for the /cache endpoint.
+type CacheEntry struct {
+    Key        string    `json:"key"`
+    Provider   string    `json:"provider"`
+    Model      string    `json:"model"`
+    PromptHash string    `json:"promptHash"`
+    Options    string    `json:"options"`
+    Size       int       `json:"size"`
+    CreatedAt  time.Time `json:"createdAt"`
+    Hits       int       `json:"hits"`
+}
+
+func createCacheTable() {
+    createCacheTable := `CREATE TABLE IF NOT EXISTS llm_cache (
+        key TEXT PRIMARY KEY,
+        provider TEXT,
+        model TEXT,
+        prompt_hash TEXT,
+        options TEXT,
+        response BLOB,
+        created_at INTEGER, -- Unix seconds
+        last_used INTEGER,  -- Unix nanoseconds
+        hits INTEGER DEFAULT 0
+    );`
+
+    _, err := db.Exec(createCacheTable)
+    if err != nil {
+        log.Fatalf("Failed to create llm_cache table: %v", err)
+    }
+}
+
+// Get returns the cached response for key unless it is missing or expired.
+func (c *ResponseCache) Get(key llm.CacheKey) ([]byte, time.Time, bool) {
+    if db == nil {
+        return nil, time.Time{}, false
+    }
+
+    var value []byte
+    var createdAt int64
+    err := db.QueryRow(`SELECT response, created_at FROM llm_cache WHERE key = ?`, key.String()).Scan(&value, &createdAt)
+    if err != nil {
+        return nil, time.Time{}, false
+    }
+    created := time.Unix(createdAt, 0)
+    if c.TTL > 0 && time.Since(created) > c.TTL {
+        return nil, time.Time{}, false
+    }
+
+    _, err = db.Exec(`UPDATE llm_cache SET hits = hits + 1, last_used = ? WHERE key = ?`, time.Now().UnixNano(), key.String())
+    if err != nil {
+        log.Printf("Failed to update response cache entry: %v", err)
+    }
+    return value, created, true
+}
+
+// Put stores value under key, then removes expired entries and the least
+// recently used ones beyond MaxEntries.
+func (c *ResponseCache) Put(key llm.CacheKey, value []byte) {
+    if db == nil {
+        return
+    }
+
+    now := time.Now()
+    _, err := db.Exec(`INSERT OR REPLACE INTO llm_cache (key, provider, model, prompt_hash, options, response, created_at, last_used, hits)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, 0)`,
+        key.String(), key.Provider, key.Model, key.PromptHash, key.Options, value, now.Unix(), now.UnixNano())
+    if err != nil {
+        log.Printf("Failed to store response in cache: %v", err)
+        return
+    }
+
+    if c.TTL > 0 {
+        if _, err := db.Exec(`DELETE FROM llm_cache WHERE created_at < ?`, time.Now().Add(-c.TTL).Unix()); err != nil {
+            log.Printf("Failed to remove expired cache entries: %v", err)
+        }
+    }
+    if c.MaxEntries > 0 {
+        _, err := db.Exec(`DELETE FROM llm_cache WHERE key NOT IN (SELECT key FROM llm_cache ORDER BY last_used DESC LIMIT ?)`, c.MaxEntries)
+        if err != nil {
+            log.Printf("Failed to trim response cache: %v", err)
+        }
+    }
+}
+
+// CacheEntries lists the cached responses, most recently used first.
+func CacheEntries() ([]CacheEntry, error) {
+    if db == nil {
+        return nil, fmt.Errorf("database is not open")
+    }
+
+    rows, err := db.Query(`SELECT key, provider, model, prompt_hash, options, length(response), created_at, hits
+        FROM llm_cache ORDER BY last_used DESC`)
+    if err != nil {
+        return nil, fmt.Errorf("failed to query response cache: %w", err)
+    }
+    defer rows.Close()
+
+    entries := []CacheEntry{}
+    for rows.Next() {
+        var entry CacheEntry
+        var createdAt int64
+        if err := rows.Scan(&entry.Key, &entry.Provider, &entry.Model, &entry.PromptHash, &entry.Options,
+            &entry.Size, &createdAt, &entry.Hits); err != nil {
+            return nil, fmt.Errorf("failed to read response cache entry: %w", err)
+        }
+        entry.CreatedAt = time.Unix(createdAt, 0)
+        entries = append(entries, entry)
+    }
+    return entries, rows.Err()
+}
+
+// ClearResponseCache deletes every cached response and returns how many
+// were removed.
+func ClearResponseCache() (int64, error) {
+    if db == nil {
+        return 0, fmt.Errorf("database is not open")
+    }
+
+    result, err := db.Exec(`DELETE FROM llm_cache`)
+    if err != nil {
+        return 0, fmt.Errorf("failed to clear response cache: %w", err)
+    }
+    return result.RowsAffected()
+}
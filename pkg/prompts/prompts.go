@@ -0,0 +1,128 @@
+package prompts
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"text/template"
+)
+
+// Names of the prompt templates.
+const (
+	ShellCommand      = "shell_command"
+	TaskPlan          = "task_plan"
+	CommandGeneration = "command_generation"
+	VerifyTask        = "verify_task"
+	ToolAgent         = "tool_agent"
+)
+
+//go:embed templates
+var builtin embed.FS
+
+// versionPattern matches the {{/* version: N */}} header of a template.
+var versionPattern = regexp.MustCompile(`^\{\{-?\s*/\*\s*version:\s*(\S+)\s*\*/`)
+
+// Vars are the values available to prompt templates.
+type Vars struct {
+	OS             string // Display name of the operating system, e.g. "macOS"
+	Shell          string
+	DefaultBrowser string
+	Username       string
+	IndexSummary   string // Summary of key directories from the system index
+	ErrorContext   string // Error of the previous attempt, if any
+	Goal           string
+}
+
+// Platform returns the template set used on this machine: "linux" on Linux
+// and "darwin" everywhere else.
+func Platform() string {
+	if runtime.GOOS == "linux" {
+		return "linux"
+	}
+	return "darwin"
+}
+
+// DefaultVars returns Vars with the OS and shell of this machine filled in.
+func DefaultVars() Vars {
+	vars := Vars{OS: "macOS", Shell: "/bin/zsh"}
+	if Platform() == "linux" {
+		vars = Vars{OS: "Linux", Shell: "/bin/bash"}
+	}
+	if shell := os.Getenv("SHELL"); shell != "" {
+		vars.Shell = shell
+	}
+	return vars
+}
+
+// Dir returns the directory searched for user overrides: WSA_PROMPTS_DIR if
+// set, otherwise wsa/prompts in the user's config directory.
+func Dir() string {
+	if dir := os.Getenv("WSA_PROMPTS_DIR"); dir != "" {
+		return dir
+	}
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(configDir, "wsa", "prompts")
+}
+
+// Render executes the named template with vars and returns the prompt along
+// with the template's version, e.g. "shell_command/darwin@1". An override in
+// Dir()/<platform>/<name>.tmpl or Dir()/<name>.tmpl takes precedence over the
+// built-in template and is marked "(override)" in the version.
+func Render(name string, vars Vars) (string, string, error) {
+	text, override, err := load(name)
+	if err != nil {
+		return "", "", err
+	}
+
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to parse prompt template %s: %w", name, err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, vars); err != nil {
+		return "", "", fmt.Errorf("failed to render prompt template %s: %w", name, err)
+	}
+
+	version := "unversioned"
+	if m := versionPattern.FindStringSubmatch(text); m != nil {
+		version = m[1]
+	}
+	version = fmt.Sprintf("%s/%s@%s", name, Platform(), version)
+	if override {
+		version += " (override)"
+	}
+	return buf.String(), version, nil
+}
+
+// load returns the source of the named template and whether it came from the
+// user's override directory.
+func load(name string) (string, bool, error) {
+	if dir := Dir(); dir != "" {
+		for _, path := range []string{
+			filepath.Join(dir, Platform(), name+".tmpl"),
+			filepath.Join(dir, name+".tmpl"),
+		} {
+			data, err := os.ReadFile(path)
+			if err == nil {
+				return string(data), true, nil
+			}
+			if !os.IsNotExist(err) {
+				return "", false, fmt.Errorf("failed to read prompt template %s: %w", path, err)
+			}
+		}
+	}
+
+	data, err := builtin.ReadFile("templates/" + Platform() + "/" + name + ".tmpl")
+	if err != nil {
+		return "", false, fmt.Errorf("unknown prompt template %s: %w", name, err)
+	}
+	return string(data), false, nil
+}
@@ -5,13 +5,97 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"WSA/pkg/llm"
 )
 
 type Settings struct {
 	DefaultBrowser string `json:"defaultBrowser"`
+	// LLMProvider selects the model backend: "ollama" (default) or "openai"
+	// for OpenAI-compatible servers such as llama.cpp and vLLM.
+	LLMProvider    string `json:"llmProvider,omitempty"`
+	OpenAIEndpoint string `json:"openaiEndpoint,omitempty"`
+	OpenAIAPIKey   string `json:"openaiApiKey,omitempty"`
+	// OpenAIAPIKeySet reports whether an API key is stored, in place of the
+	// key itself in the settings returned by Redacted.
+	OpenAIAPIKeySet bool `json:"openaiApiKeySet,omitempty"`
+	// OpenAIJSONSchema can be set to false for OpenAI-compatible servers
+	// that do not support json_schema response formats. Default true.
+	OpenAIJSONSchema *bool `json:"openaiJsonSchema,omitempty"`
+	// AgentMode completes tasks through native tool calls by default.
+	AgentMode bool `json:"agentMode,omitempty"`
+	// IncludeThinking returns model reasoning traces with /execute responses.
+	IncludeThinking bool `json:"includeThinking,omitempty"`
+	// ResponseCache reuses stored model responses for identical requests.
+	ResponseCache           bool   `json:"responseCache,omitempty"`
+	ResponseCacheTTL        string `json:"responseCacheTTL,omitempty"`        // Go duration, default 24h
+	ResponseCacheMaxEntries int    `json:"responseCacheMaxEntries,omitempty"` // Default 500
+	// ModelRoles names the models used for planning, command generation,
+	// vision and verification.
+	ModelRoles ModelRoles `json:"modelRoles,omitempty"`
+	// VerifyTasks has the verifier model check each task whose commands
+	// succeeded and retries the task when it was not achieved.
+	VerifyTasks bool `json:"verifyTasks,omitempty"`
+	// CommandSamples is how many command candidates are sampled per task
+	// and voted on. 0 or 1 takes a single sample.
+	CommandSamples int `json:"commandSamples,omitempty"`
+	// ParallelTasks is how many independent tasks of a goal run at the same
+	// time. 0 uses the default of 1.
+	ParallelTasks int `json:"parallelTasks,omitempty"`
+	// PostconditionTimeout is how long the postconditions of a task are given
+	// to hold after it runs, as a Go duration. Default 5s.
+	PostconditionTimeout string `json:"postconditionTimeout,omitempty"`
+	// MaxReplans is how many times a goal is replanned after tasks fail. 0
+	// uses the default of 2 and a negative value turns replanning off.
+	MaxReplans int `json:"maxReplans,omitempty"`
+	// PreloadModels loads the first planner and commander models when the
+	// server starts.
+	PreloadModels bool `json:"preloadModels,omitempty"`
+	// KeepAlive is how long loaded models stay in memory: a Go duration,
+	// seconds, or -1 for until unloaded. Empty uses the Ollama default.
+	KeepAlive string `json:"keepAlive,omitempty"`
 	// Add other settings fields here as needed
 }
 
+// ModelRoles lists the models of each role in fallback order: a model is
+// used when the ones before it are not installed or fail.
+type ModelRoles struct {
+	Planner   []string `json:"planner,omitempty"`
+	Commander []string `json:"commander,omitempty"`
+	Vision    []string `json:"vision,omitempty"`
+	Verifier  []string `json:"verifier,omitempty"`
+}
+
+// Chain returns the models of role.
+func (r ModelRoles) Chain(role llm.Role) []string {
+	switch role {
+	case llm.RolePlanner:
+		return r.Planner
+	case llm.RoleCommander:
+		return r.Commander
+	case llm.RoleVision:
+		return r.Vision
+	case llm.RoleVerifier:
+		return r.Verifier
+	}
+	return nil
+}
+
+// Map returns the chains of the roles that name models, as used by
+// llm.Options.
+func (r ModelRoles) Map() map[llm.Role][]string {
+	roles := map[llm.Role][]string{}
+	for _, role := range llm.Roles {
+		if chain := r.Chain(role); len(chain) > 0 {
+			roles[role] = chain
+		}
+	}
+	return roles
+}
+
 const settingsFilePath = "system_settings.json"
 
 // LoadSettings loads the settings from the settings file or creates default settings if the file doesn't exist.
@@ -35,9 +119,20 @@ func LoadSettings() (*Settings, error) {
 	return &settings, nil
 }
 
+// Redacted returns a copy of the settings that is safe to send to clients:
+// the API key is left out and OpenAIAPIKeySet tells whether one is stored.
+func (s *Settings) Redacted() Settings {
+	redacted := *s
+	redacted.OpenAIAPIKeySet = s.OpenAIAPIKey != ""
+	redacted.OpenAIAPIKey = ""
+	return redacted
+}
+
 // SaveSettings saves the settings to the settings file.
 func (s *Settings) SaveSettings() error {
-	data, err := json.MarshalIndent(s, "", "  ")
+	stored := *s
+	stored.OpenAIAPIKeySet = false
+	data, err := json.MarshalIndent(&stored, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal settings: %w", err)
 	}
@@ -49,3 +144,49 @@ func (s *Settings) SaveSettings() error {
 
 	return nil
 }
+
+// ApplyLLMEnvironment exports the LLM backend settings as the environment
+// variables read by the llm package. Empty settings leave the environment as is.
+func (s *Settings) ApplyLLMEnvironment() {
+	if s.LLMProvider != "" {
+		os.Setenv("LLM_PROVIDER", s.LLMProvider)
+	}
+	if s.OpenAIEndpoint != "" {
+		os.Setenv("OPENAI_API_BASE", s.OpenAIEndpoint)
+	}
+	if s.OpenAIAPIKey != "" {
+		os.Setenv("OPENAI_API_KEY", s.OpenAIAPIKey)
+	}
+	if s.OpenAIJSONSchema != nil {
+		os.Setenv("OPENAI_JSON_SCHEMA", strconv.FormatBool(*s.OpenAIJSONSchema))
+	}
+	s.ApplyModelRoles()
+}
+
+var (
+	startRolesOnce sync.Once
+	startRoles     = map[llm.Role]string{}
+)
+
+// ApplyModelRoles exports the model chain of each role as the environment
+// variable read by llm.RoleModels: the models of the settings first, then
+// any set in the environment when the server started.
+func (s *Settings) ApplyModelRoles() {
+	startRolesOnce.Do(func() {
+		for _, role := range llm.Roles {
+			startRoles[role] = os.Getenv(llm.RoleEnv(role))
+		}
+	})
+
+	for _, role := range llm.Roles {
+		chain := append([]string{}, s.ModelRoles.Chain(role)...)
+		if startRoles[role] != "" {
+			chain = append(chain, startRoles[role])
+		}
+		if len(chain) == 0 {
+			os.Unsetenv(llm.RoleEnv(role))
+			continue
+		}
+		os.Setenv(llm.RoleEnv(role), strings.Join(chain, ","))
+	}
+}
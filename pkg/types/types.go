@@ -7,12 +7,43 @@ type CombinedPrompt struct {
     NLResponse  string   `json:"nlResponse"`
     Commands    []string `json:"commands"`
     VisionNeeded bool     `json:"visionNeeded"` // Indicates if vision is needed for the task
+    Thinking    string   `json:"-"`            // Reasoning trace of the model, if it returned one
+    PromptVersion string `json:"-"`            // Version of the system prompt template used
 }
 
 // PromptMessage represents a message in the chat history.
 type PromptMessage struct {
-    Role    string `json:"role"`
-    Content string `json:"content"`
+    Role       string     `json:"role"`
+    Content    string     `json:"content"`
+    ToolCalls  []ToolCall `json:"tool_calls,omitempty"`   // Tool calls requested by the assistant
+    ToolName   string     `json:"tool_name,omitempty"`    // Name of the tool a "tool" message answers
+    ToolCallID string     `json:"tool_call_id,omitempty"` // ID of the call a "tool" message answers (OpenAI)
+}
+
+// Tool describes a function the model may call through the tools API
+type Tool struct {
+    Type     string       `json:"type"` // Always "function"
+    Function ToolFunction `json:"function"`
+}
+
+// ToolFunction is the name, description and JSON-schema parameters of a tool
+type ToolFunction struct {
+    Name        string      `json:"name"`
+    Description string      `json:"description"`
+    Parameters  interface{} `json:"parameters"`
+}
+
+// ToolCall is a single tool invocation requested by the model
+type ToolCall struct {
+    ID       string           `json:"id,omitempty"`
+    Type     string           `json:"type,omitempty"`
+    Function ToolCallFunction `json:"function"`
+}
+
+// ToolCallFunction holds the called tool's name and decoded arguments
+type ToolCallFunction struct {
+    Name      string                 `json:"name"`
+    Arguments map[string]interface{} `json:"arguments"`
 }
 
 // ChatData represents the data sent to the LLM API
@@ -20,6 +51,9 @@ type ChatData struct {
     Model    string          `json:"model"`
     Messages []PromptMessage `json:"messages"`
     Stream   bool            `json:"stream"` // Indicates whether to use streaming
+    Tools    []Tool          `json:"tools,omitempty"`
+    Options  map[string]interface{} `json:"options,omitempty"`
+    Format   interface{}            `json:"format,omitempty"` // "json" or a JSON schema constraining the reply
 }
 
 // LLMResponse represents the response from the LLM API when streaming is disabled
@@ -27,17 +61,116 @@ type LLMResponse struct {
     Model     string     `json:"model"`
     CreatedAt string     `json:"created_at"`
     Message   LLMMessage `json:"message"`
+    Done      bool       `json:"done"`
+    PromptEvalCount int  `json:"prompt_eval_count,omitempty"` // Prompt tokens, set on the final message
+    EvalCount       int  `json:"eval_count,omitempty"`        // Generated tokens, set on the final message
     // Include other fields as necessary
 }
 
 type LLMMessage struct {
-    Role    string `json:"role"`
-    Content string `json:"content"`
+    Role     string `json:"role"`
+    Content  string `json:"content"`
+    Thinking string `json:"thinking,omitempty"` // Reasoning text from thinking models such as gpt-oss
+    ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+}
+
+// StreamEvent is a Server-Sent Event pushed to /execute/stream clients
+type StreamEvent struct {
+    Type string `json:"type"`           // thinking, nlResponse, plan, log or done
+    Task string `json:"task,omitempty"` // Task description the event belongs to, if any
+    Data string `json:"data"`
 }
 
 // OllamaResponse represents the response from Ollama when streaming is disabled
 type OllamaResponse struct {
     Model     string `json:"model"`
-    CreatedAt int64  `json:"created_at"`
+    CreatedAt string `json:"created_at"`
     Response  string `json:"response"`
 }
+
+// OpenAIChatRequest is the body sent to an OpenAI-compatible /v1/chat/completions endpoint
+type OpenAIChatRequest struct {
+    Model       string          `json:"model"`
+    Messages    []OpenAIMessage `json:"messages"`
+    Tools       []Tool          `json:"tools,omitempty"`
+    Stream      bool            `json:"stream"`
+    Temperature *float64        `json:"temperature,omitempty"`
+    MaxTokens   int             `json:"max_tokens,omitempty"`
+    ResponseFormat interface{}  `json:"response_format,omitempty"`
+}
+
+// OpenAIMessage is a chat message whose content is either a string or a list of content parts
+type OpenAIMessage struct {
+    Role       string           `json:"role"`
+    Content    interface{}      `json:"content"`
+    ToolCalls  []OpenAIToolCall `json:"tool_calls,omitempty"`
+    ToolCallID string           `json:"tool_call_id,omitempty"`
+    // ReasoningContent is the thinking trace returned by reasoning models on
+    // vLLM and llama.cpp; it is never sent back to the server.
+    ReasoningContent string `json:"reasoning_content,omitempty"`
+}
+
+// OpenAIToolCall is a tool call whose arguments are a JSON-encoded string
+type OpenAIToolCall struct {
+    ID       string `json:"id"`
+    Type     string `json:"type"`
+    Function struct {
+        Name      string `json:"name"`
+        Arguments string `json:"arguments"`
+    } `json:"function"`
+}
+
+// OpenAIContentPart is a single text or image part of a multimodal message
+type OpenAIContentPart struct {
+    Type     string          `json:"type"`
+    Text     string          `json:"text,omitempty"`
+    ImageURL *OpenAIImageURL `json:"image_url,omitempty"`
+}
+
+// OpenAIImageURL holds an image reference, usually a base64 data URL
+type OpenAIImageURL struct {
+    URL string `json:"url"`
+}
+
+// OpenAIChatResponse represents the non-streaming response from /v1/chat/completions
+type OpenAIChatResponse struct {
+    Model   string `json:"model"`
+    Created int64  `json:"created"`
+    Choices []struct {
+        Index        int           `json:"index"`
+        Message      OpenAIMessage `json:"message"`
+        FinishReason string        `json:"finish_reason"`
+    } `json:"choices"`
+    Usage struct {
+        PromptTokens     int `json:"prompt_tokens"`
+        CompletionTokens int `json:"completion_tokens"`
+    } `json:"usage"`
+}
+
+// OpenAIModelsResponse represents the response from /v1/models
+type OpenAIModelsResponse struct {
+    Data []struct {
+        ID      string `json:"id"`
+        Created int64  `json:"created"`
+        OwnedBy string `json:"owned_by"`
+        MaxModelLen int `json:"max_model_len,omitempty"` // Context length reported by vLLM
+        Meta struct {
+            NCtxTrain int `json:"n_ctx_train,omitempty"` // Context length reported by llama.cpp
+        } `json:"meta"`
+    } `json:"data"`
+}
+
+// OllamaShowResponse represents the response from Ollama's /api/show endpoint
+type OllamaShowResponse struct {
+    Parameters string `json:"parameters"`
+    Template   string `json:"template"`
+    Details    struct {
+        Format            string   `json:"format"`
+        Family            string   `json:"family"`
+        Families          []string `json:"families"`
+        ParameterSize     string `json:"parameter_size"`
+        QuantizationLevel string `json:"quantization_level"`
+    } `json:"details"`
+    ModelInfo    map[string]interface{} `json:"model_info"`
+    Capabilities []string               `json:"capabilities"` // Missing on Ollama before 0.6.4
+}
@@ -1,19 +1,17 @@
 package vision
 
 import (
-    "bytes"
+    "context"
     "encoding/base64"
-    "encoding/json"
+    "errors"
     "fmt"
-    "io"
-    "net/http"
     "os"
 
-    "WSA/pkg/types"
+    "WSA/pkg/llm"
 )
 
-// ProcessImage uses LLava via Ollama to process an image and generate a description or extract information
-func ProcessImage(imagePath string, question string) (string, error) {
+// ProcessImage uses the vision model to process an image and generate a description or extract information
+func ProcessImage(ctx context.Context, imagePath string, question string) (string, error) {
     imageData, err := os.ReadFile(imagePath)
     if err != nil {
         return "", fmt.Errorf("failed to read image file: %w", err)
@@ -22,42 +20,78 @@ func ProcessImage(imagePath string, question string) (string, error) {
     // Encode image in base64
     imageBase64 := base64.StdEncoding.EncodeToString(imageData)
 
-    // Prepare the request payload
-    payload := map[string]interface{}{
-        "model":  "llava",
-        "prompt": question,
-        "images": []string{imageBase64},
+    // Send the image to the vision models through the configured LLM provider
+    response, err := see(ctx, llm.For(ctx), "", question, []string{imageBase64})
+    if err != nil {
+        return "", fmt.Errorf("error making vision request: %w", err)
     }
 
-    payloadBytes, err := json.Marshal(payload)
+    return response.Response, nil
+}
+
+// AnalyzeWithImages sends a prompt and one or more base64-encoded images to a multimodal
+// model and returns the text response. Without a model, the vision models of
+// the llm.Options carried by ctx are used.
+func AnalyzeWithImages(ctx context.Context, prompt string, imagesBase64 []string, model string) (string, error) {
+    response, err := see(ctx, llm.For(ctx), model, prompt, imagesBase64)
     if err != nil {
-        return "", fmt.Errorf("failed to marshal payload: %w", err)
+        return "", fmt.Errorf("vision request failed: %w", err)
     }
 
-    // Send request to Ollama API
-    apiEndpoint := os.Getenv("LLM_API_ENDPOINT")
-    if apiEndpoint == "" {
-        apiEndpoint = "http://localhost:11434/api/generate"
-    }
+    return response.Response, nil
+}
 
-    response, err := http.Post(apiEndpoint, "application/json", bytes.NewBuffer(payloadBytes))
-    if err != nil {
-        return "", fmt.Errorf("error making Ollama API request: %w", err)
+// see sends a vision request to model if set, otherwise to the models of the
+// vision role in order, skipping models that are missing, cannot see images
+// or fail. When no model of the chain can see images, the request is rerouted
+// to an installed vision-capable model.
+func see(ctx context.Context, provider llm.Provider, model, prompt string, images []string) (*llm.GenerateResponse, error) {
+    chain := []string{model}
+    if model == "" {
+        chain = llm.RoleModels(ctx, llm.RoleVision)
     }
-    defer response.Body.Close()
 
-    // Read the response
-    respBody, err := io.ReadAll(response.Body)
-    if err != nil {
-        return "", fmt.Errorf("error reading Ollama response body: %w", err)
+    var response *llm.GenerateResponse
+    attempt := func(candidate string) error {
+        if !llm.Capabilities(ctx, provider, candidate).Has(llm.CapabilityVision) {
+            return fmt.Errorf("%w: %s has no vision support", llm.ErrUnsupported, candidate)
+        }
+        var err error
+        response, err = provider.Vision(ctx, llm.VisionRequest{
+            Model:  candidate,
+            Prompt: prompt,
+            Images: images,
+        })
+        return err
+    }
+    onFallback := func(candidate string, err error) {
+        fmt.Printf("Skipping vision model %s: %v\n", candidate, err)
+    }
+    err := llm.WithFallback(ctx, provider, chain, onFallback, attempt)
+    if err == nil || !errors.Is(err, llm.ErrUnsupported) {
+        return response, err
     }
 
-    // Parse the response
-    var ollamaResponse types.OllamaResponse
-    err = json.Unmarshal(respBody, &ollamaResponse)
+    resolved, _, err := llm.ResolveModel(ctx, provider, chain[0], llm.CapabilityVision)
     if err != nil {
-        return "", fmt.Errorf("failed to decode Ollama response: %w\nResponse body: %s", err, string(respBody))
+        return nil, err
     }
+    fmt.Printf("Model %s has no vision support, using %s instead\n", chain[0], resolved)
+    if err := attempt(resolved); err != nil {
+        return nil, err
+    }
+    return response, nil
+}
 
-    return ollamaResponse.Response, nil
+// AnalyzeImagePaths reads image files, base64-encodes them, and calls AnalyzeWithImages.
+func AnalyzeImagePaths(ctx context.Context, prompt string, imagePaths []string, model string) (string, error) {
+    images := make([]string, 0, len(imagePaths))
+    for _, p := range imagePaths {
+        data, err := os.ReadFile(p)
+        if err != nil {
+            return "", fmt.Errorf("failed to read image %s: %w", p, err)
+        }
+        images = append(images, base64.StdEncoding.EncodeToString(data))
+    }
+    return AnalyzeWithImages(ctx, prompt, images, model)
 }